@@ -0,0 +1,240 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// AES-GCM-SIV needs OpenSSL 3.2
+var aead_gcm_siv_support = C.X_AEAD_GCM_SIV_SUPPORT != 0
+
+var errOpen = errors.New("openssl: message authentication failed")
+
+// AEAD implements cipher.AEAD with an OpenSSL cipher. It keeps a context for
+// sealing and one for opening, which are keyed once and reused by every call.
+// Calls sharing a context are serialized, so an AEAD is safe for concurrent
+// use.
+type AEAD struct {
+	a          *C.X_AEAD
+	name       string
+	nonce_size int
+	tag_size   int
+	seal_mtx   sync.Mutex
+	open_mtx   sync.Mutex
+}
+
+var _ cipher.AEAD = (*AEAD)(nil)
+
+// NewAESGCM returns AES-GCM with a 12 byte nonce and a 16 byte tag. The key
+// length, 16, 24 or 32 bytes, selects AES-128, AES-192 or AES-256.
+func NewAESGCM(key []byte) (*AEAD, error) {
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	return newAEAD(fmt.Sprintf("aes-%d-gcm", len(key)*8), key, 12, 16)
+}
+
+// NewChaCha20Poly1305 returns ChaCha20-Poly1305 as defined by RFC 8439, with
+// a 32 byte key. It needs OpenSSL 1.1.0.
+func NewChaCha20Poly1305(key []byte) (*AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("openssl: invalid ChaCha20-Poly1305 key "+
+			"length %d", len(key))
+	}
+	return newAEAD("chacha20-poly1305", key, 12, 16)
+}
+
+// NewAESCCM returns AES-CCM. nonce_size is between 7 and 13 bytes and
+// tag_size an even number between 4 and 16. Unlike the other modes, the
+// whole message is processed in a single pass.
+func NewAESCCM(key []byte, nonce_size, tag_size int) (*AEAD, error) {
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	if nonce_size < 7 || nonce_size > 13 {
+		return nil, fmt.Errorf("openssl: invalid CCM nonce size %d",
+			nonce_size)
+	}
+	if tag_size < 4 || tag_size > 16 || tag_size%2 != 0 {
+		return nil, fmt.Errorf("openssl: invalid CCM tag size %d", tag_size)
+	}
+	return newAEAD(fmt.Sprintf("aes-%d-ccm", len(key)*8), key, nonce_size,
+		tag_size)
+}
+
+// NewAESOCB returns AES-OCB as defined by RFC 7253, with a 12 byte nonce and
+// a 16 byte tag. It needs OpenSSL 1.1.0 built with OCB.
+func NewAESOCB(key []byte) (*AEAD, error) {
+	if err := checkAESKey(key); err != nil {
+		return nil, err
+	}
+	return newAEAD(fmt.Sprintf("aes-%d-ocb", len(key)*8), key, 12, 16)
+}
+
+// NewAESGCMSIV returns AES-GCM-SIV as defined by RFC 8452, which stays
+// secure when nonces are repeated, with a 16 or 32 byte key. It needs
+// OpenSSL 3.2.
+func NewAESGCMSIV(key []byte) (*AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, fmt.Errorf("openssl: invalid AES-GCM-SIV key length %d",
+			len(key))
+	}
+	if !aead_gcm_siv_support {
+		return nil, errors.New("openssl: AES-GCM-SIV needs OpenSSL 3.2")
+	}
+	return newAEAD(fmt.Sprintf("aes-%d-gcm-siv", len(key)*8), key, 12, 16)
+}
+
+func checkAESKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("openssl: invalid AES key length %d", len(key))
+}
+
+func newAEAD(name string, key []byte, nonce_size, tag_size int) (*AEAD,
+	error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	var res C.X_result
+	defer releaseResult(&res)
+	a := C.X_AEAD_new(cname, bytePtr(key), C.size_t(len(key)),
+		C.size_t(nonce_size), C.size_t(tag_size), &res)
+	if a == nil {
+		if res.nerrs == 0 {
+			return nil, fmt.Errorf("openssl: cipher %s is not supported",
+				name)
+		}
+		return nil, errorFromResult(&res)
+	}
+	aead := &AEAD{
+		a:          a,
+		name:       name,
+		nonce_size: nonce_size,
+		tag_size:   tag_size,
+	}
+	runtime.SetFinalizer(aead, func(aead *AEAD) { aead.Close() })
+	return aead, nil
+}
+
+// Close releases the AEAD. It must not be used afterwards.
+func (aead *AEAD) Close() {
+	if aead.a != nil {
+		C.X_AEAD_free(aead.a)
+		aead.a = nil
+	}
+}
+
+// NonceSize returns the size of the nonce passed to Seal and Open.
+func (aead *AEAD) NonceSize() int {
+	return aead.nonce_size
+}
+
+// Overhead returns the size of the tag appended by Seal.
+func (aead *AEAD) Overhead() int {
+	return aead.tag_size
+}
+
+// Seal encrypts and authenticates plaintext, authenticates additional_data
+// and appends the result to dst. To reuse plaintext's storage, pass
+// plaintext[:0] as dst; any other overlap panics.
+func (aead *AEAD) Seal(dst, nonce, plaintext, additional_data []byte) []byte {
+	if len(nonce) != aead.nonce_size {
+		panic(fmt.Sprintf("openssl: %s: incorrect nonce length %d",
+			aead.name, len(nonce)))
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+aead.tag_size)
+	if inexactOverlap(out, plaintext) {
+		panic("openssl: invalid buffer overlap")
+	}
+	aead.seal_mtx.Lock()
+	rc := C.X_AEAD_seal(aead.a, bytePtr(nonce), bytePtr(additional_data),
+		C.size_t(len(additional_data)), bytePtr(plaintext),
+		C.size_t(len(plaintext)), bytePtr(out[:len(plaintext)]),
+		bytePtr(out[len(plaintext):]))
+	aead.seal_mtx.Unlock()
+	runtime.KeepAlive(aead)
+	if rc != 1 {
+		panic(fmt.Sprintf("openssl: %s: cannot seal", aead.name))
+	}
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates additional_data
+// and, if both are authentic, appends the plaintext to dst. To reuse
+// ciphertext's storage, pass ciphertext[:0] as dst; any other overlap
+// panics. On failure dst's spare capacity is cleared.
+func (aead *AEAD) Open(dst, nonce, ciphertext, additional_data []byte) (
+	[]byte, error) {
+	if len(nonce) != aead.nonce_size {
+		panic(fmt.Sprintf("openssl: %s: incorrect nonce length %d",
+			aead.name, len(nonce)))
+	}
+	if len(ciphertext) < aead.tag_size {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-aead.tag_size:]
+	ciphertext = ciphertext[:len(ciphertext)-aead.tag_size]
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	if inexactOverlap(out, ciphertext) {
+		panic("openssl: invalid buffer overlap")
+	}
+	aead.open_mtx.Lock()
+	rc := C.X_AEAD_open(aead.a, bytePtr(nonce), bytePtr(additional_data),
+		C.size_t(len(additional_data)), bytePtr(ciphertext),
+		C.size_t(len(ciphertext)), bytePtr(out), bytePtr(tag))
+	aead.open_mtx.Unlock()
+	runtime.KeepAlive(aead)
+	if rc != 1 {
+		// don't leave unauthenticated plaintext behind
+		clear(out)
+		return nil, errOpen
+	}
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, reallocating if needed, and returns
+// the result and the n new bytes.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return head, tail
+}
+
+// inexactOverlap reports whether x and y share memory other than starting
+// at the same address, which in-place operation allows.
+func inexactOverlap(x, y []byte) bool {
+	if len(x) == 0 || len(y) == 0 || &x[0] == &y[0] {
+		return false
+	}
+	x0 := uintptr(unsafe.Pointer(&x[0]))
+	y0 := uintptr(unsafe.Pointer(&y[0]))
+	return x0 <= y0+uintptr(len(y)-1) && y0 <= x0+uintptr(len(x)-1)
+}
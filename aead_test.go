@@ -0,0 +1,395 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type aeadVector struct {
+	key, nonce, ad, plaintext, ciphertext string
+}
+
+func checkAEADVectors(t *testing.T, new func(key []byte) (*AEAD, error),
+	vectors []aeadVector) {
+	for i, v := range vectors {
+		aead, err := new(mustHex(t, v.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, ad := mustHex(t, v.nonce), mustHex(t, v.ad)
+		plaintext := mustHex(t, v.plaintext)
+		want := mustHex(t, v.ciphertext)
+		got := aead.Seal(nil, nonce, plaintext, ad)
+		if !bytes.Equal(got, want) {
+			t.Errorf("vector %d: Seal: got %x, want %x", i, got, want)
+		}
+		opened, err := aead.Open(nil, nonce, want, ad)
+		if err != nil {
+			t.Errorf("vector %d: Open: %v", i, err)
+		} else if !bytes.Equal(opened, plaintext) {
+			t.Errorf("vector %d: Open: got %x, want %x", i, opened, plaintext)
+		}
+	}
+}
+
+// checkAEAD seals and opens messages of various lengths, in place too, and
+// makes sure that any modification is detected.
+func checkAEAD(t *testing.T, aead cipher.AEAD) {
+	nonce := make([]byte, aead.NonceSize())
+	for _, size := range []int{0, 1, 15, 16, 17, 100, 1000} {
+		for _, ad_size := range []int{0, 13} {
+			plaintext := make([]byte, size)
+			ad := make([]byte, ad_size)
+			rand.Read(nonce)
+			rand.Read(plaintext)
+			rand.Read(ad)
+			prefix := []byte("prefix")
+			sealed := aead.Seal(prefix, nonce, plaintext, ad)
+			if !bytes.Equal(sealed[:len(prefix)], prefix) ||
+				len(sealed) != len(prefix)+size+aead.Overhead() {
+				t.Fatalf("%d/%d: Seal did not append", size, ad_size)
+			}
+			ciphertext := sealed[len(prefix):]
+			opened, err := aead.Open(nil, nonce, ciphertext, ad)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Fatalf("%d/%d: Open failed: %v", size, ad_size, err)
+			}
+
+			buf := make([]byte, size, size+aead.Overhead())
+			copy(buf, plaintext)
+			in_place := aead.Seal(buf[:0], nonce, buf, ad)
+			if !bytes.Equal(in_place, ciphertext) {
+				t.Fatalf("%d/%d: in place Seal differs", size, ad_size)
+			}
+			opened, err = aead.Open(in_place[:0], nonce, in_place, ad)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Fatalf("%d/%d: in place Open failed: %v", size, ad_size,
+					err)
+			}
+
+			for i := range ciphertext {
+				tampered := append([]byte(nil), ciphertext...)
+				tampered[i] ^= 0x80
+				if _, err := aead.Open(nil, nonce, tampered, ad); err == nil {
+					t.Fatalf("%d/%d: modified byte %d was accepted", size,
+						ad_size, i)
+				}
+			}
+			if ad_size > 0 {
+				ad[0] ^= 1
+				if _, err := aead.Open(nil, nonce, ciphertext, ad); err == nil {
+					t.Fatalf("%d/%d: modified data was accepted", size,
+						ad_size)
+				}
+			}
+			if _, err := aead.Open(nil, nonce,
+				ciphertext[:aead.Overhead()-1], nil); err == nil {
+				t.Fatalf("%d/%d: short ciphertext was accepted", size,
+					ad_size)
+			}
+		}
+	}
+}
+
+func TestAESGCM(t *testing.T) {
+	for _, key_size := range []int{16, 24, 32} {
+		t.Run(fmt.Sprint(key_size*8), func(t *testing.T) {
+			key := make([]byte, key_size)
+			rand.Read(key)
+			aead, err := NewAESGCM(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer aead.Close()
+			checkAEAD(t, aead)
+
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			std, err := cipher.NewGCM(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := make([]byte, 12)
+			for _, size := range []int{0, 1, 16, 33, 4096} {
+				plaintext := make([]byte, size)
+				rand.Read(nonce)
+				rand.Read(plaintext)
+				ad := plaintext[:size/2]
+				got := aead.Seal(nil, nonce, plaintext, ad)
+				want := std.Seal(nil, nonce, plaintext, ad)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%d: got %x, want %x", size, got, want)
+				}
+			}
+		})
+	}
+	if _, err := NewAESGCM(make([]byte, 20)); err == nil {
+		t.Fatal("invalid key length was accepted")
+	}
+}
+
+func TestChaCha20Poly1305(t *testing.T) {
+	// RFC 8439, section 2.8.2
+	checkAEADVectors(t, NewChaCha20Poly1305, []aeadVector{{
+		key: "808182838485868788898a8b8c8d8e8f" +
+			"909192939495969798999a9b9c9d9e9f",
+		nonce: "070000004041424344454647",
+		ad:    "50515253c0c1c2c3c4c5c6c7",
+		plaintext: fmt.Sprintf("%x", "Ladies and Gentlemen of the class "+
+			"of '99: If I could offer you only one tip for the future, "+
+			"sunscreen would be it."),
+		ciphertext: "d31a8d34648e60db7b86afbc53ef7ec2" +
+			"a4aded51296e08fea9e2b5a736ee62d6" +
+			"3dbea45e8ca9671282fafb69da92728b" +
+			"1a71de0a9e060b2905d6a5b67ecd3b36" +
+			"92ddbd7f2d778b8c9803aee328091b58" +
+			"fab324e4fad675945585808b4831d7bc" +
+			"3ff4def08e4b7a9de576d26586cec64b" +
+			"6116" +
+			"1ae10b594f09e26a7e902ecbd0600691",
+	}})
+	key := make([]byte, 32)
+	rand.Read(key)
+	aead, err := NewChaCha20Poly1305(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aead.Close()
+	checkAEAD(t, aead)
+}
+
+func TestAESCCM(t *testing.T) {
+	// NIST SP 800-38C, appendix C, examples 1 to 3
+	vectors := []struct {
+		nonce_size, tag_size int
+		aeadVector
+	}{
+		{7, 4, aeadVector{
+			key:        "404142434445464748494a4b4c4d4e4f",
+			nonce:      "10111213141516",
+			ad:         "0001020304050607",
+			plaintext:  "20212223",
+			ciphertext: "7162015b4dac255d",
+		}},
+		{8, 6, aeadVector{
+			key:        "404142434445464748494a4b4c4d4e4f",
+			nonce:      "1011121314151617",
+			ad:         "000102030405060708090a0b0c0d0e0f",
+			plaintext:  "202122232425262728292a2b2c2d2e2f",
+			ciphertext: "d2a1f0e051ea5f62081a7792073d593d1fc64fbfaccd",
+		}},
+		{12, 8, aeadVector{
+			key:   "404142434445464748494a4b4c4d4e4f",
+			nonce: "101112131415161718191a1b",
+			ad:    "000102030405060708090a0b0c0d0e0f10111213",
+			plaintext: "202122232425262728292a2b2c2d2e2f" +
+				"3031323334353637",
+			ciphertext: "e3b201a9f5b71a7a9b1ceaeccd97e70b" +
+				"6176aad9a4428aa5484392fbc1b09951",
+		}},
+	}
+	for _, v := range vectors {
+		checkAEADVectors(t, func(key []byte) (*AEAD, error) {
+			return NewAESCCM(key, v.nonce_size, v.tag_size)
+		}, []aeadVector{v.aeadVector})
+	}
+	for _, sizes := range [][2]int{{7, 4}, {12, 16}, {13, 8}} {
+		key := make([]byte, 32)
+		rand.Read(key)
+		aead, err := NewAESCCM(key, sizes[0], sizes[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkAEAD(t, aead)
+		aead.Close()
+	}
+	if _, err := NewAESCCM(make([]byte, 16), 12, 5); err == nil {
+		t.Fatal("odd tag size was accepted")
+	}
+	if _, err := NewAESCCM(make([]byte, 16), 14, 16); err == nil {
+		t.Fatal("invalid nonce size was accepted")
+	}
+}
+
+func TestAESOCB(t *testing.T) {
+	// RFC 7253, appendix A
+	checkAEADVectors(t, NewAESOCB, []aeadVector{{
+		key:        "000102030405060708090a0b0c0d0e0f",
+		nonce:      "bbaa99887766554433221100",
+		ciphertext: "785407bfffc8ad9edcc5520ac9111ee6",
+	}, {
+		key:        "000102030405060708090a0b0c0d0e0f",
+		nonce:      "bbaa99887766554433221101",
+		ad:         "0001020304050607",
+		plaintext:  "0001020304050607",
+		ciphertext: "6820b3657b6f615a5725bda0d3b4eb3a257c9af1f8f03009",
+	}})
+	key := make([]byte, 16)
+	rand.Read(key)
+	aead, err := NewAESOCB(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aead.Close()
+	checkAEAD(t, aead)
+}
+
+func TestAESGCMSIV(t *testing.T) {
+	if !aead_gcm_siv_support {
+		if _, err := NewAESGCMSIV(make([]byte, 16)); err == nil {
+			t.Fatal("AES-GCM-SIV should not be supported")
+		}
+		t.Skip("AES-GCM-SIV needs OpenSSL 3.2")
+	}
+	// RFC 8452, appendix C.1 and C.2
+	checkAEADVectors(t, NewAESGCMSIV, []aeadVector{{
+		key:        "01000000000000000000000000000000",
+		nonce:      "030000000000000000000000",
+		ciphertext: "dc20e2d83f25705bb49e439eca56de25",
+	}, {
+		key: "01000000000000000000000000000000" +
+			"00000000000000000000000000000000",
+		nonce:      "030000000000000000000000",
+		ciphertext: "07f5f4169bbf55a8400cd47ea6fd400f",
+	}})
+	key := make([]byte, 32)
+	rand.Read(key)
+	aead, err := NewAESGCMSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aead.Close()
+	checkAEAD(t, aead)
+}
+
+func TestAEADConcurrent(t *testing.T) {
+	aead, err := NewAESGCM(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aead.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce := make([]byte, 12)
+			plaintext := make([]byte, 100)
+			for j := 0; j < 100; j++ {
+				rand.Read(nonce)
+				rand.Read(plaintext)
+				sealed := aead.Seal(nil, nonce, plaintext, nonce)
+				opened, err := aead.Open(nil, nonce, sealed, nonce)
+				if err != nil || !bytes.Equal(opened, plaintext) {
+					t.Errorf("round trip failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAEADOverlap(t *testing.T) {
+	aead, err := NewAESGCM(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aead.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("inexact overlap did not panic")
+		}
+	}()
+	buf := make([]byte, 64)
+	aead.Seal(buf[1:1], make([]byte, 12), buf[:32], nil)
+}
+
+func benchmarkAEADSeal(b *testing.B, aead cipher.AEAD, size int) {
+	b.SetBytes(int64(size))
+	nonce := make([]byte, aead.NonceSize())
+	buf := make([]byte, size, size+aead.Overhead())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aead.Seal(buf[:0], nonce, buf[:size], nonce)
+	}
+}
+
+func benchmarkAEADOpen(b *testing.B, aead cipher.AEAD, size int) {
+	b.SetBytes(int64(size))
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nil, nonce, make([]byte, size), nonce)
+	out := make([]byte, size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := aead.Open(out[:0], nonce, sealed, nonce); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newAESGCMOpenSSL(b *testing.B) cipher.AEAD {
+	aead, err := NewAESGCM(make([]byte, 16))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return aead
+}
+
+func newAESGCMStdlib(b *testing.B) cipher.AEAD {
+	block, err := aes.NewCipher(make([]byte, 16))
+	if err != nil {
+		b.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return aead
+}
+
+func BenchmarkAESGCMSealSmall_openssl(b *testing.B) {
+	benchmarkAEADSeal(b, newAESGCMOpenSSL(b), 64)
+}
+func BenchmarkAESGCMSealSmall_stdlib(b *testing.B) {
+	benchmarkAEADSeal(b, newAESGCMStdlib(b), 64)
+}
+func BenchmarkAESGCMSealLarge_openssl(b *testing.B) {
+	benchmarkAEADSeal(b, newAESGCMOpenSSL(b), 16*1024)
+}
+func BenchmarkAESGCMSealLarge_stdlib(b *testing.B) {
+	benchmarkAEADSeal(b, newAESGCMStdlib(b), 16*1024)
+}
+func BenchmarkAESGCMOpenSmall_openssl(b *testing.B) {
+	benchmarkAEADOpen(b, newAESGCMOpenSSL(b), 64)
+}
+func BenchmarkAESGCMOpenSmall_stdlib(b *testing.B) {
+	benchmarkAEADOpen(b, newAESGCMStdlib(b), 64)
+}
+func BenchmarkAESGCMOpenLarge_openssl(b *testing.B) {
+	benchmarkAEADOpen(b, newAESGCMOpenSSL(b), 16*1024)
+}
+func BenchmarkAESGCMOpenLarge_stdlib(b *testing.B) {
+	benchmarkAEADOpen(b, newAESGCMStdlib(b), 16*1024)
+}
@@ -0,0 +1,229 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+func init() {
+	if C.X_handshake_info_init() != 0 {
+		panic("openssl: failed to allocate handshake ex data indexes")
+	}
+}
+
+// AlertLevel is the level of a TLS alert.
+type AlertLevel int
+
+const (
+	AlertWarning AlertLevel = 1
+	AlertFatal   AlertLevel = 2
+)
+
+func (l AlertLevel) String() string {
+	switch l {
+	case AlertWarning:
+		return "warning"
+	case AlertFatal:
+		return "fatal"
+	}
+	return fmt.Sprintf("AlertLevel(%d)", int(l))
+}
+
+// AlertDescription is the description of a TLS alert, as defined by RFC 8446.
+type AlertDescription int
+
+const (
+	AlertCloseNotify            AlertDescription = 0
+	AlertUnexpectedMessage      AlertDescription = 10
+	AlertBadRecordMAC           AlertDescription = 20
+	AlertRecordOverflow         AlertDescription = 22
+	AlertHandshakeFailure       AlertDescription = 40
+	AlertBadCertificate         AlertDescription = 42
+	AlertUnsupportedCertificate AlertDescription = 43
+	AlertCertificateRevoked     AlertDescription = 44
+	AlertCertificateExpired     AlertDescription = 45
+	AlertCertificateUnknown     AlertDescription = 46
+	AlertIllegalParameter       AlertDescription = 47
+	AlertUnknownCA              AlertDescription = 48
+	AlertAccessDenied           AlertDescription = 49
+	AlertDecodeError            AlertDescription = 50
+	AlertDecryptError           AlertDescription = 51
+	AlertProtocolVersion        AlertDescription = 70
+	AlertInsufficientSecurity   AlertDescription = 71
+	AlertInternalError          AlertDescription = 80
+	AlertInappropriateFallback  AlertDescription = 86
+	AlertUserCanceled           AlertDescription = 90
+	AlertMissingExtension       AlertDescription = 109
+	AlertUnsupportedExtension   AlertDescription = 110
+	AlertUnrecognizedName       AlertDescription = 112
+	AlertUnknownPSKIdentity     AlertDescription = 115
+	AlertCertificateRequired    AlertDescription = 116
+	AlertNoApplicationProtocol  AlertDescription = 120
+)
+
+func (d AlertDescription) String() string {
+	return C.GoString(C.SSL_alert_desc_string_long(C.int(d)))
+}
+
+// AlertError is a fatal TLS alert that ended a connection, either sent to
+// the peer because of a local failure or received from it.
+type AlertError struct {
+	Level       AlertLevel
+	Description AlertDescription
+	// Sent is true if the alert was sent to the peer, false if the peer
+	// sent it.
+	Sent bool
+	// Err is the OpenSSL error of the failed call, if any.
+	Err error
+}
+
+func (e *AlertError) Error() string {
+	direction := "received"
+	if e.Sent {
+		direction = "sent"
+	}
+	return fmt.Sprintf("openssl: %s %s alert: %s", direction, e.Level,
+		e.Description)
+}
+
+func (e *AlertError) Unwrap() error {
+	return e.Err
+}
+
+// HandshakeCause classifies why a handshake failed.
+type HandshakeCause int
+
+const (
+	// HandshakeCauseOther is any failure not covered below.
+	HandshakeCauseOther HandshakeCause = iota
+	// HandshakeCauseTransport means the underlying connection failed or was
+	// closed, or timed out.
+	HandshakeCauseTransport
+	// HandshakeCauseVersion means the peers have no protocol version in
+	// common.
+	HandshakeCauseVersion
+	// HandshakeCauseNoSharedCipher means the peers could not agree on the
+	// handshake parameters, usually the cipher suite.
+	HandshakeCauseNoSharedCipher
+	// HandshakeCauseCertificate means a certificate was rejected, by either
+	// peer, or was required but not sent.
+	HandshakeCauseCertificate
+)
+
+func (c HandshakeCause) String() string {
+	switch c {
+	case HandshakeCauseOther:
+		return "other"
+	case HandshakeCauseTransport:
+		return "transport"
+	case HandshakeCauseVersion:
+		return "version"
+	case HandshakeCauseNoSharedCipher:
+		return "no_shared_cipher"
+	case HandshakeCauseCertificate:
+		return "certificate"
+	}
+	return fmt.Sprintf("HandshakeCause(%d)", int(c))
+}
+
+// HandshakeError is returned by Conn.Handshake when the handshake fails.
+type HandshakeError struct {
+	Cause HandshakeCause
+	// State is the last handshake state entered, as reported by
+	// SSL_state_string_long, or empty if the handshake did not start.
+	State string
+	// Err is an *AlertError if an alert ended the handshake, otherwise the
+	// OpenSSL or transport error.
+	Err error
+}
+
+func (e *HandshakeError) Error() string {
+	if e.State == "" {
+		return fmt.Sprintf("openssl: handshake failed (%s): %v", e.Cause,
+			e.Err)
+	}
+	return fmt.Sprintf("openssl: handshake failed in %q (%s): %v", e.State,
+		e.Cause, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the handshake failed because of a deadline, so a
+// *HandshakeError keeps satisfying net.Error like the transport error.
+func (e *HandshakeError) Timeout() bool {
+	var nerr net.Error
+	return errors.As(e.Err, &nerr) && nerr.Timeout()
+}
+
+// Temporary is part of net.Error, see Timeout.
+func (e *HandshakeError) Temporary() bool {
+	var nerr interface{ Temporary() bool }
+	return errors.As(e.Err, &nerr) && nerr.Temporary()
+}
+
+// handshakeError wraps the error of a failed handshake. c.mtx must not be
+// held.
+func (c *Conn) handshakeError(err error) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	herr := &HandshakeError{Err: err}
+	if state := C.X_SSL_get_handshake_state(c.ssl); state != nil {
+		herr.State = C.GoString(state)
+	}
+	// reaching EOF closes the connection, which fails in OpenSSL when the
+	// handshake is not done, so that error says little about the cause
+	var ossl_err *Error
+	if !errors.As(err, &ossl_err) || c.is_eof {
+		herr.Cause = HandshakeCauseTransport
+	}
+	for _, sent := range []bool{false, true} {
+		alert := C.X_SSL_get_fatal_alert(c.ssl, cBool(sent))
+		if alert == 0 {
+			continue
+		}
+		aerr := &AlertError{
+			Level:       AlertLevel(alert >> 8),
+			Description: AlertDescription(alert & 0xff),
+			Sent:        sent,
+			Err:         err,
+		}
+		herr.Err = aerr
+		herr.Cause = alertCause(aerr.Description)
+		break
+	}
+	return herr
+}
+
+func alertCause(desc AlertDescription) HandshakeCause {
+	switch desc {
+	case AlertProtocolVersion, AlertInappropriateFallback:
+		return HandshakeCauseVersion
+	case AlertHandshakeFailure, AlertInsufficientSecurity:
+		return HandshakeCauseNoSharedCipher
+	case AlertBadCertificate, AlertUnsupportedCertificate,
+		AlertCertificateRevoked, AlertCertificateExpired,
+		AlertCertificateUnknown, AlertUnknownCA, AlertCertificateRequired:
+		return HandshakeCauseCertificate
+	}
+	return HandshakeCauseOther
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"testing"
+)
+
+func checkHandshakeError(t *testing.T, err error, cause HandshakeCause,
+	desc AlertDescription, sent bool) {
+	t.Helper()
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		t.Fatalf("expected *HandshakeError, got %v", err)
+	}
+	if herr.Cause != cause {
+		t.Fatalf("expected cause %s, got %s: %v", cause, herr.Cause, err)
+	}
+	if herr.State == "" {
+		t.Fatalf("no handshake state: %v", err)
+	}
+	var aerr *AlertError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("expected *AlertError, got %v", err)
+	}
+	if aerr.Level != AlertFatal || aerr.Description != desc ||
+		aerr.Sent != sent {
+		t.Fatalf("unexpected alert: %v", aerr)
+	}
+}
+
+func TestHandshakeErrorVersion(t *testing.T) {
+	server_ctx := newTestCtx(t, TLSv1_2, true)
+	// the client only offers TLS 1.3
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	client_ctx.SetOptions(NoTLSv12)
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	checkHandshakeError(t, err, HandshakeCauseVersion,
+		AlertProtocolVersion, false)
+}
+
+func TestHandshakeErrorNoSharedCipher(t *testing.T) {
+	server_ctx := newTestCtx(t, TLSv1_2, true)
+	if err := server_ctx.SetCipherList("AES128-SHA"); err != nil {
+		t.Fatal(err)
+	}
+	client_ctx := newTestCtx(t, TLSv1_2, false)
+	if err := client_ctx.SetCipherList("AES256-SHA"); err != nil {
+		t.Fatal(err)
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	errs := make(chan error, 1)
+	go func() { errs <- client.Handshake() }()
+	checkHandshakeError(t, server.Handshake(), HandshakeCauseNoSharedCipher,
+		AlertHandshakeFailure, true)
+	checkHandshakeError(t, <-errs, HandshakeCauseNoSharedCipher,
+		AlertHandshakeFailure, false)
+}
+
+func TestHandshakeErrorCertificate(t *testing.T) {
+	server_ctx := newTestCtx(t, AnyVersion, true)
+	// the self-signed server certificate is not trusted
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	client_ctx.SetVerifyMode(VerifyPeer)
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	var herr *HandshakeError
+	if !errors.As(err, &herr) || herr.Cause != HandshakeCauseCertificate {
+		t.Fatalf("expected a certificate failure, got %v", err)
+	}
+	var aerr *AlertError
+	if !errors.As(err, &aerr) || !aerr.Sent {
+		t.Fatalf("expected a sent alert, got %v", err)
+	}
+}
+
+func TestHandshakeErrorTransport(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, newTestCtx(t, AnyVersion, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client_conn.Close()
+
+	err = server.Handshake()
+	var herr *HandshakeError
+	if !errors.As(err, &herr) || herr.Cause != HandshakeCauseTransport {
+		t.Fatalf("expected a transport failure, got %v", err)
+	}
+	var aerr *AlertError
+	if errors.As(err, &aerr) {
+		t.Fatalf("unexpected alert %v", aerr)
+	}
+}
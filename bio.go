@@ -277,6 +277,13 @@ func (b *readBio) MarkEOF() {
 	b.eof = true
 }
 
+// IsEOF reports whether the reader hit EOF and all buffered data was consumed.
+func (b *readBio) IsEOF() bool {
+	b.data_mtx.Lock()
+	defer b.data_mtx.Unlock()
+	return b.eof && len(b.buf) == 0
+}
+
 type anyBio C.BIO
 
 func asAnyBio(b *C.BIO) *anyBio { return (*anyBio)(b) }
@@ -267,6 +267,16 @@ func (c *Certificate) SetExpireDate(when time.Duration) error {
 	return nil
 }
 
+// GetIssueDate returns the start of the certificate's validity period.
+func (c *Certificate) GetIssueDate() (time.Time, error) {
+	return asn1TimeToTime(C.X_X509_get0_notBefore(c.x))
+}
+
+// GetExpireDate returns the end of the certificate's validity period.
+func (c *Certificate) GetExpireDate() (time.Time, error) {
+	return asn1TimeToTime(C.X_X509_get0_notAfter(c.x))
+}
+
 // SetPubKey assigns a new public key to a certificate.
 func (c *Certificate) SetPubKey(pubKey PublicKey) error {
 	c.pubKey = pubKey
@@ -336,7 +346,7 @@ func (c *Certificate) AddExtension(nid NID, value string) error {
 		issuer = c.Issuer
 	}
 	var ctx C.X509V3_CTX
-	C.X509V3_set_ctx(&ctx, c.x, issuer.x, nil, nil, 0)
+	C.X509V3_set_ctx(&ctx, issuer.x, c.x, nil, nil, 0)
 	ex := C.X509V3_EXT_conf_nid(nil, &ctx, C.int(nid), C.CString(value))
 	if ex == nil {
 		return errors.New("failed to create x509v3 extension")
@@ -364,14 +374,12 @@ func LoadCertificateFromPEM(pem_block []byte) (*Certificate, error) {
 	if len(pem_block) == 0 {
 		return nil, errors.New("empty pem block")
 	}
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
-		C.int(len(pem_block)))
-	cert := C.PEM_read_bio_X509(bio, nil, nil, nil)
-	C.BIO_free(bio)
+	var res C.X_result
+	defer releaseResult(&res)
+	cert := C.X_PEM_read_X509(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)), &res)
 	if cert == nil {
-		return nil, errorFromErrorQueue()
+		return nil, errorFromResult(&res)
 	}
 	x := &Certificate{x: cert}
 	runtime.SetFinalizer(x, func(x *Certificate) {
@@ -385,14 +393,12 @@ func LoadCertificateFromDER(der_block []byte) (*Certificate, error) {
 	if len(der_block) == 0 {
 		return nil, errors.New("empty der block")
 	}
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
-		C.int(len(der_block)))
-	cert := C.d2i_X509_bio(bio, nil)
-	C.BIO_free(bio)
+	var res C.X_result
+	defer releaseResult(&res)
+	cert := C.X_d2i_X509(unsafe.Pointer(&der_block[0]),
+		C.int(len(der_block)), &res)
 	if cert == nil {
-		return nil, errorFromErrorQueue()
+		return nil, errorFromResult(&res)
 	}
 	x := &Certificate{x: cert}
 	runtime.SetFinalizer(x, func(x *Certificate) {
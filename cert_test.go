@@ -15,6 +15,9 @@
 package openssl
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
 	"math/big"
 	"testing"
 	"time"
@@ -101,6 +104,82 @@ func TestCAGenerate(t *testing.T) {
 	}
 }
 
+func TestCertAuthorityKeyIdentifier(t *testing.T) {
+	cakey, err := GenerateRSAKey(768)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &CertificateInfo{
+		Serial:       big.NewInt(int64(1)),
+		Issued:       0,
+		Expires:      24 * time.Hour,
+		Country:      "US",
+		Organization: "Test CA",
+		CommonName:   "CA",
+	}
+	ca, err := NewCertificate(info, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.AddExtension(NID_subject_key_identifier, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	key, err := GenerateRSAKey(768)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Organization = "Test"
+	info.CommonName = "localhost"
+	cert, err := NewCertificate(info, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetVersion(X509_V3); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuer(ca); err != nil {
+		t.Fatal(err)
+	}
+	// keyid:always fails unless the issuer's subject key identifier is
+	// found, which requires the issuer to be passed as the issuer.
+	if err := cert.AddExtension(NID_authority_key_identifier,
+		"keyid:always"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(cakey, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	parse := func(c *Certificate) *x509.Certificate {
+		data, err := c.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatal("failed to decode certificate PEM")
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	ca_parsed, cert_parsed := parse(ca), parse(cert)
+	if len(ca_parsed.SubjectKeyId) == 0 {
+		t.Fatal("CA has no subject key identifier")
+	}
+	if !bytes.Equal(cert_parsed.AuthorityKeyId, ca_parsed.SubjectKeyId) {
+		t.Fatalf("authority key identifier %x, want %x",
+			cert_parsed.AuthorityKeyId, ca_parsed.SubjectKeyId)
+	}
+}
+
 func TestCertGetNameEntry(t *testing.T) {
 	key, err := GenerateRSAKey(768)
 	if err != nil {
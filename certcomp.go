@@ -0,0 +1,151 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	cert_comp_support = C.X_CERT_COMP_SUPPORT != 0
+	// ex data index flagging a received compressed certificate, owned by C
+	cert_comp_idx = C.X_cert_comp_init()
+)
+
+// CertCompressionAlgorithm is a certificate compression algorithm as defined
+// by RFC 8879.
+type CertCompressionAlgorithm int
+
+const (
+	CertCompressionZlib   CertCompressionAlgorithm = C.TLSEXT_comp_cert_zlib
+	CertCompressionBrotli CertCompressionAlgorithm = C.TLSEXT_comp_cert_brotli
+	CertCompressionZstd   CertCompressionAlgorithm = C.TLSEXT_comp_cert_zstd
+)
+
+func (a CertCompressionAlgorithm) String() string {
+	switch a {
+	case CertCompressionZlib:
+		return "zlib"
+	case CertCompressionBrotli:
+		return "brotli"
+	case CertCompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("CertCompressionAlgorithm(%d)", int(a))
+}
+
+var cert_comp_algs struct {
+	once sync.Once
+	algs []CertCompressionAlgorithm
+}
+
+// CertCompressionAlgorithms returns the certificate compression algorithms
+// available at runtime. It is empty if OpenSSL is older than 3.2 or was built
+// without any of the compression libraries.
+func CertCompressionAlgorithms() []CertCompressionAlgorithm {
+	cert_comp_algs.once.Do(func() {
+		if !cert_comp_support {
+			return
+		}
+		for _, alg := range []CertCompressionAlgorithm{
+			CertCompressionZlib, CertCompressionBrotli, CertCompressionZstd} {
+			if C.X_cert_comp_available(C.int(alg)) == 1 {
+				cert_comp_algs.algs = append(cert_comp_algs.algs, alg)
+			}
+		}
+	})
+	return append([]CertCompressionAlgorithm(nil), cert_comp_algs.algs...)
+}
+
+// Available reports whether the algorithm can be used at runtime.
+func (a CertCompressionAlgorithm) Available() bool {
+	for _, alg := range CertCompressionAlgorithms() {
+		if alg == a {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCertCompression enables certificate compression with the given
+// algorithms in order of preference, both for certificates sent to and
+// received from the peer. Algorithms left out are not used. Without
+// arguments, certificate compression is disabled. Compression only applies
+// to TLS 1.3 connections. Requires OpenSSL 3.2 or later.
+// See https://www.openssl.org/docs/man3.2/man3/SSL_CTX_set1_cert_comp_preference.html
+func (c *Ctx) SetCertCompression(algs ...CertCompressionAlgorithm) error {
+	if !cert_comp_support {
+		return errors.New("certificate compression requires OpenSSL 3.2")
+	}
+	c_algs := make([]C.int, len(algs)+1)
+	for i, alg := range algs {
+		if !alg.Available() {
+			return fmt.Errorf("certificate compression algorithm %s is not "+
+				"available", alg)
+		}
+		c_algs[i] = C.int(alg)
+	}
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_CTX_set_cert_comp(c.ctx, &c_algs[0], C.size_t(len(algs)),
+		&res) != 1 {
+		return errorFromResult(&res)
+	}
+	C.X_SSL_CTX_track_cert_comp(c.ctx)
+	return nil
+}
+
+// CompressCertificates compresses the configured certificate chains ahead of
+// time with the given algorithms, or with all enabled ones if none are given,
+// so the work is not repeated in every handshake. It has to be called again
+// after the certificates change. Requires OpenSSL 3.2 or later.
+func (c *Ctx) CompressCertificates(algs ...CertCompressionAlgorithm) error {
+	if !cert_comp_support {
+		return errors.New("certificate compression requires OpenSSL 3.2")
+	}
+	if len(algs) == 0 {
+		// 0 compresses with every algorithm in the preference list
+		algs = []CertCompressionAlgorithm{0}
+	}
+	for _, alg := range algs {
+		var res C.X_result
+		if C.X_SSL_CTX_compress_certs(c.ctx, C.int(alg), &res) != 1 {
+			err := errorFromResult(&res)
+			releaseResult(&res)
+			return err
+		}
+		releaseResult(&res)
+	}
+	C.X_SSL_CTX_track_cert_comp(c.ctx)
+	return nil
+}
+
+// PeerCertificateCompressed reports whether the certificate the peer sent
+// during the handshake arrived compressed. It is only tracked on contexts
+// configured with SetCertCompression or CompressCertificates, and is false
+// otherwise.
+func (c *Conn) PeerCertificateCompressed() bool {
+	if !cert_comp_support {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return C.X_SSL_peer_cert_compressed(c.ssl) == 1
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"testing"
+)
+
+func newCertCompressionCtx(t *testing.T, server bool,
+	algs ...CertCompressionAlgorithm) *Ctx {
+	ctx := newTestCtx(t, AnyVersion, server)
+	if err := ctx.SetCertCompression(algs...); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestCertCompression(t *testing.T) {
+	algs := CertCompressionAlgorithms()
+	if len(algs) == 0 {
+		t.Skip("certificate compression is not available")
+	}
+	for _, alg := range algs {
+		server_ctx := newCertCompressionCtx(t, true, alg)
+		if err := server_ctx.CompressCertificates(alg); err != nil {
+			t.Fatal(err)
+		}
+		client_ctx := newCertCompressionCtx(t, false, alg)
+		server, client, err := handshakePair(t, server_ctx, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !client.PeerCertificateCompressed() {
+			t.Fatalf("%s: expected a compressed certificate", alg)
+		}
+		if server.PeerCertificateCompressed() {
+			t.Fatalf("%s: server got a certificate from the client", alg)
+		}
+		close_both(server, client)
+
+		// a client without compression gets the plain certificate
+		client_ctx = newCertCompressionCtx(t, false)
+		server, client, err = handshakePair(t, server_ctx, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.PeerCertificateCompressed() {
+			t.Fatalf("%s: expected an uncompressed certificate", alg)
+		}
+		close_both(server, client)
+	}
+}
+
+func TestCertCompressionUnavailable(t *testing.T) {
+	if cert_comp_support {
+		t.Skip("certificate compression is supported")
+	}
+	if algs := CertCompressionAlgorithms(); len(algs) != 0 {
+		t.Fatalf("unexpected algorithms %v", algs)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetCertCompression(CertCompressionZlib); err == nil {
+		t.Fatal("expected an error without certificate compression support")
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// CipherSuite describes a TLS cipher suite as OpenSSL implements it. The
+// KeyExchange, Auth, Encryption and MAC algorithms are named like OpenSSL's
+// objects, such as "ECDHE", "RSA", "aes-128-gcm" and "SHA256"; they are
+// empty with OpenSSL before 1.1.0.
+type CipherSuite struct {
+	// Name is the OpenSSL name, as used in cipher lists.
+	Name string
+	// IANAName is the standard name, such as
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". It is empty with OpenSSL
+	// before 1.1.1.
+	IANAName string
+	// ID is the IANA id, which is also the value of the matching crypto/tls
+	// cipher suite constant.
+	ID uint16
+	// Version is the protocol version the suite was introduced with, such as
+	// "TLSv1.2".
+	Version     string
+	KeyExchange string
+	Auth        string
+	Encryption  string
+	// MAC is "AEAD" for suites using authenticated encryption.
+	MAC string
+	// Bits is the strength of the encryption in bits.
+	Bits int
+}
+
+func nidShortName(nid C.int, prefix string) string {
+	if nid == C.NID_undef {
+		return ""
+	}
+	return strings.TrimPrefix(C.GoString(C.OBJ_nid2sn(nid)), prefix)
+}
+
+func newCipherSuite(c *C.SSL_CIPHER) *CipherSuite {
+	s := &CipherSuite{
+		Name:        C.GoString(C.SSL_CIPHER_get_name(c)),
+		ID:          uint16(C.X_SSL_CIPHER_get_protocol_id(c)),
+		Version:     C.GoString(C.SSL_CIPHER_get_version(c)),
+		KeyExchange: nidShortName(C.X_SSL_CIPHER_get_kx_nid(c), "Kx"),
+		Auth:        nidShortName(C.X_SSL_CIPHER_get_auth_nid(c), "Auth"),
+		MAC:         nidShortName(C.X_SSL_CIPHER_get_digest_nid(c), ""),
+		Bits:        int(C.SSL_CIPHER_get_bits(c, nil))}
+	if name := C.X_SSL_CIPHER_standard_name(c); name != nil {
+		s.IANAName = C.GoString(name)
+	}
+	if nid := C.X_SSL_CIPHER_get_cipher_nid(c); nid != C.NID_undef {
+		s.Encryption = C.GoString(C.OBJ_nid2ln(nid))
+	}
+	if C.X_SSL_CIPHER_is_aead(c) != 0 {
+		s.MAC = "AEAD"
+	}
+	return s
+}
+
+// cipherSuites returns the suites ssl may negotiate, in order of preference.
+// Before OpenSSL 1.0.2 these are all suites of the cipher list, including
+// those the enabled protocol versions rule out.
+func cipherSuites(ssl *C.SSL) []CipherSuite {
+	sk := C.X_SSL_get1_supported_ciphers(ssl)
+	if sk == nil {
+		return nil
+	}
+	defer C.X_sk_SSL_CIPHER_free(sk)
+	num := int(C.X_sk_SSL_CIPHER_num(sk))
+	suites := make([]CipherSuite, 0, num)
+	for i := 0; i < num; i++ {
+		suites = append(suites, *newCipherSuite(C.X_sk_SSL_CIPHER_value(sk,
+			C.int(i))))
+	}
+	return suites
+}
+
+// Ciphers returns the cipher suites the context's connections can negotiate,
+// in order of preference: those of the cipher list and, with TLSv1.3, the
+// TLSv1.3 cipher suites, less the ones that the enabled protocol versions or
+// the security level rule out.
+func (c *Ctx) Ciphers() ([]CipherSuite, error) {
+	ssl, err := newSSL(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer C.SSL_free(ssl)
+	return cipherSuites(ssl), nil
+}
+
+// Ciphers returns the cipher suites the connection can negotiate, in order
+// of preference, see Ctx.Ciphers.
+func (c *Conn) Ciphers() []CipherSuite {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return cipherSuites(c.ssl)
+}
+
+// CurrentCipherSuite returns the cipher suite negotiated on the connection.
+func (c *Conn) CurrentCipherSuite() (*CipherSuite, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	cipher := C.SSL_get_current_cipher(c.ssl)
+	if cipher == nil {
+		return nil, errors.New("Session not established")
+	}
+	return newCipherSuite(cipher), nil
+}
+
+// cipher_lookup holds the connection LookupCipherSuite searches, the search
+// only depends on its method. It is never freed.
+var cipher_lookup struct {
+	once sync.Once
+	ctx  *Ctx
+	ssl  *C.SSL
+	err  error
+}
+
+// LookupCipherSuite returns the cipher suite OpenSSL implements for the IANA
+// id, such as a crypto/tls cipher suite constant, whether or not it is
+// enabled anywhere. Requires OpenSSL 1.0.2 or later.
+func LookupCipherSuite(id uint16) (*CipherSuite, error) {
+	cipher_lookup.once.Do(func() {
+		cipher_lookup.ctx, cipher_lookup.err = NewCtx()
+		if cipher_lookup.err != nil {
+			return
+		}
+		cipher_lookup.ssl, cipher_lookup.err = newSSL(cipher_lookup.ctx.ctx)
+	})
+	if cipher_lookup.err != nil {
+		return nil, cipher_lookup.err
+	}
+	cipher := C.X_SSL_CIPHER_find(cipher_lookup.ssl, C.int(id))
+	if cipher == nil {
+		return nil, fmt.Errorf("unknown cipher suite 0x%04x", id)
+	}
+	return newCipherSuite(cipher), nil
+}
+
+// TLSCipherList returns the cipher list, for SetCipherList, that enables the
+// given crypto/tls cipher suites in the given order. TLSv1.3 suites are not
+// configured by cipher lists and are rejected.
+func TLSCipherList(ids []uint16) (string, error) {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		suite, err := LookupCipherSuite(id)
+		if err != nil {
+			return "", err
+		}
+		if suite.Version == "TLSv1.3" {
+			return "", fmt.Errorf("%s is a TLSv1.3 cipher suite", suite.Name)
+		}
+		names = append(names, suite.Name)
+	}
+	return strings.Join(names, ":"), nil
+}
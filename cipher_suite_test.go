@@ -0,0 +1,154 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/tls"
+	"sync"
+	"testing"
+)
+
+func TestCtxCiphers(t *testing.T) {
+	ctx, err := NewCtxWithVersion(TLSv1_2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.SetCipherList("ECDHE-RSA-AES128-GCM-SHA256:AES128-SHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites, err := ctx.Ciphers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, suite := range suites {
+		names = append(names, suite.Name)
+	}
+	if len(suites) != 2 {
+		t.Fatalf("expected 2 cipher suites, got %v", names)
+	}
+
+	suite := suites[0]
+	if suite.Name != "ECDHE-RSA-AES128-GCM-SHA256" ||
+		suite.ID != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ||
+		suite.Version != "TLSv1.2" || suite.Bits != 128 {
+		t.Fatalf("unexpected cipher suite %+v", suite)
+	}
+	if suite.IANAName != "" &&
+		suite.IANAName != "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" {
+		t.Fatalf("unexpected IANA name %q", suite.IANAName)
+	}
+	if suite.KeyExchange != "" && (suite.KeyExchange != "ECDHE" ||
+		suite.Auth != "RSA" || suite.Encryption != "aes-128-gcm" ||
+		suite.MAC != "AEAD") {
+		t.Fatalf("unexpected algorithms %+v", suite)
+	}
+	suite = suites[1]
+	if suite.ID != tls.TLS_RSA_WITH_AES_128_CBC_SHA {
+		t.Fatalf("unexpected cipher suite %+v", suite)
+	}
+	if suite.KeyExchange != "" && (suite.KeyExchange != "RSA" ||
+		suite.Encryption != "aes-128-cbc" || suite.MAC != "SHA1") {
+		t.Fatalf("unexpected algorithms %+v", suite)
+	}
+}
+
+func TestConnCurrentCipherSuite(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	for _, conn := range []HandshakingConn{server, client} {
+		name, err := conn.(*Conn).CurrentCipher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		suite, err := conn.(*Conn).CurrentCipherSuite()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suite.Name != name {
+			t.Fatalf("current cipher suite is %s, expected %s", suite.Name,
+				name)
+		}
+		found := false
+		for _, enabled := range conn.(*Conn).Ciphers() {
+			found = found || enabled == *suite
+		}
+		if !found {
+			t.Fatalf("current cipher suite %s is not enabled", name)
+		}
+	}
+}
+
+func TestTLSCipherList(t *testing.T) {
+	list, err := TLSCipherList([]uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-CHACHA20-POLY1305:" +
+		"AES128-SHA"
+	if list != expected {
+		t.Fatalf("got cipher list %q, expected %q", list, expected)
+	}
+
+	_, err = TLSCipherList([]uint16{tls.TLS_AES_128_GCM_SHA256})
+	if err == nil {
+		t.Fatal("expected a TLSv1.3 cipher suite to fail")
+	}
+	_, err = TLSCipherList([]uint16{0xfefe})
+	if err == nil {
+		t.Fatal("expected an unknown cipher suite to fail")
+	}
+}
+
+func TestLookupCipherSuiteConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, suite := range tls.CipherSuites() {
+				got, err := LookupCipherSuite(suite.ID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got.ID != suite.ID {
+					t.Errorf("looked up 0x%04x, got 0x%04x", suite.ID, got.ID)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkLookupCipherSuite(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := LookupCipherSuite(
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"net"
 	"runtime"
 	"sync"
+	"syscall"
 	"time"
 	"unsafe"
 
@@ -35,6 +36,13 @@ var (
 	wantRead   = errors.New("want read")
 	wantWrite  = errors.New("want write")
 	tryAgain   = errors.New("try again")
+
+	// ErrTruncated is returned by Read when the underlying connection reached
+	// EOF before the peer sent a close_notify alert and the context was
+	// configured with SetReportTruncation.
+	ErrTruncated = errors.New("openssl: connection truncated without close_notify")
+
+	negotiated_group_support = C.X_NEGOTIATED_GROUP_SUPPORT != 0
 )
 
 type Conn struct {
@@ -45,8 +53,21 @@ type Conn struct {
 	into_ssl         *readBio
 	from_ssl         *writeBio
 	is_shutdown      bool
+	is_write_closed  bool
+	is_truncated     bool
+	is_eof           bool
 	mtx              sync.Mutex
 	want_read_future *utils.Future
+
+	// raw is set when OpenSSL does the socket I/O itself, see newSocketConn
+	raw syscall.RawConn
+
+	// metrics is the Metrics of ctx when the connection was created, see
+	// handshakeFinished and ioDone
+	metrics        Metrics
+	hs_start       time.Time
+	hs_reported    bool
+	renegotiations int
 }
 
 type VerifyResult int
@@ -119,11 +140,11 @@ func (e VerifyError) Error() string {
 }
 
 func newSSL(ctx *C.SSL_CTX) (*C.SSL, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	ssl := C.SSL_new(ctx)
+	var res C.X_result
+	defer releaseResult(&res)
+	ssl := C.X_SSL_new(ctx, &res)
 	if ssl == nil {
-		return nil, errorFromErrorQueue()
+		return nil, errorFromResult(&res)
 	}
 	return ssl, nil
 }
@@ -133,6 +154,13 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
+	C.X_SSL_track_handshake(ssl)
+
+	if ctx.GetOptions()&EnableKTLS != 0 {
+		if raw, fd, ok := tcpSocket(conn); ok {
+			return newSocketConn(conn, ctx, ssl, raw, fd)
+		}
+	}
 
 	into_ssl := &readBio{}
 	from_ssl := &writeBio{}
@@ -156,7 +184,7 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 	C.SSL_set_bio(ssl, into_ssl_cbio, from_ssl_cbio)
 
 	s := &SSL{ssl: ssl}
-	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), unsafe.Pointer(s))
+	s.attach()
 
 	c := &Conn{
 		SSL: s,
@@ -164,7 +192,8 @@ func newConn(conn net.Conn, ctx *Ctx) (*Conn, error) {
 		conn:     conn,
 		ctx:      ctx,
 		into_ssl: into_ssl,
-		from_ssl: from_ssl}
+		from_ssl: from_ssl,
+		metrics:  ctx.currentMetrics()}
 	runtime.SetFinalizer(c, func(c *Conn) {
 		c.into_ssl.Disconnect(into_ssl_cbio)
 		c.from_ssl.Disconnect(from_ssl_cbio)
@@ -217,6 +246,21 @@ func (c *Conn) CurrentCipher() (string, error) {
 	return C.GoString(p), nil
 }
 
+// NegotiatedGroup returns the name of the group used for the key exchange,
+// such as "x25519". Requires OpenSSL 3.0 or later.
+func (c *Conn) NegotiatedGroup() (string, error) {
+	if !negotiated_group_support {
+		return "", errors.New("negotiated group requires OpenSSL 3.0")
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	p := C.X_SSL_get_negotiated_group_name(c.ssl)
+	if p == nil {
+		return "", errors.New("no group negotiated")
+	}
+	return C.GoString(p), nil
+}
+
 func (c *Conn) fillInputBuffer() error {
 	for {
 		n, err := c.into_ssl.ReadFromOnce(c.conn)
@@ -225,20 +269,57 @@ func (c *Conn) fillInputBuffer() error {
 		}
 		if err == io.EOF {
 			c.into_ssl.MarkEOF()
-			return c.Close()
+			return c.transportEOF()
 		}
 		return err
 	}
 }
 
+// transportEOF closes the connection after the underlying connection reached
+// EOF, reporting ErrTruncated if the context asks for it and the peer did not
+// send close_notify first.
+func (c *Conn) transportEOF() error {
+	c.mtx.Lock()
+	c.is_eof = true
+	truncated := c.ctx.report_truncation && !c.peerClosedLocked()
+	if truncated {
+		c.is_truncated = true
+	}
+	c.mtx.Unlock()
+	err := c.Close()
+	if truncated {
+		return ErrTruncated
+	}
+	return err
+}
+
+// atEOFLocked reports whether all data from the underlying connection has
+// been consumed. c.mtx must be held.
+func (c *Conn) atEOFLocked() bool {
+	if c.into_ssl != nil {
+		return c.into_ssl.IsEOF()
+	}
+	return c.is_eof
+}
+
 func (c *Conn) flushOutputBuffer() error {
+	if c.from_ssl == nil {
+		// OpenSSL writes to the socket directly
+		return nil
+	}
 	_, err := c.from_ssl.WriteTo(c.conn)
 	return err
 }
 
-func (c *Conn) getErrorHandler(rv C.int, errno error) func() error {
-	errcode := C.SSL_get_error(c.ssl, rv)
-	switch errcode {
+// getErrorHandler maps the outcome of an SSL I/O call to a handler. The error
+// state in r is captured by the shim, so no thread pinning is needed.
+func (c *Conn) getErrorHandler(r *C.X_io_result) func() error {
+	defer releaseResult(&r.res)
+	if c.raw != nil {
+		return c.getSocketErrorHandler(r)
+	}
+	res := &r.res
+	switch res.ssl_error {
 	case C.SSL_ERROR_ZERO_RETURN:
 		return func() error {
 			c.Close()
@@ -278,25 +359,34 @@ func (c *Conn) getErrorHandler(rv C.int, errno error) func() error {
 		}
 	case C.SSL_ERROR_SYSCALL:
 		var err error
-		if C.ERR_peek_error() == 0 {
-			switch rv {
+		if res.nerrs == 0 {
+			switch r.ret {
 			case 0:
 				err = errors.New("protocol-violating EOF")
 			case -1:
-				err = errno
+				err = errnoError(res.sys_errno)
 			default:
-				err = errorFromErrorQueue()
+				err = errorFromResult(res)
 			}
 		} else {
-			err = errorFromErrorQueue()
+			err = errorFromResult(res)
 		}
 		return func() error { return err }
 	default:
-		err := errorFromErrorQueue()
+		err := errorFromResult(res)
 		return func() error { return err }
 	}
 }
 
+// errnoError converts an errno captured by a shim like cgo does for the second
+// result of a call: 0 means no error.
+func errnoError(errno C.int) error {
+	if errno == 0 {
+		return nil
+	}
+	return syscall.Errno(errno)
+}
+
 func (c *Conn) handleError(errcb func() error) error {
 	if errcb != nil {
 		return errcb()
@@ -310,24 +400,32 @@ func (c *Conn) handshake() func() error {
 	if c.is_shutdown {
 		return func() error { return io.ErrUnexpectedEOF }
 	}
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_do_handshake(c.ssl)
-	if rv > 0 {
+	r := C.X_SSL_do_handshake(c.ssl)
+	if r.ret > 0 {
+		releaseResult(&r.res)
 		return nil
 	}
-	return c.getErrorHandler(rv, errno)
+	return c.getErrorHandler(&r)
 }
 
 // Handshake performs an SSL handshake. If a handshake is not manually
 // triggered, it will run before the first I/O on the encrypted stream.
 func (c *Conn) Handshake() error {
+	c.handshakeStarted()
 	err := tryAgain
 	for err == tryAgain {
 		err = c.handleError(c.handshake())
 	}
+	if err != nil {
+		// deliver the alert telling the peer why before the caller closes
+		c.flushOutputBuffer()
+		err = c.handshakeError(err)
+		c.handshakeFinished(err)
+		return err
+	}
+	c.handshakeFinished(nil)
 	go c.flushOutputBuffer()
-	return err
+	return nil
 }
 
 // PeerCertificate returns the Certificate of the peer with which you're
@@ -400,25 +498,27 @@ func (c *Conn) ConnectionState() (rv ConnectionState) {
 func (c *Conn) shutdown() func() error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_shutdown(c.ssl)
-	if rv > 0 {
+	r := C.X_SSL_shutdown(c.ssl)
+	if r.ret >= 0 {
+		releaseResult(&r.res)
+	}
+	if r.ret > 0 {
 		return nil
 	}
-	if rv == 0 {
+	if r.ret == 0 {
 		// The OpenSSL docs say that in this case, the shutdown is not
 		// finished, and we should call SSL_shutdown() a second time, if a
 		// bidirectional shutdown is going to be performed. Further, the
 		// output of SSL_get_error may be misleading, as an erroneous
 		// SSL_ERROR_SYSCALL may be flagged even though no error occurred.
-		// So, TODO: revisit bidrectional shutdown, possibly trying again.
+		// Waiting for the peer's close_notify is done separately by
+		// waitPeerShutdown, which reads records until the alert arrives.
 		// Note: some broken clients won't engage in bidirectional shutdown
 		// without tickling them to close by sending a TCP_FIN packet, or
 		// shutting down the write-side of the connection.
 		return nil
 	} else {
-		return c.getErrorHandler(rv, errno)
+		return c.getErrorHandler(&r)
 	}
 }
 
@@ -435,14 +535,81 @@ func (c *Conn) shutdownLoop() error {
 			return errors.New("shutdown requested a third time?")
 		}
 	}
-	if err == io.ErrUnexpectedEOF {
+	switch err {
+	case io.ErrUnexpectedEOF, syscall.EPIPE, syscall.ECONNRESET:
+		// the peer is gone already, so there is nobody left to notify
 		err = nil
 	}
 	return err
 }
 
+// peerClosed reports whether the peer's close_notify alert has been received.
+func (c *Conn) peerClosed() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.peerClosedLocked()
+}
+
+func (c *Conn) peerClosedLocked() bool {
+	return C.SSL_get_shutdown(c.ssl)&C.SSL_RECEIVED_SHUTDOWN != 0
+}
+
+// drain reads and discards a single chunk of application data. It reports
+// done once the peer's close_notify has been seen or the transport is at EOF.
+func (c *Conn) drain(b []byte) (done bool, errcb func() error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.peerClosedLocked() || c.atEOFLocked() ||
+		C.X_SSL_is_init_finished(c.ssl) != 1 {
+		return true, nil
+	}
+	r := C.X_SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	if r.ret > 0 {
+		releaseResult(&r.res)
+		return false, nil
+	}
+	return false, c.getErrorHandler(&r)
+}
+
+// waitPeerShutdown completes a bidirectional shutdown by waiting up to the
+// context's shutdown timeout for the peer's close_notify. Application data
+// that arrives in the meantime is discarded.
+func (c *Conn) waitPeerShutdown() error {
+	timeout := c.ctx.GetShutdownTimeout()
+	if timeout <= 0 {
+		return nil
+	}
+	err := c.conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, SSLRecordSize)
+	for {
+		done, errcb := c.drain(buf)
+		if done {
+			return nil
+		}
+		err = c.handleError(errcb)
+		switch err {
+		case nil, tryAgain:
+			continue
+		case io.ErrUnexpectedEOF:
+			// SSL_ERROR_ZERO_RETURN, the peer's close_notify arrived
+			return nil
+		}
+		c.mtx.Lock()
+		eof := c.atEOFLocked()
+		c.mtx.Unlock()
+		if err != ErrTruncated && eof {
+			return nil
+		}
+		return err
+	}
+}
+
 // Close shuts down the SSL connection and closes the underlying wrapped
-// connection.
+// connection. If the context has a shutdown timeout set, Close waits for the
+// peer's close_notify before closing the underlying connection.
 func (c *Conn) Close() error {
 	c.mtx.Lock()
 	if c.is_shutdown {
@@ -450,13 +617,56 @@ func (c *Conn) Close() error {
 		return nil
 	}
 	c.is_shutdown = true
+	// if OpenSSL hit the EOF on the socket itself, the SSL object is in an
+	// error state and can't send close_notify anymore
+	skip_shutdown := c.raw != nil && c.is_eof
+	// after CloseWrite a second SSL_shutdown would block reading the peer's
+	// close_notify, waiting for it is left to waitPeerShutdown
+	sent_shutdown := c.is_write_closed ||
+		C.SSL_get_shutdown(c.ssl)&C.SSL_SENT_SHUTDOWN != 0
 	c.mtx.Unlock()
 	var errs utils.ErrorGroup
-	errs.Add(c.shutdownLoop())
+	if !skip_shutdown {
+		var err error
+		if !sent_shutdown {
+			err = c.shutdownLoop()
+			errs.Add(err)
+		}
+		if err == nil {
+			errs.Add(c.waitPeerShutdown())
+		}
+	}
 	errs.Add(c.conn.Close())
 	return errs.Finalize()
 }
 
+// CloseWrite sends a close_notify alert to the peer and, if the underlying
+// connection supports it (as *net.TCPConn does), shuts down its writing side.
+// Reads keep working until the peer closes its side of the connection.
+func (c *Conn) CloseWrite() error {
+	c.mtx.Lock()
+	if c.is_shutdown {
+		c.mtx.Unlock()
+		return errors.New("connection closed")
+	}
+	if c.is_write_closed {
+		c.mtx.Unlock()
+		return nil
+	}
+	c.is_write_closed = true
+	c.mtx.Unlock()
+	err := c.shutdownLoop()
+	if err != nil {
+		return err
+	}
+	if cw, ok := c.conn.(interface {
+		CloseWrite() error
+	}); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
 func (c *Conn) read(b []byte) (int, func() error) {
 	if len(b) == 0 {
 		return 0, nil
@@ -464,15 +674,17 @@ func (c *Conn) read(b []byte) (int, func() error) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 	if c.is_shutdown {
+		if c.is_truncated {
+			return 0, func() error { return ErrTruncated }
+		}
 		return 0, func() error { return io.EOF }
 	}
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
-	if rv > 0 {
-		return int(rv), nil
+	r := C.X_SSL_read(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	if r.ret > 0 {
+		releaseResult(&r.res)
+		return int(r.ret), nil
 	}
-	return 0, c.getErrorHandler(rv, errno)
+	return 0, c.getErrorHandler(&r)
 }
 
 // Read reads up to len(b) bytes into b. It returns the number of bytes read
@@ -482,18 +694,21 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	c.handshakeStarted()
 	err = tryAgain
 	for err == tryAgain {
 		n, errcb := c.read(b)
 		err = c.handleError(errcb)
 		if err == nil {
 			go c.flushOutputBuffer()
+			c.ioDone(n, false)
 			return n, nil
 		}
 		if err == io.ErrUnexpectedEOF {
 			err = io.EOF
 		}
 	}
+	c.handshakeFinished(err)
 	return 0, err
 }
 
@@ -503,17 +718,16 @@ func (c *Conn) write(b []byte) (int, func() error) {
 	}
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	if c.is_shutdown {
+	if c.is_shutdown || c.is_write_closed {
 		err := errors.New("connection closed")
 		return 0, func() error { return err }
 	}
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	rv, errno := C.SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
-	if rv > 0 {
-		return int(rv), nil
+	r := C.X_SSL_write(c.ssl, unsafe.Pointer(&b[0]), C.int(len(b)))
+	if r.ret > 0 {
+		releaseResult(&r.res)
+		return int(r.ret), nil
 	}
-	return 0, c.getErrorHandler(rv, errno)
+	return 0, c.getErrorHandler(&r)
 }
 
 // Write will encrypt the contents of b and write it to the underlying stream.
@@ -523,14 +737,17 @@ func (c *Conn) Write(b []byte) (written int, err error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
+	c.handshakeStarted()
 	err = tryAgain
 	for err == tryAgain {
 		n, errcb := c.write(b)
 		err = c.handleError(errcb)
 		if err == nil {
+			c.ioDone(n, true)
 			return n, c.flushOutputBuffer()
 		}
 	}
+	c.handshakeFinished(err)
 	return 0, err
 }
 
@@ -576,10 +793,10 @@ func (c *Conn) UnderlyingConn() net.Conn {
 func (c *Conn) SetTlsExtHostName(name string) error {
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	if C.X_SSL_set_tlsext_host_name(c.ssl, cname) == 0 {
-		return errorFromErrorQueue()
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_set_tlsext_host_name(c.ssl, cname, &res) == 0 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -593,9 +810,6 @@ func (c *Conn) SessionReused() bool {
 }
 
 func (c *Conn) GetSession() ([]byte, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
 	// get1 increases the refcount of the session, so we have to free it.
 	session := (*C.SSL_SESSION)(C.SSL_get1_session(c.ssl))
 	if session == nil {
@@ -621,19 +835,19 @@ func (c *Conn) GetSession() ([]byte, error) {
 }
 
 func (c *Conn) setSession(session []byte) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
+	var res C.X_result
+	defer releaseResult(&res)
 	ptr := (*C.uchar)(&session[0])
-	s := C.d2i_SSL_SESSION(nil, &ptr, C.long(len(session)))
+	s := C.X_d2i_SSL_SESSION(ptr, C.long(len(session)), &res)
 	if s == nil {
-		return fmt.Errorf("unable to load session: %s", errorFromErrorQueue())
+		return fmt.Errorf("unable to load session: %s", errorFromResult(&res))
 	}
 	defer C.SSL_SESSION_free(s)
 
-	ret := C.SSL_set_session(c.ssl, s)
+	releaseResult(&res)
+	ret := C.X_SSL_set_session(c.ssl, s, &res)
 	if ret != 1 {
-		return fmt.Errorf("unable to set session: %s", errorFromErrorQueue())
+		return fmt.Errorf("unable to set session: %s", errorFromResult(&res))
 	}
 	return nil
 }
@@ -1,6 +1,14 @@
 package openssl
 
-import "testing"
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestVerifyError(t *testing.T) {
 	e := NewVerifyError(Ok)
@@ -17,3 +25,276 @@ func TestVerifyError(t *testing.T) {
 		t.Errorf("Error() failed")
 	}
 }
+
+func handshakeBoth(t testing.TB, server, client HandshakingConn) {
+	var errs [2]error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = server.Handshake()
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = client.Handshake()
+	}()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestCtx returns a context for version, with the test certificate and key
+// if it is for a server.
+func newTestCtx(t *testing.T, version SSLVersion, server bool) *Ctx {
+	ctx, err := NewCtxWithVersion(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server {
+		if err := ctx.useKeys(certBytes, keyBytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ctx
+}
+
+// handshakePair connects a server_ctx server with a client_ctx client and
+// runs both handshakes, returning the error of either.
+func handshakePair(t *testing.T, server_ctx, client_ctx *Ctx) (
+	server, client *Conn, err error) {
+	server_conn, client_conn := NetPipe(t)
+	server, err = Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Handshake()
+	}()
+	err = client.Handshake()
+	if err != nil {
+		// unblock the server
+		client.Close()
+	}
+	if server_err := <-errs; err == nil {
+		err = server_err
+	}
+	return server, client, err
+}
+
+func TestOpenSSLCloseWrite(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := StdlibOpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.(*Conn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("again")); err == nil {
+		t.Fatal("write after CloseWrite succeeded")
+	}
+
+	data, err := ioutil.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("server read %q", data)
+	}
+	if _, err := server.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "bye" {
+		t.Fatalf("client read %q", data)
+	}
+}
+
+func TestOpenSSLCloseAfterCloseWrite(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	if err := client.(*Conn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// the server stays silent, so Close must not wait for its close_notify
+	done := make(chan error, 1)
+	go func() { done <- client.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close after CloseWrite blocked")
+	}
+}
+
+func TestOpenSSLTruncation(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	server.(*Conn).GetCtx().SetReportTruncation(true)
+	handshakeBoth(t, server, client)
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// a full close with unread session tickets makes the kernel reset the
+	// connection, a half close always ends in a plain FIN
+	client_conn.(*net.TCPConn).CloseWrite()
+
+	data, err := ioutil.ReadAll(server)
+	if err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("server read %q", data)
+	}
+	if _, err := server.Read(make([]byte, 1)); err != ErrTruncated {
+		t.Fatalf("expected sticky ErrTruncated, got %v", err)
+	}
+}
+
+func TestOpenSSLBidirectionalShutdown(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	server.(*Conn).GetCtx().SetShutdownTimeout(5 * time.Second)
+	handshakeBoth(t, server, client)
+
+	go ioutil.ReadAll(client)
+
+	start := time.Now()
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !server.(*Conn).peerClosed() {
+		t.Fatal("close_notify was not received from the peer")
+	}
+	if time.Since(start) >= 5*time.Second {
+		t.Fatal("close waited for the whole timeout")
+	}
+}
+
+func TestOpenSSLShutdownTimeout(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := OpenSSLConstructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	server.(*Conn).GetCtx().SetShutdownTimeout(100 * time.Millisecond)
+	handshakeBoth(t, server, client)
+
+	err := server.Close()
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func groupsHandshake(t *testing.T, server_groups []tls.CurveID,
+	client_groups []string, version uint16) (*Conn, error) {
+	server_conn, client_conn := NetPipe(t)
+	cert, err := tls.X509KeyPair(certBytes, keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := tls.Server(server_conn, &tls.Config{
+		Certificates:     []tls.Certificate{cert},
+		MinVersion:       version,
+		MaxVersion:       version,
+		CurvePreferences: server_groups})
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetGroups(client_groups); err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server.Handshake()
+		server.Close()
+	}()
+	err = client.Handshake()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func TestOpenSSLStdlibGroups(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		client, err := groupsHandshake(t, []tls.CurveID{tls.X25519},
+			[]string{"P-256", "X25519"}, version)
+		if err != nil {
+			t.Fatalf("version %x: %s", version, err)
+		}
+		group, err := client.NegotiatedGroup()
+		client.Close()
+		if negotiated_group_support {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.EqualFold(group, "X25519") {
+				t.Fatalf("version %x: negotiated %s", version, group)
+			}
+		}
+
+		_, err = groupsHandshake(t, []tls.CurveID{tls.X25519},
+			[]string{"P-256"}, version)
+		if err == nil {
+			t.Fatalf("version %x: expected no shared group to fail", version)
+		}
+	}
+}
+
+func TestCtxSetGroupsInvalid(t *testing.T) {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetGroups([]string{"X25519", "no-such-group"}); err == nil {
+		t.Fatal("expected an unknown group to fail")
+	}
+	if err := ctx.SetGroups(nil); err == nil {
+		t.Fatal("expected no groups to fail")
+	}
+}
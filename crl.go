@@ -39,11 +39,14 @@ const (
 )
 
 func (c *CRL) GetNextUpdateTime() (time.Time, error) {
+	return asn1TimeToTime(C.X_X509_CRL_get_nextUpdate(c.x))
+}
+
+func asn1TimeToTime(t *C.ASN1_TIME) (time.Time, error) {
 	bio := C.BIO_new(C.BIO_s_mem())
 	defer C.BIO_free(bio)
-	t := C.X_X509_CRL_get_nextUpdate(c.x)
 	if int(C.ASN1_TIME_print(bio, t)) != 1 {
-		return time.Time{}, errors.New("failed to convert crl next time")
+		return time.Time{}, errors.New("failed to convert asn1 time")
 	}
 
 	data, err := ioutil.ReadAll(asAnyBio(bio))
@@ -23,17 +23,20 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
-
-	"github.com/spacemonkeygo/spacelog"
 )
 
 var (
 	ssl_ctx_idx = C.X_SSL_CTX_new_index()
 
-	logger = spacelog.GetLogger()
+	cert_chain_support = C.X_CERT_CHAIN_SUPPORT != 0
+	groups_support     = C.X_GROUPS_SUPPORT != 0
+	// SSL_CTX_use_cert_and_key replaces a certificate set atomically
+	cert_and_key_support = C.X_CERT_AND_KEY_SUPPORT != 0
 )
 
 type Ctx struct {
@@ -41,10 +44,25 @@ type Ctx struct {
 	cert      *Certificate
 	chain     []*Certificate
 	key       PrivateKey
+	cert_sets []certificateSet
 	verify_cb VerifyCallback
 	lookup_cb LookupCrlsCallback
 	sni_cb    TLSExtServernameCallback
 
+	security_cb SecurityCallback
+
+	psk_client_cb       PSKClientCallback
+	psk_server_cb       PSKServerCallback
+	psk_use_session_cb  PSKUseSessionCallback
+	psk_find_session_cb PSKFindSessionCallback
+
+	custom_ext_ids []C.int
+
+	shutdown_timeout  time.Duration
+	report_truncation bool
+
+	metrics atomic.Pointer[metricsHolder]
+
 	ticket_store_mu sync.Mutex
 	ticket_store    *TicketStore
 }
@@ -55,16 +73,17 @@ func get_ssl_ctx_idx() C.int {
 }
 
 func newCtx(method *C.SSL_METHOD) (*Ctx, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	ctx := C.SSL_CTX_new(method)
+	var res C.X_result
+	defer releaseResult(&res)
+	ctx := C.X_SSL_CTX_new(method, &res)
 	if ctx == nil {
-		return nil, errorFromErrorQueue()
+		return nil, errorFromResult(&res)
 	}
 	c := &Ctx{ctx: ctx}
 	C.SSL_CTX_set_ex_data(ctx, get_ssl_ctx_idx(), unsafe.Pointer(c))
 	runtime.SetFinalizer(c, func(c *Ctx) {
 		C.SSL_CTX_free(c.ctx)
+		unregisterCustomExtensions(c.custom_ext_ids)
 	})
 	return c, nil
 }
@@ -121,43 +140,194 @@ func NewCtxFromKeys(cert_bytes []byte, key_bytes []byte) (*Ctx, error) {
 		return nil, err
 	}
 
+	err = ctx.useKeys(cert_bytes, key_bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// useKeys configures the context to use the first certificate in cert_bytes,
+// the rest of them as its chain, and the private key in key_bytes.
+func (c *Ctx) useKeys(cert_bytes []byte, key_bytes []byte) error {
 	certs := SplitPEM(cert_bytes)
 	if len(certs) == 0 {
-		return nil, fmt.Errorf("No PEM certificate found")
+		return fmt.Errorf("No PEM certificate found")
 	}
 	first, certs := certs[0], certs[1:]
 	cert, err := LoadCertificateFromPEM(first)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	err = ctx.UseCertificate(cert)
+	err = c.UseCertificate(cert)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	for _, pem := range certs {
 		cert, err := LoadCertificateFromPEM(pem)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		err = ctx.AddChainCertificate(cert)
+		err = c.AddChainCertificate(cert)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	key, err := LoadPrivateKeyFromPEM(key_bytes)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	return c.UsePrivateKey(key)
+}
+
+// certificateSet is a certificate with its chain and private key, see
+// AddCertificateSet.
+type certificateSet struct {
+	cert  *Certificate
+	chain []*Certificate
+	key   PrivateKey
+}
+
+// AddCertificateSet adds a certificate together with its chain and private key
+// to the context. OpenSSL holds one such set per key type, so a context can
+// serve, for example, both an RSA and an ECDSA certificate; adding a set with
+// the key type of an earlier one replaces that one. Each handshake uses the
+// set that best fits the cipher suites and signature algorithms the client
+// offers.
+//
+// Unlike the certificates added with AddChainCertificate, which are sent with
+// any certificate that has no chain of its own, chain is only sent with cert.
+// A non-empty chain requires OpenSSL 1.0.2 or later.
+//
+// On error the context is left as it was. Before OpenSSL 1.1.1 this is done
+// by adding the previous set of the key type again, if it came from
+// AddCertificateSet.
+func (c *Ctx) AddCertificateSet(cert *Certificate, chain []*Certificate,
+	key PrivateKey) error {
+
+	if len(chain) > 0 && !cert_chain_support {
+		return errors.New("per certificate chains require OpenSSL 1.0.2")
+	}
+	set := certificateSet{cert: cert, chain: chain, key: key}
+	index := -1
+	for i := range c.cert_sets {
+		if c.cert_sets[i].key.BaseType() == key.BaseType() {
+			index = i
+			break
+		}
+	}
+	if err := c.useCertificateSet(set); err != nil {
+		if !cert_and_key_support && index >= 0 {
+			c.useCertificateSet(c.cert_sets[index])
+		}
+		return err
 	}
+	c.cert = cert
+	if index >= 0 {
+		c.cert_sets[index] = set
+	} else {
+		c.cert_sets = append(c.cert_sets, set)
+	}
+	return nil
+}
 
-	err = ctx.UsePrivateKey(key)
+// useCertificateSet installs set in OpenSSL. Before OpenSSL 1.1.1, it may
+// fail after replacing part of the set of the same key type.
+func (c *Ctx) useCertificateSet(set certificateSet) error {
+	var res C.X_result
+	defer releaseResult(&res)
+	if cert_and_key_support {
+		// one more, so that the first element exists
+		chain := make([]*C.X509, len(set.chain)+1)
+		for i, chain_cert := range set.chain {
+			chain[i] = chain_cert.x
+		}
+		if C.X_SSL_CTX_use_cert_and_key(c.ctx, set.cert.x, set.key.evpPKey(),
+			&chain[0], C.int(len(set.chain)), &res) != 1 {
+			return errorFromResult(&res)
+		}
+		return nil
+	}
+	cert, chain, key := set.cert, set.chain, set.key
+	// check up front, so a mismatched key leaves the context as it was
+	if int(C.X_X509_check_private_key(cert.x, key.evpPKey(), &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	// the certificate selects the set the chain and key are added to
+	releaseResult(&res)
+	if int(C.X_SSL_CTX_use_certificate(c.ctx, cert.x, &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	if cert_chain_support {
+		C.X_SSL_CTX_clear_chain_certs(c.ctx)
+	}
+	for _, chain_cert := range chain {
+		releaseResult(&res)
+		if int(C.X_SSL_CTX_add1_chain_cert(c.ctx, chain_cert.x, &res)) != 1 {
+			return errorFromResult(&res)
+		}
+	}
+	releaseResult(&res)
+	if int(C.X_SSL_CTX_use_PrivateKey(c.ctx, key.evpPKey(), &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// AddCertificateChainPEM adds a certificate set, see AddCertificateSet, from
+// a full chain: the PEM encoded certificate followed by its chain, as read by
+// SSL_CTX_use_certificate_chain_file, and the PEM encoded private key.
+func (c *Ctx) AddCertificateChainPEM(chain_bytes []byte,
+	key_bytes []byte) error {
+
+	pems := SplitPEM(chain_bytes)
+	if len(pems) == 0 {
+		return fmt.Errorf("No PEM certificate found")
+	}
+	var certs []*Certificate
+	for _, pem := range pems {
+		cert, err := LoadCertificateFromPEM(pem)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	key, err := LoadPrivateKeyFromPEM(key_bytes)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return c.AddCertificateSet(certs[0], certs[1:], key)
+}
 
-	return ctx, nil
+// AddCertificateChainFile is like AddCertificateChainPEM, but reads the full
+// chain and the private key from files.
+func (c *Ctx) AddCertificateChainFile(chain_file string,
+	key_file string) error {
+
+	chain_bytes, err := ioutil.ReadFile(chain_file)
+	if err != nil {
+		return err
+	}
+	key_bytes, err := ioutil.ReadFile(key_file)
+	if err != nil {
+		return err
+	}
+	return c.AddCertificateChainPEM(chain_bytes, key_bytes)
+}
+
+// GetCertificates returns the certificates of the sets added with
+// AddCertificateSet, one per key type.
+func (c *Ctx) GetCertificates() []*Certificate {
+	certs := make([]*Certificate, 0, len(c.cert_sets))
+	for _, set := range c.cert_sets {
+		certs = append(certs, set.cert)
+	}
+	return certs
 }
 
 // NewCtxFromFiles calls NewCtx, loads the provided files, and configures the
@@ -191,31 +361,56 @@ const (
 
 // SetEllipticCurve sets the elliptic curve used by the SSL context to
 // enable an ECDH cipher suite to be selected during the handshake.
+//
+// Deprecated: use SetGroups, which also covers X25519, X448 and the FFDHE
+// groups.
 func (c *Ctx) SetEllipticCurve(curve EllipticCurve) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
 	k := C.EC_KEY_new_by_curve_name(C.int(curve))
 	if k == nil {
 		return errors.New("Unknown curve")
 	}
 	defer C.EC_KEY_free(k)
 
-	if int(C.X_SSL_CTX_set_tmp_ecdh(c.ctx, k)) != 1 {
-		return errorFromErrorQueue()
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_set_tmp_ecdh(c.ctx, k, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 
 	return nil
 }
 
+// SetGroups sets the groups offered and accepted for the key exchange, in
+// order of preference. Groups are named as OpenSSL names them, such as
+// "X25519", "X448", "P-256", "P-384", "ffdhe2048" or, with OpenSSL 3 and a
+// provider supporting them, hybrid groups such as "X25519MLKEM768". Requires
+// OpenSSL 1.0.2 or later, which only knows elliptic curves. See
+// https://www.openssl.org/docs/man3.0/man3/SSL_CTX_set1_groups_list.html
+func (c *Ctx) SetGroups(groups []string) error {
+	if !groups_support {
+		return errors.New("SetGroups requires OpenSSL 1.0.2")
+	}
+	if len(groups) == 0 {
+		return errors.New("no groups provided")
+	}
+	var res C.X_result
+	defer releaseResult(&res)
+	clist := C.CString(strings.Join(groups, ":"))
+	defer C.free(unsafe.Pointer(clist))
+	if int(C.X_SSL_CTX_set1_groups_list(c.ctx, clist, &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
 // UseCertificate configures the context to present the given certificate to
 // peers.
 func (c *Ctx) UseCertificate(cert *Certificate) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	c.cert = cert
-	if int(C.SSL_CTX_use_certificate(c.ctx, cert.x)) != 1 {
-		return errorFromErrorQueue()
+	if int(C.X_SSL_CTX_use_certificate(c.ctx, cert.x, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -223,11 +418,11 @@ func (c *Ctx) UseCertificate(cert *Certificate) error {
 // AddChainCertificate adds a certificate to the chain presented in the
 // handshake.
 func (c *Ctx) AddChainCertificate(cert *Certificate) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	c.chain = append(c.chain, cert)
-	if int(C.X_SSL_CTX_add_extra_chain_cert(c.ctx, cert.x)) != 1 {
-		return errorFromErrorQueue()
+	if int(C.X_SSL_CTX_add_extra_chain_cert(c.ctx, cert.x, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	// OpenSSL takes ownership via SSL_CTX_add_extra_chain_cert
 	runtime.SetFinalizer(cert, nil)
@@ -237,15 +432,34 @@ func (c *Ctx) AddChainCertificate(cert *Certificate) error {
 // UsePrivateKey configures the context to use the given private key for SSL
 // handshakes.
 func (c *Ctx) UsePrivateKey(key PrivateKey) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	c.key = key
-	if int(C.SSL_CTX_use_PrivateKey(c.ctx, key.evpPKey())) != 1 {
-		return errorFromErrorQueue()
+	if int(C.X_SSL_CTX_use_PrivateKey(c.ctx, key.evpPKey(), &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// CheckPrivateKey verifies that the context's private key matches the public
+// key of its certificate. See
+// https://www.openssl.org/docs/ssl/SSL_CTX_check_private_key.html
+func (c *Ctx) CheckPrivateKey() error {
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_check_private_key(c.ctx, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
 
+// GetCertificate returns the certificate the context was last configured
+// with, by UseCertificate or AddCertificateSet, or nil. GetCertificates
+// returns those of all certificate sets.
+func (c *Ctx) GetCertificate() *Certificate {
+	return c.cert
+}
+
 // from openssl/x509_vfy.h
 const (
 	X509_V_FLAG_CRL_CHECK int = 0x4
@@ -301,11 +515,11 @@ func (c *Ctx) GetCertificateStore() *CertificateStore {
 // AddCertificate marks the provided Certificate as a trusted certificate in
 // the given CertificateStore.
 func (s *CertificateStore) AddCertificate(cert *Certificate) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	s.certs = append(s.certs, cert)
-	if int(C.X509_STORE_add_cert(s.store, cert.x)) != 1 {
-		return errorFromErrorQueue()
+	if int(C.X_X509_STORE_add_cert(s.store, cert.x, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -313,20 +527,20 @@ func (s *CertificateStore) AddCertificate(cert *Certificate) error {
 // AddCertificateRevocationList adds certificate revocation list to
 // the CertificateStore
 func (s *CertificateStore) AddCertificateRevocationList(crl *CRL) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	s.crls = append(s.crls, crl)
-	if int(C.X509_STORE_add_crl(s.store, crl.x)) != 1 {
-		return errorFromErrorQueue()
+	if int(C.X_X509_STORE_add_crl(s.store, crl.x, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
 
 func (s *CertificateStore) SetFlags(flags int) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-	if int(C.X509_STORE_set_flags(s.store, C.ulong(flags))) != 1 {
-		return errorFromErrorQueue()
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_X509_STORE_set_flags(s.store, C.ulong(flags), &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -334,8 +548,6 @@ func (s *CertificateStore) SetFlags(flags int) error {
 // SetupLookupCrlsCb set the function to look up all the CRLs
 // that match the given name
 func (s *CertificateStore) SetupLookupCrlsCb(lookup_cb LookupCrlsCallback) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
 	s.ctx.lookup_cb = lookup_cb
 	if lookup_cb != nil {
 		C.X509_STORE_set_lookup_crls_cb(s.store, (*[0]byte)(C.X_STORE_lookup_crls_cb))
@@ -391,8 +603,6 @@ func (self *CertificateStoreCtx) GetCurrentCert() *Certificate {
 // See http://www.openssl.org/docs/ssl/SSL_CTX_load_verify_locations.html for
 // more.
 func (c *Ctx) LoadVerifyLocations(ca_file string, ca_path string) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
 	var c_ca_file, c_ca_path *C.char
 	if ca_file != "" {
 		c_ca_file = C.CString(ca_file)
@@ -402,8 +612,10 @@ func (c *Ctx) LoadVerifyLocations(ca_file string, ca_path string) error {
 		c_ca_path = C.CString(ca_path)
 		defer C.free(unsafe.Pointer(c_ca_path))
 	}
-	if C.SSL_CTX_load_verify_locations(c.ctx, c_ca_file, c_ca_path) != 1 {
-		return errorFromErrorQueue()
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_CTX_load_verify_locations(c.ctx, c_ca_file, c_ca_path, &res) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -421,6 +633,12 @@ const (
 	CipherServerPreference             Options = C.SSL_OP_CIPHER_SERVER_PREFERENCE
 	NoSessionResumptionOrRenegotiation Options = C.SSL_OP_NO_SESSION_RESUMPTION_ON_RENEGOTIATION
 	NoTicket                           Options = C.SSL_OP_NO_TICKET
+	// EnableKTLS is only valid if you are using OpenSSL 3.0 or newer built
+	// with kTLS support. Connections over a *net.TCPConn then hand their
+	// socket to OpenSSL, which offloads record encryption to the kernel
+	// once the handshake completes if the kernel tls module is loaded. See
+	// Conn.KTLSSendActive and Conn.KTLSRecvActive.
+	EnableKTLS Options = C.SSL_OP_ENABLE_KTLS
 )
 
 // SetOptions sets context options. See
@@ -568,15 +786,15 @@ func (c *Ctx) SetTLSExtServernameCallback(sni_cb TLSExtServernameCallback) {
 }
 
 func (c *Ctx) SetSessionId(session_id []byte) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	var ptr *C.uchar
 	if len(session_id) > 0 {
 		ptr = (*C.uchar)(unsafe.Pointer(&session_id[0]))
 	}
-	if int(C.SSL_CTX_set_session_id_context(c.ctx, ptr,
-		C.uint(len(session_id)))) == 0 {
-		return errorFromErrorQueue()
+	if int(C.X_SSL_CTX_set_session_id_context(c.ctx, ptr,
+		C.uint(len(session_id)), &res)) == 0 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -585,12 +803,12 @@ func (c *Ctx) SetSessionId(session_id []byte) error {
 // described at http://www.openssl.org/docs/apps/ciphers.html, but see
 // http://www.openssl.org/docs/ssl/SSL_CTX_set_cipher_list.html for more.
 func (c *Ctx) SetCipherList(list string) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	var res C.X_result
+	defer releaseResult(&res)
 	clist := C.CString(list)
 	defer C.free(unsafe.Pointer(clist))
-	if int(C.SSL_CTX_set_cipher_list(c.ctx, clist)) == 0 {
-		return errorFromErrorQueue()
+	if int(C.X_SSL_CTX_set_cipher_list(c.ctx, clist, &res)) == 0 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -628,6 +846,31 @@ func (c *Ctx) GetTimeout() time.Duration {
 	return time.Duration(C.X_SSL_CTX_get_timeout(c.ctx)) * time.Second
 }
 
+// SetShutdownTimeout sets how long Conn.Close waits for the peer's
+// close_notify alert after sending its own, performing a bidirectional
+// shutdown. A zero timeout, the default, closes the underlying connection
+// right after close_notify is sent.
+func (c *Ctx) SetShutdownTimeout(t time.Duration) {
+	c.shutdown_timeout = t
+}
+
+// GetShutdownTimeout returns the bidirectional shutdown timeout.
+func (c *Ctx) GetShutdownTimeout() time.Duration {
+	return c.shutdown_timeout
+}
+
+// SetReportTruncation controls whether Conn.Read returns ErrTruncated instead
+// of io.EOF when the underlying connection is closed before the peer sent a
+// close_notify alert, which may indicate a truncation attack.
+func (c *Ctx) SetReportTruncation(report bool) {
+	c.report_truncation = report
+}
+
+// GetReportTruncation reports whether truncation is reported by Conn.Read.
+func (c *Ctx) GetReportTruncation() bool {
+	return c.report_truncation
+}
+
 // Set session cache size. Returns previously set value.
 // https://www.openssl.org/docs/ssl/SSL_CTX_sess_set_cache_size.html
 func (c *Ctx) SessSetCacheSize(t int) int {
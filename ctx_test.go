@@ -15,6 +15,11 @@
 package openssl
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"testing"
 	"time"
 )
@@ -46,3 +51,320 @@ func TestCtxSessCacheSizeOption(t *testing.T) {
 		t.Error("SessSetCacheSize() does not save anything to ctx")
 	}
 }
+
+// newTestChain returns a PEM full chain of a certificate for key issued by a
+// fresh CA with serial ca_serial, followed by the CA certificate.
+func newTestChain(t *testing.T, key PrivateKey, ca_serial int64) []byte {
+	ca := newTestKeyPair(t, ca_serial, 24*time.Hour)
+	ca_key, err := LoadPrivateKeyFromPEM(ca.key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewCertificate(&CertificateInfo{
+		Serial:       big.NewInt(1),
+		Issued:       0,
+		Expires:      24 * time.Hour,
+		Country:      "US",
+		Organization: "Test",
+		CommonName:   "localhost",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuer(ca.cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(ca_key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	cert_pem, err := cert.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(cert_pem, ca.cert_pem...)
+}
+
+func addTestCertificateSet(t *testing.T, ctx *Ctx, key PrivateKey,
+	ca_serial int64) {
+	key_pem, err := key.MarshalPKCS1PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.AddCertificateChainPEM(newTestChain(t, key, ca_serial), key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCtxCertificateSets(t *testing.T) {
+	rsa_key, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec_key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetEllipticCurve(Prime256v1); err != nil {
+		t.Fatal(err)
+	}
+	addTestCertificateSet(t, ctx, rsa_key, 10)
+	addTestCertificateSet(t, ctx, ec_key, 20)
+	if len(ctx.GetCertificates()) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(ctx.GetCertificates()))
+	}
+
+	tcp_listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(tcp_listener, ctx)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		cipher    uint16
+		algorithm x509.PublicKeyAlgorithm
+		ca_serial int64
+	}{
+		{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, x509.ECDSA, 20},
+		{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, x509.RSA, 10},
+	}
+	for _, test := range tests {
+		conn, err := tls.Dial(l.Addr().Network(), l.Addr().String(),
+			&tls.Config{
+				MaxVersion:         tls.VersionTLS12,
+				CipherSuites:       []uint16{test.cipher},
+				InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("%s: %s", test.algorithm, err)
+		}
+		certs := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		if len(certs) != 2 {
+			t.Fatalf("%s: expected 2 certificates, got %d", test.algorithm,
+				len(certs))
+		}
+		if certs[0].PublicKeyAlgorithm != test.algorithm {
+			t.Errorf("%s: served a %s certificate", test.algorithm,
+				certs[0].PublicKeyAlgorithm)
+		}
+		if certs[1].SerialNumber.Int64() != test.ca_serial {
+			t.Errorf("%s: served the chain of CA %s", test.algorithm,
+				certs[1].SerialNumber)
+		}
+	}
+}
+
+func TestCtxCertificateSetReplace(t *testing.T) {
+	rsa_key, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec_key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestCertificateSet(t, ctx, rsa_key, 10)
+	first := ctx.GetCertificates()[0]
+
+	// the key of one set does not fit the certificate of another
+	err = ctx.AddCertificateSet(first, nil, ec_key)
+	if err == nil {
+		t.Fatal("expected mismatched key to fail")
+	}
+	if err := ctx.CheckPrivateKey(); err != nil {
+		t.Fatalf("failed add changed the context: %s", err)
+	}
+
+	addTestCertificateSet(t, ctx, rsa_key, 11)
+	certs := ctx.GetCertificates()
+	if len(certs) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(certs))
+	}
+	if certs[0] == first {
+		t.Fatal("set with the same key type was not replaced")
+	}
+}
+
+// servedCertificates runs a handshake of a client_ctx client with a ctx
+// server and returns the certificates the client received, leaf first, along
+// with the protocol version.
+func servedCertificates(t *testing.T, ctx, client_ctx *Ctx) (
+	[]*x509.Certificate, string) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, err := Server(server_conn, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+
+	suite, err := client.CurrentCipherSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := client.PeerCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for _, cert := range chain {
+		cert_pem, err := cert.MarshalPEM()
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(cert_pem)
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, parsed)
+	}
+	return certs, suite.Version
+}
+
+func TestCtxCertificateSetsTLS13(t *testing.T) {
+	rsa_key, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec_key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestCertificateSet(t, ctx, rsa_key, 10)
+	addTestCertificateSet(t, ctx, ec_key, 20)
+
+	// TLS 1.3 suites do not name the authentication, the signature
+	// algorithms the client offers select the set
+	tests := []struct {
+		sigalg    string
+		algorithm x509.PublicKeyAlgorithm
+		ca_serial int64
+	}{
+		{"ecdsa_secp256r1_sha256", x509.ECDSA, 20},
+		{"rsa_pss_rsae_sha256", x509.RSA, 10},
+	}
+	for _, test := range tests {
+		client_ctx, err := NewCtx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client_ctx.SetSigAlgs([]string{test.sigalg}); err != nil {
+			t.Fatal(err)
+		}
+		certs, version := servedCertificates(t, ctx, client_ctx)
+		if version != "TLSv1.3" {
+			t.Skipf("negotiated %s", version)
+		}
+		if len(certs) != 2 {
+			t.Fatalf("%s: expected 2 certificates, got %d", test.sigalg,
+				len(certs))
+		}
+		if certs[0].PublicKeyAlgorithm != test.algorithm {
+			t.Errorf("%s: served a %s certificate", test.sigalg,
+				certs[0].PublicKeyAlgorithm)
+		}
+		if certs[1].SerialNumber.Int64() != test.ca_serial {
+			t.Errorf("%s: served the chain of CA %s", test.sigalg,
+				certs[1].SerialNumber)
+		}
+	}
+}
+
+func TestCtxCertificateSetFailure(t *testing.T) {
+	if !security_level_support {
+		t.Skip("security levels are not supported")
+	}
+	key, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestCertificateSet(t, ctx, key, 10)
+	before := ctx.GetCertificate()
+	if before != ctx.GetCertificates()[0] {
+		t.Fatal("GetCertificate does not return the added set")
+	}
+
+	// the chain is refused only after the certificate was accepted
+	ctx.SetSecurityLevel(2)
+	weak_key, err := GenerateRSAKey(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &CertificateInfo{
+		Serial:       big.NewInt(30),
+		Expires:      24 * time.Hour,
+		Country:      "US",
+		Organization: "Test",
+		CommonName:   "weak CA",
+	}
+	weak_ca, err := NewCertificate(info, weak_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := weak_ca.Sign(weak_key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	info.Serial = big.NewInt(2)
+	info.CommonName = "localhost"
+	cert, err := NewCertificate(info, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.SetIssuer(weak_ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(weak_key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.AddCertificateSet(cert, []*Certificate{weak_ca}, key)
+	if err == nil {
+		t.Fatal("expected a CA key below the security level to fail")
+	}
+	if ctx.GetCertificate() != before ||
+		ctx.GetCertificates()[0] != before {
+		t.Fatal("failed add changed the certificates")
+	}
+
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, _ := servedCertificates(t, ctx, client_ctx)
+	if len(certs) != 2 || certs[1].SerialNumber.Int64() != 10 {
+		t.Fatal("failed add changed the served chain")
+	}
+}
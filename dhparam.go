@@ -54,11 +54,10 @@ func LoadDHParametersFromPEM(pem_block []byte) (*DH, error) {
 // SetDHParameters sets the DH group (DH parameters) used to
 // negotiate an emphemeral DH key during handshaking.
 func (c *Ctx) SetDHParameters(dh *DH) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	if int(C.X_SSL_CTX_set_tmp_dh(c.ctx, dh.dh)) != 1 {
-		return errorFromErrorQueue()
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_set_tmp_dh(c.ctx, dh.dh, &res)) != 1 {
+		return errorFromResult(&res)
 	}
 	return nil
 }
@@ -27,6 +27,36 @@ type Digest struct {
 	ptr *C.EVP_MD
 }
 
+func (d *Digest) Nid() NID {
+	return NID(C.X_EVP_MD_type(d.ptr))
+}
+
+func (d *Digest) ShortName() (string, error) {
+	return Nid2ShortName(d.Nid())
+}
+
+// Size returns the length of the digest output in bytes.
+func (d *Digest) Size() int {
+	return int(C.X_EVP_MD_size(d.ptr))
+}
+
+func (d *Digest) BlockSize() int {
+	return int(C.X_EVP_MD_block_size(d.ptr))
+}
+
+// Sum returns the digest of data.
+func (d *Digest) Sum(data []byte) ([]byte, error) {
+	h, err := NewHashFromDigest(d)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+	if _, err := h.Write(data); err != nil {
+		return nil, err
+	}
+	return h.Sum()
+}
+
 // GetDigestByName returns the Digest with the name or nil and an error if the
 // digest was not found.
 func GetDigestByName(name string) (*Digest, error) {
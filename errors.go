@@ -0,0 +1,155 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorEntry is a single entry of the OpenSSL error queue.
+type ErrorEntry struct {
+	// Code is the packed error code, as returned by ERR_get_error.
+	Code uint64
+	// Library and Reason are the library and reason codes unpacked from
+	// Code.
+	Library int
+	Reason  int
+	// File and Line locate the OpenSSL source that raised the error.
+	File string
+	Line int
+	// Data is the additional text attached to the error, if any.
+	Data string
+}
+
+func (e ErrorEntry) String() string {
+	code := C.ulong(e.Code)
+	return fmt.Sprintf("%s:%s:%s",
+		C.GoString(C.ERR_lib_error_string(code)),
+		C.GoString(C.ERR_func_error_string(code)),
+		C.GoString(C.ERR_reason_error_string(code)))
+}
+
+// Error is returned by operations that fail inside OpenSSL. It holds the
+// error queue of the failed call, oldest entry first. Use errors.Is with the
+// Err* reasons below to check for specific failures.
+type Error struct {
+	Entries []ErrorEntry
+}
+
+func (e *Error) Error() string {
+	errs := make([]string, 0, len(e.Entries))
+	for _, entry := range e.Entries {
+		errs = append(errs, entry.String())
+	}
+	return fmt.Sprintf("SSL errors: %s", strings.Join(errs, "\n"))
+}
+
+// Is reports whether any entry of the queue matches target, which has to be
+// an *ErrorReason.
+func (e *Error) Is(target error) bool {
+	reason, ok := target.(*ErrorReason)
+	if !ok {
+		return false
+	}
+	for _, entry := range e.Entries {
+		if reason.matches(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorReason identifies a failure by its OpenSSL library and reason codes.
+// The same failure is reported by different libraries in some OpenSSL
+// versions, so a reason may cover several of them.
+type ErrorReason struct {
+	name  string
+	codes [][2]int
+}
+
+func (r *ErrorReason) Error() string {
+	return "openssl: " + r.name
+}
+
+func (r *ErrorReason) matches(entry ErrorEntry) bool {
+	for _, code := range r.codes {
+		if entry.Library == code[0] && entry.Reason == code[1] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrBadDecrypt is reported when decryption fails, for example when
+	// loading an encrypted key with the wrong password.
+	ErrBadDecrypt = &ErrorReason{name: "bad decrypt", codes: [][2]int{
+		{C.ERR_LIB_EVP, C.EVP_R_BAD_DECRYPT},
+		{C.ERR_LIB_PROV, C.PROV_R_BAD_DECRYPT}}}
+	// ErrNoStartLine is reported when PEM input does not contain the
+	// expected block.
+	ErrNoStartLine = &ErrorReason{name: "no start line", codes: [][2]int{
+		{C.ERR_LIB_PEM, C.PEM_R_NO_START_LINE}}}
+	// ErrKeyValuesMismatch is reported when a private key does not match
+	// the public key of a certificate.
+	ErrKeyValuesMismatch = &ErrorReason{name: "key values mismatch",
+		codes: [][2]int{{C.ERR_LIB_X509, C.X509_R_KEY_VALUES_MISMATCH}}}
+)
+
+// errUnknown is reported for failures that left the error queue empty.
+var errUnknown = errors.New("openssl: unknown error")
+
+// errorFromResult returns the error captured by a shim in the same C call as
+// the failed operation, so it may run on any OS thread. The caller still has
+// to release res.
+func errorFromResult(res *C.X_result) error {
+	if res.errs == nil {
+		return errUnknown
+	}
+	err := &Error{Entries: make([]ErrorEntry, 0, int(res.nerrs))}
+	for i := 0; i < int(res.nerrs); i++ {
+		code := res.errs.codes[i]
+		err.Entries = append(err.Entries, ErrorEntry{
+			Code:    uint64(code),
+			Library: int(C.X_ERR_GET_LIB(code)),
+			Reason:  int(C.X_ERR_GET_REASON(code)),
+			File:    resultString(res.errs, res.errs.file_offs[i]),
+			Line:    int(res.errs.lines[i]),
+			Data:    resultString(res.errs, res.errs.data_offs[i]),
+		})
+	}
+	return err
+}
+
+// releaseResult frees the error queue captured in res, if the call left one.
+// It takes the queue rather than res to C, so res may stay on the stack.
+func releaseResult(res *C.X_result) {
+	if res.errs != nil {
+		C.X_err_queue_free(res.errs)
+		res.errs = nil
+	}
+}
+
+func resultString(errs *C.X_err_queue, off C.short) string {
+	if off < 0 {
+		return ""
+	}
+	return C.GoString(&errs.strs[off])
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestErrorBadDecrypt(t *testing.T) {
+	block, _ := pem.Decode(keyBytes)
+	// a fixed IV keeps the padding check, and so the reason, deterministic
+	iv := bytes.NewReader(bytes.Repeat([]byte{7}, 16))
+	encrypted, err := x509.EncryptPEMBlock(iv, block.Type, block.Bytes,
+		[]byte("password"), x509.PEMCipherAES128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem_block := pem.EncodeToMemory(encrypted)
+
+	if _, err := LoadPrivateKeyFromPEMWithPassword(pem_block,
+		"password"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadPrivateKeyFromPEMWithPassword(pem_block, "wrong")
+	if !errors.Is(err, ErrBadDecrypt) {
+		t.Fatalf("expected ErrBadDecrypt, got %v", err)
+	}
+	if errors.Is(err, ErrNoStartLine) {
+		t.Fatal("unexpected ErrNoStartLine")
+	}
+}
+
+func TestErrorNoStartLine(t *testing.T) {
+	_, err := LoadCertificateFromPEM([]byte("not a certificate"))
+	if !errors.Is(err, ErrNoStartLine) {
+		t.Fatalf("expected ErrNoStartLine, got %v", err)
+	}
+	var ossl_err *Error
+	if !errors.As(err, &ossl_err) {
+		t.Fatalf("expected *Error, got %T", err)
+	}
+	if len(ossl_err.Entries) == 0 {
+		t.Fatal("no entries")
+	}
+	entry := ossl_err.Entries[0]
+	if entry.Code == 0 || entry.File == "" || entry.Line == 0 {
+		t.Fatalf("incomplete entry %+v", entry)
+	}
+}
+
+func TestErrorKeyValuesMismatch(t *testing.T) {
+	pair := newTestKeyPair(t, 1, time.Hour)
+	other := newTestKeyPair(t, 2, time.Hour)
+	key, err := LoadPrivateKeyFromPEM(other.key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseCertificate(pair.cert); err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.UsePrivateKey(key)
+	if !errors.Is(err, ErrKeyValuesMismatch) {
+		t.Fatalf("expected ErrKeyValuesMismatch, got %v", err)
+	}
+}
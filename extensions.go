@@ -0,0 +1,211 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+var (
+	custom_ext_support = C.X_CUSTOM_EXT_SUPPORT != 0
+)
+
+// ExtensionContext is a set of the handshake messages a custom extension may
+// appear in, along with flags restricting when it is used.
+type ExtensionContext uint
+
+const (
+	ExtTLSOnly                  ExtensionContext = C.SSL_EXT_TLS_ONLY
+	ExtIgnoreOnResumption       ExtensionContext = C.SSL_EXT_IGNORE_ON_RESUMPTION
+	ExtClientHello              ExtensionContext = C.SSL_EXT_CLIENT_HELLO
+	ExtTLS12ServerHello         ExtensionContext = C.SSL_EXT_TLS1_2_SERVER_HELLO
+	ExtTLS13ServerHello         ExtensionContext = C.SSL_EXT_TLS1_3_SERVER_HELLO
+	ExtTLS13EncryptedExtensions ExtensionContext = C.SSL_EXT_TLS1_3_ENCRYPTED_EXTENSIONS
+	ExtTLS13HelloRetryRequest   ExtensionContext = C.SSL_EXT_TLS1_3_HELLO_RETRY_REQUEST
+	ExtTLS13Certificate         ExtensionContext = C.SSL_EXT_TLS1_3_CERTIFICATE
+	ExtTLS13NewSessionTicket    ExtensionContext = C.SSL_EXT_TLS1_3_NEW_SESSION_TICKET
+	ExtTLS13CertificateRequest  ExtensionContext = C.SSL_EXT_TLS1_3_CERTIFICATE_REQUEST
+)
+
+// CustomExtension is a TLS extension implemented by the application. All
+// callbacks are optional.
+type CustomExtension struct {
+	// Type is the extension type, which must not be one OpenSSL implements.
+	Type uint16
+	// Context is the set of messages the extension may appear in. A server
+	// only adds the extension to its messages if the client sent it.
+	Context ExtensionContext
+
+	// Add returns the payload to send in the message identified by context,
+	// or nil to leave the extension out. An error aborts the handshake.
+	// Without Add, the extension is sent empty.
+	Add func(ssl *SSL, context ExtensionContext) ([]byte, error)
+	// Free is called once the payload returned by Add has been sent.
+	Free func(ssl *SSL, context ExtensionContext)
+	// Parse checks the payload the peer sent in the message identified by
+	// context. An error aborts the handshake with a decode_error alert.
+	// Accepted payloads are available from PeerExtension.
+	Parse func(ssl *SSL, context ExtensionContext, data []byte) error
+}
+
+// custom extensions are found by id, since OpenSSL keeps them with each
+// connection and they must work after SetSSLCtx changed its context
+var custom_exts = struct {
+	sync.RWMutex
+	m    map[C.int]*CustomExtension
+	next C.int
+}{m: make(map[C.int]*CustomExtension)}
+
+func getCustomExtension(id C.int) *CustomExtension {
+	custom_exts.RLock()
+	defer custom_exts.RUnlock()
+	return custom_exts.m[id]
+}
+
+func unregisterCustomExtensions(ids []C.int) {
+	custom_exts.Lock()
+	defer custom_exts.Unlock()
+	for _, id := range ids {
+		delete(custom_exts.m, id)
+	}
+}
+
+//export go_custom_ext_add_cb_thunk
+func go_custom_ext_add_cb_thunk(con *C.SSL, ext_type, context C.uint,
+	out **C.uchar, outlen *C.size_t, id C.int, al *C.int) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: custom extension add callback panic'd: %v",
+				err)
+			os.Exit(1)
+		}
+	}()
+	ext := getCustomExtension(id)
+	if ext == nil {
+		return 0
+	}
+	var data []byte
+	if ext.Add != nil {
+		var err error
+		data, err = ext.Add(callbackSSL(con), ExtensionContext(context))
+		if err != nil {
+			*al = C.SSL_AD_INTERNAL_ERROR
+			return -1
+		}
+		if data == nil {
+			return 0
+		}
+	}
+	// always allocate, the free callback only runs for non-nil payloads
+	buf := C.malloc(C.size_t(len(data) + 1))
+	if len(data) > 0 {
+		C.memcpy(buf, unsafe.Pointer(&data[0]), C.size_t(len(data)))
+	}
+	*out = (*C.uchar)(buf)
+	*outlen = C.size_t(len(data))
+	return 1
+}
+
+//export go_custom_ext_free_cb_thunk
+func go_custom_ext_free_cb_thunk(con *C.SSL, ext_type, context C.uint,
+	id C.int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: custom extension free callback panic'd: %v",
+				err)
+			os.Exit(1)
+		}
+	}()
+	ext := getCustomExtension(id)
+	if ext != nil && ext.Free != nil {
+		ext.Free(callbackSSL(con), ExtensionContext(context))
+	}
+}
+
+//export go_custom_ext_parse_cb_thunk
+func go_custom_ext_parse_cb_thunk(con *C.SSL, ext_type, context C.uint,
+	in *C.uchar, inlen C.size_t, id C.int, al *C.int) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: custom extension parse callback panic'd: %v",
+				err)
+			os.Exit(1)
+		}
+	}()
+	ext := getCustomExtension(id)
+	if ext == nil {
+		return 1
+	}
+	s := callbackSSL(con)
+	data := C.GoBytes(unsafe.Pointer(in), C.int(inlen))
+	if ext.Parse != nil {
+		if err := ext.Parse(s, ExtensionContext(context), data); err != nil {
+			*al = C.SSL_AD_DECODE_ERROR
+			return 0
+		}
+	}
+	if s.peer_exts == nil {
+		s.peer_exts = make(map[uint16][]byte)
+	}
+	s.peer_exts[uint16(ext_type)] = data
+	return 1
+}
+
+// AddCustomExtension registers ext with the context. Connections created from
+// the context afterwards send and parse it. Requires OpenSSL 1.1.1 or later.
+// See https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_add_custom_ext.html
+func (c *Ctx) AddCustomExtension(ext CustomExtension) error {
+	if !custom_ext_support {
+		return errors.New("custom extensions require OpenSSL 1.1.1")
+	}
+	custom_exts.Lock()
+	id := custom_exts.next
+	custom_exts.next++
+	custom_exts.m[id] = &ext
+	custom_exts.Unlock()
+
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_add_custom_ext(c.ctx, C.uint(ext.Type),
+		C.uint(ext.Context), id, &res)) != 1 {
+		unregisterCustomExtensions([]C.int{id})
+		return errorFromResult(&res)
+	}
+	c.custom_ext_ids = append(c.custom_ext_ids, id)
+	return nil
+}
+
+// PeerExtension returns the payload of the custom extension of type ext_type
+// the peer sent last, if any. It is meant for callbacks, which run while the
+// connection parses extensions; use Conn.PeerExtension otherwise.
+func (s *SSL) PeerExtension(ext_type uint16) ([]byte, bool) {
+	data, ok := s.peer_exts[ext_type]
+	return data, ok
+}
+
+// PeerExtension returns the payload of the custom extension of type ext_type
+// the peer sent last, if any. Only valid after a handshake.
+func (c *Conn) PeerExtension(ext_type uint16) ([]byte, bool) {
+	// the parse callback records payloads while c.mtx is held
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.SSL.PeerExtension(ext_type)
+}
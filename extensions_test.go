@@ -0,0 +1,170 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"testing"
+)
+
+// testExtType is taken from the private use range.
+const testExtType = 0xff42
+
+func newExtensionCtx(t *testing.T, version SSLVersion, server bool,
+	ext CustomExtension) *Ctx {
+	ctx := newTestCtx(t, version, server)
+	if err := ctx.AddCustomExtension(ext); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func attestationExtension(payload string,
+	parsed *[]ExtensionContext) CustomExtension {
+	return CustomExtension{
+		Type: testExtType,
+		Context: ExtClientHello | ExtTLS12ServerHello |
+			ExtTLS13EncryptedExtensions,
+		Add: func(ssl *SSL, context ExtensionContext) ([]byte, error) {
+			return []byte(payload), nil
+		},
+		Parse: func(ssl *SSL, context ExtensionContext, data []byte) error {
+			*parsed = append(*parsed, context)
+			if string(data) == "reject" {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	}
+}
+
+func TestCustomExtension(t *testing.T) {
+	if !custom_ext_support {
+		t.Skip("custom extensions are not supported")
+	}
+	tests := []struct {
+		version SSLVersion
+		server  ExtensionContext
+	}{
+		{TLSv1_2, ExtTLS12ServerHello},
+		{AnyVersion, ExtTLS13EncryptedExtensions},
+	}
+	for _, test := range tests {
+		var server_parsed, client_parsed []ExtensionContext
+		server_ctx := newExtensionCtx(t, test.version, true,
+			attestationExtension("server attestation", &server_parsed))
+		client_ctx := newExtensionCtx(t, test.version, false,
+			attestationExtension("client attestation", &client_parsed))
+
+		server, client, err := handshakePair(t, server_ctx, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := server.PeerExtension(testExtType)
+		if !ok || string(data) != "client attestation" {
+			t.Fatalf("server got %q, %t", data, ok)
+		}
+		data, ok = client.PeerExtension(testExtType)
+		if !ok || string(data) != "server attestation" {
+			t.Fatalf("client got %q, %t", data, ok)
+		}
+		close_both(server, client)
+
+		if len(server_parsed) != 1 || server_parsed[0] != ExtClientHello {
+			t.Fatalf("server parsed in %v", server_parsed)
+		}
+		if len(client_parsed) != 1 || client_parsed[0] != test.server {
+			t.Fatalf("client parsed in %v", client_parsed)
+		}
+	}
+}
+
+func TestCustomExtensionRejected(t *testing.T) {
+	if !custom_ext_support {
+		t.Skip("custom extensions are not supported")
+	}
+	var parsed []ExtensionContext
+	server_ctx := newExtensionCtx(t, AnyVersion, true,
+		attestationExtension("server attestation", &parsed))
+	client_ctx := newExtensionCtx(t, AnyVersion, false,
+		attestationExtension("reject", &parsed))
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	if err == nil {
+		t.Fatal("expected the rejected extension to fail the handshake")
+	}
+}
+
+func TestCustomExtensionNotSent(t *testing.T) {
+	if !custom_ext_support {
+		t.Skip("custom extensions are not supported")
+	}
+	// the server only answers clients sending the extension
+	var parsed []ExtensionContext
+	server_ctx := newExtensionCtx(t, AnyVersion, true,
+		attestationExtension("server attestation", &parsed))
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	if _, ok := server.PeerExtension(testExtType); ok {
+		t.Fatal("server got an extension that was not sent")
+	}
+}
+
+func TestPeerExtensionDuringHandshake(t *testing.T) {
+	if !custom_ext_support {
+		t.Skip("custom extensions are not supported")
+	}
+	var server_parsed, client_parsed []ExtensionContext
+	server_ctx := newExtensionCtx(t, AnyVersion, true,
+		attestationExtension("server attestation", &server_parsed))
+	client_ctx := newExtensionCtx(t, AnyVersion, false,
+		attestationExtension("client attestation", &client_parsed))
+
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+
+	// polls while the handshake records the payload
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			client.PeerExtension(testExtType)
+		}
+	}()
+	handshakeBoth(t, server, client)
+	<-done
+	data, ok := client.PeerExtension(testExtType)
+	if !ok || string(data) != "server attestation" {
+		t.Fatalf("client got %q, %t", data, ok)
+	}
+}
@@ -14,26 +14,22 @@
 
 package openssl
 
-/*
-#include <openssl/ssl.h>
-*/
+// #include "shim.h"
 import "C"
-import "runtime"
 
 // FIPSModeSet enables a FIPS 140-2 validated mode of operation.
 // https://wiki.openssl.org/index.php/FIPS_mode_set()
 func FIPSModeSet(mode bool) error {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
+	var res C.X_result
+	defer releaseResult(&res)
 	var r C.int
 	if mode {
-		r = C.FIPS_mode_set(1)
+		r = C.X_FIPS_mode_set(1, &res)
 	} else {
-		r = C.FIPS_mode_set(0)
+		r = C.X_FIPS_mode_set(0, &res)
 	}
 	if r != 1 {
-		return errorFromErrorQueue()
+		return errorFromResult(&res)
 	}
 	return nil
 }
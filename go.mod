@@ -1,3 +1,3 @@
 module github.com/ssgreg/openssl
 
-require github.com/spacemonkeygo/spacelog v0.0.0-20180420211403-2296661a0572
+go 1.24
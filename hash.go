@@ -19,6 +19,49 @@ type Hash interface {
 	BlockSize() int
 }
 
+// EVPHash computes any message digest OpenSSL provides, such as SHA3-256,
+// BLAKE2b512, SM3 or RIPEMD160.
+type EVPHash struct {
+	*internalHash
+}
+
+// NewHash returns a Hash for the digest with the given name, as accepted by
+// GetDigestByName. e may be nil.
+func NewHash(name string, e *Engine) (*EVPHash, error) {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return newEVPHash(e, digest)
+}
+
+// NewHashFromDigest returns a Hash for digest.
+func NewHashFromDigest(digest *Digest) (*EVPHash, error) {
+	return newEVPHash(nil, digest)
+}
+
+func newEVPHash(e *Engine, digest *Digest) (*EVPHash, error) {
+	h, err := newDigestHash(e, digest, digest.Size(), digest.BlockSize())
+	if err != nil {
+		return nil, err
+	}
+	return &EVPHash{h}, nil
+}
+
+// Name returns the short name of the digest.
+func (h *EVPHash) Name() string {
+	return h.name
+}
+
+// HashSum returns the digest of data computed with the named algorithm.
+func HashSum(name string, data []byte) ([]byte, error) {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return digest.Sum(data)
+}
+
 type internalHash struct {
 	ctx       *C.EVP_MD_CTX
 	engine    *Engine
@@ -29,12 +72,15 @@ type internalHash struct {
 }
 
 func newInternalHash(engine *Engine, nid NID, size int, blockSize int) (*internalHash, error) {
-	name, err := Nid2ShortName(nid)
+	digest, err := GetDigestByNid(nid)
 	if err != nil {
 		return nil, err
 	}
+	return newDigestHash(engine, digest, size, blockSize)
+}
 
-	digest, err := GetDigestByName(name)
+func newDigestHash(engine *Engine, digest *Digest, size int, blockSize int) (*internalHash, error) {
+	name, err := digest.ShortName()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,134 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashStdlib(t *testing.T) {
+	tests := []struct {
+		name string
+		sum  func([]byte) []byte
+	}{
+		{"SHA256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }},
+		{"SHA512-256",
+			func(b []byte) []byte { s := sha512.Sum512_256(b); return s[:] }},
+		{"SHA3-224", func(b []byte) []byte { s := sha3.Sum224(b); return s[:] }},
+		{"SHA3-256", func(b []byte) []byte { s := sha3.Sum256(b); return s[:] }},
+		{"SHA3-384", func(b []byte) []byte { s := sha3.Sum384(b); return s[:] }},
+		{"SHA3-512", func(b []byte) []byte { s := sha3.Sum512(b); return s[:] }},
+	}
+	for _, test := range tests {
+		h, err := NewHash(test.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSHA2(t, func(data []byte) {
+			expected := test.sum(data)
+			if h.Size() != len(expected) {
+				t.Fatalf("%s: size %d, expected %d", test.name, h.Size(),
+					len(expected))
+			}
+			// write in two parts to exercise streaming
+			if _, err := h.Write(data[:len(data)/2]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.Write(data[len(data)/2:]); err != nil {
+				t.Fatal(err)
+			}
+			got, err := h.Sum()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, got) {
+				t.Fatalf("%s: exp:%x got:%x", test.name, expected, got)
+			}
+			got, err = HashSum(test.name, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(expected, got) {
+				t.Fatalf("%s one-shot: exp:%x got:%x", test.name, expected, got)
+			}
+		})
+		h.Close()
+	}
+}
+
+func TestHashVectors(t *testing.T) {
+	// digests of "abc" from the algorithm specifications
+	tests := []struct {
+		name      string
+		blockSize int
+		sum       string
+	}{
+		{"BLAKE2b512", 128, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b7" +
+			"4b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab" +
+			"92386edd4009923"},
+		{"BLAKE2s256", 64, "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a29" +
+			"4d999b4c86675982"},
+		{"SM3", 64, "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b" +
+			"8f4ba8e0"},
+		{"RIPEMD160", 64, "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+	}
+	for _, test := range tests {
+		digest, err := GetDigestByName(test.name)
+		if err != nil {
+			t.Logf("%s: %v, skipping", test.name, err)
+			continue
+		}
+		h, err := NewHashFromDigest(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.BlockSize() != test.blockSize {
+			t.Fatalf("%s: block size %d, expected %d", test.name,
+				h.BlockSize(), test.blockSize)
+		}
+		if _, err := h.Write([]byte("abc")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := h.Sum()
+		h.Close()
+		if err != nil {
+			// the digest may be known but not provided, e.g. RIPEMD160
+			// before OpenSSL 3.0.7 without the legacy provider
+			t.Logf("%s: %v, skipping", test.name, err)
+			continue
+		}
+		if hex.EncodeToString(got) != test.sum {
+			t.Fatalf("%s: exp:%s got:%x", test.name, test.sum, got)
+		}
+		got, err = digest.Sum([]byte("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != test.sum {
+			t.Fatalf("%s one-shot: exp:%s got:%x", test.name, test.sum, got)
+		}
+	}
+}
+
+func TestHashUnknown(t *testing.T) {
+	if _, err := NewHash("NO-SUCH-DIGEST", nil); err == nil {
+		t.Fatal("expected an error for an unknown digest")
+	}
+}
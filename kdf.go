@@ -0,0 +1,271 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+var (
+	// HKDF, TLS1-PRF and scrypt need OpenSSL 1.1.0, the other KDFs but
+	// PBKDF2 3.0
+	kdf_support     = C.X_KDF_SUPPORT != 0
+	evp_kdf_support = C.X_EVP_KDF_SUPPORT != 0
+	argon2_support  = C.X_ARGON2_SUPPORT != 0
+)
+
+// HKDF modes as defined by OpenSSL.
+const (
+	hkdfExtractAndExpand = 0
+	hkdfExtractOnly      = 1
+	hkdfExpandOnly       = 2
+)
+
+// kdfParams are the inputs of a key derivation, which ones apply depends on
+// the algorithm. key is the secret, or the password.
+type kdfParams struct {
+	digest  *Digest
+	mode    int
+	key     []byte
+	salt    []byte
+	info    []byte
+	iter    uint64
+	n       uint64
+	r       uint32
+	p       uint32
+	lanes   uint32
+	memcost uint32
+}
+
+// derive runs the KDF named alg. OpenSSL 3.0 supports all the algorithms,
+// before only PBKDF2 and, since 1.1.0, HKDF, TLS1-PRF and scrypt are.
+func derive(alg string, params *kdfParams, length int) ([]byte, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("openssl: %s: invalid key length %d", alg,
+			length)
+	}
+	cparams := C.X_KDF_params{
+		mode:    C.int(params.mode),
+		iter:    C.uint64_t(params.iter),
+		n:       C.uint64_t(params.n),
+		r:       C.uint32_t(params.r),
+		p:       C.uint32_t(params.p),
+		lanes:   C.uint32_t(params.lanes),
+		memcost: C.uint32_t(params.memcost),
+	}
+	if params.digest != nil {
+		cparams.md = params.digest.ptr
+	}
+	// the parameters live in C memory, so that the struct holds no Go
+	// pointers, and are cleansed afterwards
+	cparams.key, cparams.key_len = kdfBytes(params.key)
+	defer kdfFree(cparams.key, cparams.key_len)
+	cparams.salt, cparams.salt_len = kdfBytes(params.salt)
+	defer kdfFree(cparams.salt, cparams.salt_len)
+	cparams.info, cparams.info_len = kdfBytes(params.info)
+	defer kdfFree(cparams.info, cparams.info_len)
+
+	calg := C.CString(alg)
+	defer C.free(unsafe.Pointer(calg))
+	out := make([]byte, length)
+	var res C.X_result
+	defer releaseResult(&res)
+	if 1 != C.X_KDF_derive(calg, &cparams,
+		(*C.uchar)(unsafe.Pointer(&out[0])), C.size_t(length), &res) {
+		if res.nerrs == 0 {
+			return nil, fmt.Errorf("openssl: KDF %s is not supported", alg)
+		}
+		return nil, errorFromResult(&res)
+	}
+	return out, nil
+}
+
+// kdfBytes copies b to C memory. The copy is never NULL, as empty and
+// missing parameters differ.
+func kdfBytes(b []byte) (*C.uchar, C.size_t) {
+	p := C.malloc(C.size_t(len(b) + 1))
+	if len(b) > 0 {
+		C.memcpy(p, unsafe.Pointer(&b[0]), C.size_t(len(b)))
+	}
+	return (*C.uchar)(p), C.size_t(len(b))
+}
+
+func kdfFree(p *C.uchar, size C.size_t) {
+	C.OPENSSL_cleanse(unsafe.Pointer(p), size)
+	C.free(unsafe.Pointer(p))
+}
+
+func checkDigest(alg string, digest *Digest) error {
+	if digest == nil {
+		return fmt.Errorf("openssl: %s needs a digest", alg)
+	}
+	return nil
+}
+
+// HKDF derives a key of length bytes from secret as defined by RFC 5869,
+// extracting a pseudorandom key with salt and expanding it with info.
+func HKDF(digest *Digest, secret, salt, info []byte, length int) ([]byte,
+	error) {
+	if err := checkDigest("HKDF", digest); err != nil {
+		return nil, err
+	}
+	return derive("HKDF", &kdfParams{
+		digest: digest,
+		mode:   hkdfExtractAndExpand,
+		key:    secret,
+		salt:   salt,
+		info:   info,
+	}, length)
+}
+
+// HKDFExtract returns the pseudorandom key extracted from secret with salt,
+// of the size of the digest.
+func HKDFExtract(digest *Digest, secret, salt []byte) ([]byte, error) {
+	if err := checkDigest("HKDF", digest); err != nil {
+		return nil, err
+	}
+	return derive("HKDF", &kdfParams{
+		digest: digest,
+		mode:   hkdfExtractOnly,
+		key:    secret,
+		salt:   salt,
+	}, digest.Size())
+}
+
+// HKDFExpand expands the pseudorandom key prk with info to length bytes.
+func HKDFExpand(digest *Digest, prk, info []byte, length int) ([]byte,
+	error) {
+	if err := checkDigest("HKDF", digest); err != nil {
+		return nil, err
+	}
+	return derive("HKDF", &kdfParams{
+		digest: digest,
+		mode:   hkdfExpandOnly,
+		key:    prk,
+		info:   info,
+	}, length)
+}
+
+// PBKDF2 derives a key of length bytes from password as defined by RFC
+// 8018, using HMAC with digest.
+func PBKDF2(password, salt []byte, iter, length int, digest *Digest) ([]byte,
+	error) {
+	if err := checkDigest("PBKDF2", digest); err != nil {
+		return nil, err
+	}
+	if iter <= 0 {
+		return nil, fmt.Errorf("openssl: PBKDF2: invalid iteration count %d",
+			iter)
+	}
+	return derive("PBKDF2", &kdfParams{
+		digest: digest,
+		mode:   -1,
+		key:    password,
+		salt:   salt,
+		iter:   uint64(iter),
+	}, length)
+}
+
+// Scrypt derives a key of length bytes from password as defined by RFC
+// 7914. N is the cost parameter, a power of two, r the block size and p the
+// parallelization. Parameters needing more than 1025 MiB of memory, roughly
+// 128 * r * (N + p) bytes, are rejected.
+func Scrypt(password, salt []byte, N, r, p, length int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("openssl: scrypt: N must be a power of two " +
+			"greater than 1")
+	}
+	if r <= 0 || p <= 0 {
+		return nil, errors.New("openssl: scrypt: invalid parameters")
+	}
+	return derive("SCRYPT", &kdfParams{
+		mode: -1,
+		key:  password,
+		salt: salt,
+		n:    uint64(N),
+		r:    uint32(r),
+		p:    uint32(p),
+	}, length)
+}
+
+// TLS1PRF derives length bytes from secret with the pseudorandom function of
+// TLS 1.2, or of TLS 1.0 and 1.1 with the "MD5-SHA1" digest.
+func TLS1PRF(digest *Digest, secret, label, seed []byte, length int) ([]byte,
+	error) {
+	if err := checkDigest("TLS1-PRF", digest); err != nil {
+		return nil, err
+	}
+	return derive("TLS1-PRF", &kdfParams{
+		digest: digest,
+		mode:   -1,
+		key:    secret,
+		info:   append(append([]byte{}, label...), seed...),
+	}, length)
+}
+
+// SSKDF derives length bytes from the shared secret with the single-step
+// hash KDF of NIST SP 800-56C, where info is the fixed info.
+func SSKDF(digest *Digest, secret, info []byte, length int) ([]byte, error) {
+	if err := checkDigest("SSKDF", digest); err != nil {
+		return nil, err
+	}
+	return derive("SSKDF", &kdfParams{
+		digest: digest,
+		mode:   -1,
+		key:    secret,
+		info:   info,
+	}, length)
+}
+
+// X963KDF derives length bytes from the shared secret with the KDF of ANSI
+// X9.63, where info is the shared info.
+func X963KDF(digest *Digest, secret, info []byte, length int) ([]byte,
+	error) {
+	if err := checkDigest("X963KDF", digest); err != nil {
+		return nil, err
+	}
+	return derive("X963KDF", &kdfParams{
+		digest: digest,
+		mode:   -1,
+		key:    secret,
+		info:   info,
+	}, length)
+}
+
+// Argon2id derives a key of length bytes from password as defined by RFC
+// 9106, with time passes over memory KiB and threads lanes. It needs OpenSSL
+// 3.2.
+func Argon2id(password, salt []byte, time, memory uint32, threads uint8,
+	length uint32) ([]byte, error) {
+	if !argon2_support {
+		return nil, errors.New("openssl: argon2id is not supported")
+	}
+	if time == 0 || threads == 0 {
+		return nil, errors.New("openssl: argon2id: invalid parameters")
+	}
+	return derive("ARGON2ID", &kdfParams{
+		mode:    -1,
+		key:     password,
+		salt:    salt,
+		iter:    uint64(time),
+		lanes:   uint32(threads),
+		memcost: memory,
+	}, int(length))
+}
@@ -0,0 +1,196 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+func mustDigest(t *testing.T, name string) *Digest {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func checkKey(t *testing.T, name string, got []byte, err error,
+	expected string) {
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(got, mustHex(t, expected)) {
+		t.Fatalf("%s: exp:%s got:%x", name, expected, got)
+	}
+}
+
+func TestHKDF(t *testing.T) {
+	if !kdf_support {
+		t.Skip("HKDF is not supported")
+	}
+	sha256 := mustDigest(t, "SHA256")
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt := mustHex(t, "000102030405060708090a0b0c")
+	info := mustHex(t, "f0f1f2f3f4f5f6f7f8f9")
+
+	// RFC 5869 test case 1
+	prk := "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5"
+	okm := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf" +
+		"34007208d5b887185865"
+	got, err := HKDF(sha256, ikm, salt, info, 42)
+	checkKey(t, "HKDF", got, err, okm)
+	got, err = HKDFExtract(sha256, ikm, salt)
+	checkKey(t, "HKDF extract", got, err, prk)
+	got, err = HKDFExpand(sha256, got, info, 42)
+	checkKey(t, "HKDF expand", got, err, okm)
+
+	// RFC 5869 test case 3, without salt and info
+	got, err = HKDF(sha256, ikm, nil, nil, 42)
+	checkKey(t, "HKDF without salt", got, err,
+		"8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d"+
+			"9d201395faa4b61a96c8")
+}
+
+func TestPBKDF2(t *testing.T) {
+	sha1 := mustDigest(t, "SHA1")
+	// RFC 6070
+	got, err := PBKDF2([]byte("password"), []byte("salt"), 1, 20, sha1)
+	checkKey(t, "PBKDF2 1", got, err,
+		"0c60c80f961f0e71f3a9b524af6012062fe037a6")
+	got, err = PBKDF2([]byte("password"), []byte("salt"), 4096, 20, sha1)
+	checkKey(t, "PBKDF2 4096", got, err,
+		"4b007901b765489abead49d926f721d065a429c1")
+	// RFC 7914 section 11
+	got, err = PBKDF2([]byte("passwd"), []byte("salt"), 1, 64,
+		mustDigest(t, "SHA256"))
+	checkKey(t, "PBKDF2 SHA256", got, err,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	if _, err := PBKDF2([]byte("password"), nil, 0, 20, sha1); err == nil {
+		t.Fatal("expected an invalid iteration count to fail")
+	}
+}
+
+func TestScrypt(t *testing.T) {
+	if !kdf_support {
+		t.Skip("scrypt is not supported")
+	}
+	// RFC 7914 section 12
+	got, err := Scrypt(nil, nil, 16, 1, 1, 64)
+	checkKey(t, "scrypt empty", got, err,
+		"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442"+
+			"fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906")
+	got, err = Scrypt([]byte("password"), []byte("NaCl"), 1024, 8, 16, 64)
+	checkKey(t, "scrypt", got, err,
+		"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b373162"+
+			"2eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640")
+	if _, err := Scrypt(nil, nil, 15, 1, 1, 64); err == nil {
+		t.Fatal("expected N not a power of two to fail")
+	}
+	// 2 GiB of memory is over the limit
+	if _, err := Scrypt(nil, nil, 1<<21, 8, 1, 64); err == nil {
+		t.Fatal("expected scrypt over the memory limit to fail")
+	}
+}
+
+func TestTLS1PRF(t *testing.T) {
+	if !kdf_support {
+		t.Skip("TLS1-PRF is not supported")
+	}
+	// the TLS 1.2 SHA256 vector published on the TLS working group list
+	got, err := TLS1PRF(mustDigest(t, "SHA256"),
+		mustHex(t, "9bbe436ba940f017b17652849a71db35"), []byte("test label"),
+		mustHex(t, "a0ba9f936cda311827a6f796ffd5198c"), 100)
+	checkKey(t, "TLS1-PRF", got, err,
+		"e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a"+
+			"6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab"+
+			"4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff701"+
+			"87347b66")
+}
+
+// hashKDF computes the KDFs of NIST SP 800-56C and ANSI X9.63 with SHA256,
+// which hash the counter before or after the secret.
+func hashKDF(secret, info []byte, length int, counter_first bool) []byte {
+	var out []byte
+	for counter := uint32(1); len(out) < length; counter++ {
+		h := sha256.New()
+		if counter_first {
+			binary.Write(h, binary.BigEndian, counter)
+			h.Write(secret)
+		} else {
+			h.Write(secret)
+			binary.Write(h, binary.BigEndian, counter)
+		}
+		h.Write(info)
+		out = h.Sum(out)
+	}
+	return out[:length]
+}
+
+func TestSSKDF(t *testing.T) {
+	if !evp_kdf_support {
+		t.Skip("SSKDF is not supported")
+	}
+	secret := []byte("shared secret")
+	info := []byte("fixed info")
+	got, err := SSKDF(mustDigest(t, "SHA256"), secret, info, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := hashKDF(secret, info, 50, true); !bytes.Equal(got,
+		expected) {
+		t.Fatalf("exp:%x got:%x", expected, got)
+	}
+}
+
+func TestX963KDF(t *testing.T) {
+	if !evp_kdf_support {
+		t.Skip("X9.63 KDF is not supported")
+	}
+	// the ANSI X9.63 CAVS SHA256 vector without shared info
+	got, err := X963KDF(mustDigest(t, "SHA256"),
+		mustHex(t, "96c05619d56c328ab95fe84b18264b08725b85e33fd34f08"), nil,
+		16)
+	checkKey(t, "X963KDF", got, err, "443024c3dae66b95e6f5670601558f71")
+
+	secret := []byte("shared secret")
+	info := []byte("shared info")
+	got, err = X963KDF(mustDigest(t, "SHA256"), secret, info, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := hashKDF(secret, info, 50, false); !bytes.Equal(got,
+		expected) {
+		t.Fatalf("exp:%x got:%x", expected, got)
+	}
+}
+
+func TestArgon2id(t *testing.T) {
+	if !argon2_support {
+		if _, err := Argon2id([]byte("password"), []byte("somesalt"), 2,
+			1<<16, 1, 32); err == nil {
+			t.Fatal("expected argon2id to be unsupported")
+		}
+		t.Skip("argon2id is not supported")
+	}
+	// the reference implementation vector for t=2, m=2^16, p=1
+	got, err := Argon2id([]byte("password"), []byte("somesalt"), 2, 1<<16, 1,
+		32)
+	checkKey(t, "argon2id", got, err,
+		"09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7")
+}
@@ -278,16 +278,12 @@ func LoadPrivateKeyFromPEM(pem_block []byte) (PrivateKey, error) {
 	if len(pem_block) == 0 {
 		return nil, errors.New("empty pem block")
 	}
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
-		C.int(len(pem_block)))
-	if bio == nil {
-		return nil, errors.New("failed creating bio")
-	}
-	defer C.BIO_free(bio)
-
-	key := C.PEM_read_bio_PrivateKey(bio, nil, nil, nil)
+	var res C.X_result
+	defer releaseResult(&res)
+	key := C.X_PEM_read_PrivateKey(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)), nil, &res)
 	if key == nil {
-		return nil, errors.New("failed reading private key")
+		return nil, errorFromResult(&res)
 	}
 
 	p := &pKey{key: key}
@@ -303,17 +299,14 @@ func LoadPrivateKeyFromPEMWithPassword(pem_block []byte, password string) (
 	if len(pem_block) == 0 {
 		return nil, errors.New("empty pem block")
 	}
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
-		C.int(len(pem_block)))
-	if bio == nil {
-		return nil, errors.New("failed creating bio")
-	}
-	defer C.BIO_free(bio)
 	cs := C.CString(password)
 	defer C.free(unsafe.Pointer(cs))
-	key := C.PEM_read_bio_PrivateKey(bio, nil, nil, unsafe.Pointer(cs))
+	var res C.X_result
+	defer releaseResult(&res)
+	key := C.X_PEM_read_PrivateKey(unsafe.Pointer(&pem_block[0]),
+		C.int(len(pem_block)), cs, &res)
 	if key == nil {
-		return nil, errors.New("failed reading private key")
+		return nil, errorFromResult(&res)
 	}
 
 	p := &pKey{key: key}
@@ -328,16 +321,11 @@ func LoadPrivateKeyFromDER(der_block []byte) (PrivateKey, error) {
 	if len(der_block) == 0 {
 		return nil, errors.New("empty der block")
 	}
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
-		C.int(len(der_block)))
-	if bio == nil {
-		return nil, errors.New("failed creating bio")
-	}
-	defer C.BIO_free(bio)
-
-	key := C.d2i_PrivateKey_bio(bio, nil)
+	var res C.X_result
+	defer releaseResult(&res)
+	key := C.X_d2i_PrivateKey(unsafe.Pointer(&der_block[0]), C.int(len(der_block)), &res)
 	if key == nil {
-		return nil, errors.New("failed reading private key der")
+		return nil, errorFromResult(&res)
 	}
 
 	p := &pKey{key: key}
@@ -359,16 +347,11 @@ func LoadPublicKeyFromPEM(pem_block []byte) (PublicKey, error) {
 	if len(pem_block) == 0 {
 		return nil, errors.New("empty pem block")
 	}
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&pem_block[0]),
-		C.int(len(pem_block)))
-	if bio == nil {
-		return nil, errors.New("failed creating bio")
-	}
-	defer C.BIO_free(bio)
-
-	key := C.PEM_read_bio_PUBKEY(bio, nil, nil, nil)
+	var res C.X_result
+	defer releaseResult(&res)
+	key := C.X_PEM_read_PUBKEY(unsafe.Pointer(&pem_block[0]), C.int(len(pem_block)), &res)
 	if key == nil {
-		return nil, errors.New("failed reading public key der")
+		return nil, errorFromResult(&res)
 	}
 
 	p := &pKey{key: key}
@@ -383,16 +366,11 @@ func LoadPublicKeyFromDER(der_block []byte) (PublicKey, error) {
 	if len(der_block) == 0 {
 		return nil, errors.New("empty der block")
 	}
-	bio := C.BIO_new_mem_buf(unsafe.Pointer(&der_block[0]),
-		C.int(len(der_block)))
-	if bio == nil {
-		return nil, errors.New("failed creating bio")
-	}
-	defer C.BIO_free(bio)
-
-	key := C.d2i_PUBKEY_bio(bio, nil)
+	var res C.X_result
+	defer releaseResult(&res)
+	key := C.X_d2i_PUBKEY(unsafe.Pointer(&der_block[0]), C.int(len(der_block)), &res)
 	if key == nil {
-		return nil, errors.New("failed reading public key der")
+		return nil, errorFromResult(&res)
 	}
 
 	p := &pKey{key: key}
@@ -0,0 +1,232 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+var (
+	ktls_support = C.X_KTLS_SUPPORT != 0
+)
+
+// maxSendfileSize is the largest chunk handed to a single SSL_sendfile call.
+const maxSendfileSize = 4 << 20
+
+// newSocketConn creates a connection on which OpenSSL reads and writes the
+// socket fd itself rather than going through the Go memory BIOs. This is
+// required for kernel TLS, which OpenSSL only enables on socket BIOs. The
+// socket stays non-blocking and waits go through the runtime poller, so
+// deadlines set on the underlying connection keep working.
+func newSocketConn(conn net.Conn, ctx *Ctx, ssl *C.SSL, raw syscall.RawConn,
+	fd int) (*Conn, error) {
+
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_set_fd(ssl, C.int(fd), &res) != 1 {
+		C.SSL_free(ssl)
+		return nil, errorFromResult(&res)
+	}
+
+	s := &SSL{ssl: ssl}
+	s.attach()
+
+	c := &Conn{
+		SSL: s,
+
+		conn:    conn,
+		ctx:     ctx,
+		raw:     raw,
+		metrics: ctx.currentMetrics()}
+	// the socket BIO does not own the fd, conn is still responsible for it
+	runtime.SetFinalizer(c, func(c *Conn) {
+		C.SSL_free(c.ssl)
+	})
+	return c, nil
+}
+
+// getSocketErrorHandler is getErrorHandler for connections created by
+// newSocketConn.
+func (c *Conn) getSocketErrorHandler(r *C.X_io_result) func() error {
+	res := &r.res
+	switch {
+	case res.ssl_error == C.SSL_ERROR_ZERO_RETURN:
+		return func() error {
+			c.Close()
+			return io.ErrUnexpectedEOF
+		}
+	case res.ssl_error == C.SSL_ERROR_WANT_READ:
+		return func() error { return c.waitSocket(c.raw.Read, false) }
+	case res.ssl_error == C.SSL_ERROR_WANT_WRITE:
+		return func() error { return c.waitSocket(c.raw.Write, true) }
+	case r.eof != 0:
+		return func() error {
+			err := c.transportEOF()
+			if err != nil {
+				return err
+			}
+			return tryAgain
+		}
+	case res.ssl_error == C.SSL_ERROR_SYSCALL && res.nerrs == 0 && r.ret == -1:
+		err := errnoError(res.sys_errno)
+		return func() error { return err }
+	default:
+		err := errorFromResult(res)
+		return func() error { return err }
+	}
+}
+
+// waitSocket parks the goroutine in the runtime poller until the socket is
+// ready, wait being either c.raw.Read or c.raw.Write.
+func (c *Conn) waitSocket(wait func(func(uintptr) bool) error,
+	write bool) error {
+	polled := false
+	err := wait(func(fd uintptr) bool {
+		if polled {
+			return true
+		}
+		polled = true
+		// the poller forgets readiness reported before wait was called, so
+		// anything that arrived since OpenSSL gave up has to be checked here
+		return socketReady(fd, write)
+	})
+	if err != nil {
+		return err
+	}
+	return tryAgain
+}
+
+// KTLSSendActive reports whether encryption of outgoing records is offloaded
+// to the kernel. This requires the EnableKTLS option on the context, a
+// *net.TCPConn underneath, a cipher supported by the kernel and the kernel tls
+// module to be loaded. It is only meaningful after the handshake.
+func (c *Conn) KTLSSendActive() bool {
+	if c.raw == nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return C.X_SSL_get_ktls_send(c.ssl) != 0
+}
+
+// KTLSRecvActive reports whether decryption of incoming records is offloaded
+// to the kernel. See KTLSSendActive.
+func (c *Conn) KTLSRecvActive() bool {
+	if c.raw == nil {
+		return false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return C.X_SSL_get_ktls_recv(c.ssl) != 0
+}
+
+// writerOnly hides the ReadFrom method of a Conn, so io.Copy does not recurse
+// into it.
+type writerOnly struct {
+	io.Writer
+}
+
+// ReadFrom implements io.ReaderFrom. If r is a regular *os.File and kernel
+// TLS transmit offload is active, the rest of the file is sent with
+// sendfile(2) and never copied through user space. Otherwise ReadFrom falls
+// back to writing through Write.
+func (c *Conn) ReadFrom(r io.Reader) (n int64, err error) {
+	if f, ok := r.(*os.File); ok && c.KTLSSendActive() {
+		n, handled, err := c.sendFile(f)
+		if n > 0 {
+			c.ioDone(int(n), true)
+		}
+		if handled {
+			return n, err
+		}
+	}
+	return io.Copy(writerOnly{c}, r)
+}
+
+// sendFile sends f from its current offset to its end and advances the
+// offset by the number of bytes sent. handled is false if f can't be sent
+// with sendfile and nothing was written.
+func (c *Conn) sendFile(f *os.File) (written int64, handled bool, err error) {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, false, nil
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false, nil
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	remain := fi.Size() - offset
+	for remain > 0 && err == nil {
+		size := remain
+		if size > maxSendfileSize {
+			size = maxSendfileSize
+		}
+		var n int64
+		var errcb func() error
+		// waits for the socket happen outside of the callback, which only
+		// keeps f open during the call
+		err = rc.Write(func(fd uintptr) bool {
+			n, errcb = c.sendfile(int(fd), offset+written, size)
+			return true
+		})
+		if err != nil {
+			break
+		}
+		if n > 0 {
+			written += n
+			remain -= n
+			continue
+		}
+		err = c.handleError(errcb)
+		if err == tryAgain {
+			err = nil
+		}
+	}
+	if written > 0 {
+		_, serr := f.Seek(offset+written, io.SeekStart)
+		if err == nil {
+			err = serr
+		}
+	}
+	return written, true, err
+}
+
+func (c *Conn) sendfile(fd int, offset, size int64) (int64, func() error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.is_shutdown || c.is_write_closed {
+		err := errors.New("connection closed")
+		return 0, func() error { return err }
+	}
+	r := C.X_SSL_sendfile(c.ssl, C.int(fd), C.longlong(offset),
+		C.size_t(size))
+	if r.ret > 0 {
+		releaseResult(&r.res)
+		return int64(r.ret), nil
+	}
+	return 0, c.getErrorHandler(&r)
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package openssl
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// ktlsAvailable reports whether the kernel can attach the tls module to TCP
+// sockets, it is probed once.
+var ktlsAvailable = sync.OnceValue(probeKTLS)
+
+// probeKTLS attaches the tls upper layer protocol to an unconnected socket.
+// The kernel loads the module and only then refuses the socket for not being
+// connected.
+func probeKTLS() bool {
+	const tcpULP = 31
+	fd, err := syscall.Socket(syscall.AF_INET,
+		syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	err = syscall.SetsockoptString(fd, syscall.IPPROTO_TCP, tcpULP, "tls")
+	return err == nil || err == syscall.ENOTCONN
+}
+
+// tcpSocket returns the socket of conn if OpenSSL can use it for kernel TLS.
+func tcpSocket(conn net.Conn) (raw syscall.RawConn, fd int, ok bool) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || !ktls_support || !ktlsAvailable() {
+		return nil, 0, false
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return nil, 0, false
+	}
+	err = raw.Control(func(s uintptr) {
+		fd = int(s)
+	})
+	if err != nil {
+		return nil, 0, false
+	}
+	return raw, fd, true
+}
+
+// socketReady polls fd without blocking and reports whether it is readable,
+// or writable if write is set. Errors and hangups count as ready, the next
+// call into OpenSSL reports them.
+func socketReady(fd uintptr, write bool) bool {
+	const (
+		pollIn  = 0x1
+		pollOut = 0x4
+	)
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{fd: int32(fd), events: pollIn}
+	if write {
+		pfd.events = pollOut
+	}
+	var timeout syscall.Timespec
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL,
+		uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&timeout)),
+		0, 0, 0)
+	return errno != 0 || n > 0
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package openssl
+
+import (
+	"net"
+	"syscall"
+)
+
+var ktlsAvailable = func() bool { return false }
+
+// tcpSocket reports that kernel TLS is unavailable; it is Linux only.
+func tcpSocket(conn net.Conn) (raw syscall.RawConn, fd int, ok bool) {
+	return nil, 0, false
+}
+
+func socketReady(fd uintptr, write bool) bool {
+	return true
+}
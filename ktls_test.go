@@ -0,0 +1,204 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func KTLSConstructor(t testing.TB, server_conn, client_conn net.Conn) (
+	server, client HandshakingConn) {
+	return ktlsConstructor(t, server_conn, client_conn, true)
+}
+
+// setKTLSAvailable overrides the probe of the kernel tls module, so that both
+// connection paths are covered whatever the kernel.
+func setKTLSAvailable(t testing.TB, available bool) {
+	saved := ktlsAvailable
+	ktlsAvailable = func() bool { return available }
+	t.Cleanup(func() { ktlsAvailable = saved })
+}
+
+func ktlsConstructor(t testing.TB, server_conn, client_conn net.Conn,
+	available bool) (server, client HandshakingConn) {
+	setKTLSAvailable(t, available)
+	// OpenSSL 3.0 only offloads the receive side with TLS 1.2
+	ctx, err := NewCtxWithVersion(TLSv1_2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetVerify(VerifyNone, passThruVerify(t))
+	ctx.SetOptions(EnableKTLS)
+	key, err := LoadPrivateKeyFromPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.UsePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificateFromPEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.UseCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the kernel only implements AES-GCM and ChaCha20-Poly1305
+	err = ctx.SetCipherList("ECDHE-RSA-AES128-GCM-SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = Server(server_conn, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = Client(client_conn, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ktls_support && runtime.GOOS == "linux" {
+		if available &&
+			(server.(*Conn).raw == nil || client.(*Conn).raw == nil) {
+			t.Fatal("kTLS enabled but connection uses memory BIOs")
+		}
+	}
+	if !available &&
+		(server.(*Conn).raw != nil || client.(*Conn).raw != nil) {
+		t.Fatal("kTLS unavailable but connection uses the socket")
+	}
+	return server, client
+}
+
+func TestKTLSSimple(t *testing.T) {
+	SimpleConnTest(t, KTLSConstructor)
+}
+
+func TestKTLSUnavailable(t *testing.T) {
+	SimpleConnTest(t, func(t testing.TB, server_conn, client_conn net.Conn) (
+		server, client HandshakingConn) {
+		return ktlsConstructor(t, server_conn, client_conn, false)
+	})
+}
+
+func TestKTLSClosing(t *testing.T) {
+	ClosingTest(t, KTLSConstructor)
+}
+
+func TestKTLSStdlibSimple(t *testing.T) {
+	SimpleConnTest(t, func(t testing.TB, server_conn, client_conn net.Conn) (
+		server, client HandshakingConn) {
+		server, _ = KTLSConstructor(t, server_conn, client_conn)
+		client = tls.Client(client_conn, &tls.Config{InsecureSkipVerify: true})
+		return server, client
+	})
+}
+
+func TestKTLSTruncation(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := KTLSConstructor(t, server_conn, client_conn)
+	server.(*Conn).GetCtx().SetReportTruncation(true)
+	handshakeBoth(t, server, client)
+
+	client_conn.Close()
+	_, err := server.Read(make([]byte, 16))
+	if err != ErrTruncated {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+}
+
+func readFromTest(t *testing.T, constructor func(
+	t testing.TB, conn1, conn2 net.Conn) (sslconn1, sslconn2 HandshakingConn)) {
+	data := make([]byte, 3*maxSendfileSize/2+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "openssl-readfrom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	// start from an offset to check ReadFrom honors the file position
+	const offset = 17
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	server_conn, client_conn := NetPipe(t)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := constructor(t, server_conn, client_conn)
+	defer close_both(server, client)
+	handshakeBoth(t, server, client)
+	t.Logf("kTLS tx offload: %v, rx offload: %v",
+		server.(*Conn).KTLSSendActive(), client.(*Conn).KTLSRecvActive())
+
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := server.(*Conn).ReadFrom(f)
+		done <- result{n, err}
+	}()
+
+	got := make([]byte, len(data)-offset)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatal(err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.n != int64(len(got)) {
+		t.Fatalf("ReadFrom sent %d bytes, expected %d", res.n, len(got))
+	}
+	if !bytes.Equal(got, data[offset:]) {
+		t.Fatal("received data does not match the file")
+	}
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != int64(len(data)) {
+		t.Fatalf("file offset is %d, expected %d", pos, len(data))
+	}
+}
+
+func TestOpenSSLReadFrom(t *testing.T) {
+	readFromTest(t, OpenSSLConstructor)
+}
+
+func TestKTLSReadFrom(t *testing.T) {
+	readFromTest(t, KTLSConstructor)
+}
@@ -0,0 +1,70 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger receives the messages logged by the package. Critf is called right
+// before the process exits because a callback panicked.
+type Logger interface {
+	Critf(format string, args ...interface{})
+}
+
+type loggerHolder struct {
+	l Logger
+}
+
+var current_logger atomic.Pointer[loggerHolder]
+
+// logger forwards to the Logger installed with SetLogger.
+var logger packageLogger
+
+type packageLogger struct{}
+
+func (packageLogger) Critf(format string, args ...interface{}) {
+	if h := current_logger.Load(); h != nil {
+		h.l.Critf(format, args...)
+		return
+	}
+	NewSlogLogger(slog.Default()).Critf(format, args...)
+}
+
+// SetLogger installs the Logger used by the package. By default, messages go
+// to slog.Default(). A nil l restores the default.
+func SetLogger(l Logger) {
+	if l == nil {
+		current_logger.Store(nil)
+		return
+	}
+	current_logger.Store(&loggerHolder{l: l})
+}
+
+// NewSlogLogger returns a Logger writing to l at slog.LevelError.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Critf(format string, args ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, fmt.Sprintf(format, args...))
+}
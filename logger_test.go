@@ -0,0 +1,52 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) Critf(format string, args ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	var l recordingLogger
+	SetLogger(&l)
+	defer SetLogger(nil)
+	logger.Critf("callback panic'd: %v", "boom")
+	if len(l.msgs) != 1 || l.msgs[0] != "callback panic'd: boom" {
+		t.Fatalf("unexpected messages %q", l.msgs)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	l.Critf("callback panic'd: %v", "boom")
+	out := buf.String()
+	if !strings.Contains(out, "level=ERROR") ||
+		!strings.Contains(out, "callback panic'd: boom") {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"runtime"
+	"unsafe"
+)
+
+var evp_mac_support = C.X_EVP_MAC_SUPPORT != 0
+
+// MACAlgorithm is the name of a message authentication code.
+type MACAlgorithm string
+
+const (
+	// MACHMAC needs MACOptions.Digest.
+	MACHMAC MACAlgorithm = "HMAC"
+	// MACCMAC needs MACOptions.Cipher, a block cipher in CBC mode.
+	MACCMAC MACAlgorithm = "CMAC"
+	// MACGMAC needs MACOptions.Cipher, in GCM mode, and MACOptions.IV.
+	MACGMAC     MACAlgorithm = "GMAC"
+	MACPoly1305 MACAlgorithm = "POLY1305"
+	// MACKMAC128 and MACKMAC256 take MACOptions.Customization and
+	// MACOptions.Size.
+	MACKMAC128 MACAlgorithm = "KMAC-128"
+	MACKMAC256 MACAlgorithm = "KMAC-256"
+	// MACSipHash takes MACOptions.Size, 8 or 16.
+	MACSipHash MACAlgorithm = "SIPHASH"
+	// MACBLAKE2b and MACBLAKE2s take MACOptions.Customization as
+	// personalization and MACOptions.Size.
+	MACBLAKE2b MACAlgorithm = "BLAKE2BMAC"
+	MACBLAKE2s MACAlgorithm = "BLAKE2SMAC"
+)
+
+// MACOptions holds the parameters of a MAC. Which apply depends on the
+// algorithm.
+type MACOptions struct {
+	Digest        *Digest
+	Cipher        *Cipher
+	IV            []byte
+	Customization []byte
+	// Size is the length of the tag in bytes, 0 for the default.
+	Size int
+}
+
+// MAC computes a message authentication code. It implements hash.Hash, Sum
+// leaves the state unchanged.
+//
+// OpenSSL 3.0 supports all the algorithms. Before, only HMAC is supported,
+// as well as CMAC, Poly1305 and SipHash with their default tag size since
+// 1.1.1.
+type MAC struct {
+	m          *C.X_MAC
+	algorithm  MACAlgorithm
+	size       int
+	block_size int
+}
+
+var _ hash.Hash = (*MAC)(nil)
+
+// NewMAC returns a MAC of algorithm keyed with key. opts may be nil for
+// algorithms without parameters.
+func NewMAC(algorithm MACAlgorithm, key []byte, opts *MACOptions) (*MAC,
+	error) {
+	if opts == nil {
+		opts = &MACOptions{}
+	}
+	var md *C.EVP_MD
+	var cipher *C.EVP_CIPHER
+	block_size := 1
+	switch algorithm {
+	case MACHMAC:
+		if opts.Digest == nil {
+			return nil, errors.New("openssl: HMAC needs a digest")
+		}
+		md = opts.Digest.ptr
+		block_size = opts.Digest.BlockSize()
+	case MACCMAC, MACGMAC:
+		if opts.Cipher == nil {
+			return nil, fmt.Errorf("openssl: %s needs a cipher", algorithm)
+		}
+		cipher = opts.Cipher.ptr
+		block_size = opts.Cipher.BlockSize()
+	case MACPoly1305:
+		block_size = 16
+	case MACKMAC128:
+		block_size = 168
+	case MACKMAC256:
+		block_size = 136
+	case MACSipHash:
+		block_size = 8
+	case MACBLAKE2b:
+		block_size = 128
+	case MACBLAKE2s:
+		block_size = 64
+	}
+	if opts.Size < 0 {
+		return nil, fmt.Errorf("openssl: invalid MAC size %d", opts.Size)
+	}
+
+	calg := C.CString(string(algorithm))
+	defer C.free(unsafe.Pointer(calg))
+	// a NULL key would mean no key rather than an empty one
+	var empty C.uchar
+	ckey := &empty
+	if len(key) > 0 {
+		ckey = (*C.uchar)(unsafe.Pointer(&key[0]))
+	}
+	var res C.X_result
+	defer releaseResult(&res)
+	m := C.X_MAC_new(calg, ckey, C.size_t(len(key)), md, cipher,
+		bytePtr(opts.IV), C.size_t(len(opts.IV)),
+		bytePtr(opts.Customization), C.size_t(len(opts.Customization)),
+		C.size_t(opts.Size), &res)
+	if m == nil {
+		if res.nerrs == 0 {
+			return nil, fmt.Errorf("openssl: MAC %s is not supported",
+				algorithm)
+		}
+		return nil, errorFromResult(&res)
+	}
+	return newMAC(m, algorithm, block_size), nil
+}
+
+// NewHMACFromDigest returns an HMAC with any digest, unlike NewHMAC.
+func NewHMACFromDigest(key []byte, digest *Digest) (*MAC, error) {
+	return NewMAC(MACHMAC, key, &MACOptions{Digest: digest})
+}
+
+func newMAC(m *C.X_MAC, algorithm MACAlgorithm, block_size int) *MAC {
+	mac := &MAC{
+		m:          m,
+		algorithm:  algorithm,
+		size:       int(C.X_MAC_size(m)),
+		block_size: block_size,
+	}
+	runtime.SetFinalizer(mac, func(mac *MAC) { mac.Close() })
+	return mac
+}
+
+// Close releases the MAC. It must not be used afterwards.
+func (mac *MAC) Close() {
+	if mac.m != nil {
+		C.X_MAC_free(mac.m)
+		mac.m = nil
+	}
+}
+
+// Algorithm returns the algorithm of the MAC.
+func (mac *MAC) Algorithm() MACAlgorithm {
+	return mac.algorithm
+}
+
+// Write adds p to the MAC. It never returns an error.
+func (mac *MAC) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if 1 != C.X_MAC_update(mac.m, unsafe.Pointer(&p[0]),
+		C.size_t(len(p))) {
+		panic(fmt.Sprintf("openssl: %s: cannot update MAC", mac.algorithm))
+	}
+	return len(p), nil
+}
+
+// Sum appends the tag of the data written so far to b.
+func (mac *MAC) Sum(b []byte) []byte {
+	tag := make([]byte, mac.size)
+	if 1 != C.X_MAC_sum(mac.m, (*C.uchar)(unsafe.Pointer(&tag[0])),
+		C.size_t(len(tag))) {
+		panic(fmt.Sprintf("openssl: %s: cannot finalize MAC", mac.algorithm))
+	}
+	return append(b, tag...)
+}
+
+// Verify reports whether tag is the tag of the data written so far, in
+// constant time.
+func (mac *MAC) Verify(tag []byte) bool {
+	return subtle.ConstantTimeCompare(mac.Sum(nil), tag) == 1
+}
+
+// Reset restarts the MAC with the same key.
+func (mac *MAC) Reset() {
+	if 1 != C.X_MAC_reset(mac.m) {
+		panic(fmt.Sprintf("openssl: %s: cannot reset MAC", mac.algorithm))
+	}
+}
+
+// Size returns the length of the tag.
+func (mac *MAC) Size() int {
+	return mac.size
+}
+
+func (mac *MAC) BlockSize() int {
+	return mac.block_size
+}
+
+// Clone returns an independent copy of the MAC in its current state.
+func (mac *MAC) Clone() (*MAC, error) {
+	m := C.X_MAC_dup(mac.m)
+	if m == nil {
+		return nil, fmt.Errorf("openssl: %s: cannot copy MAC", mac.algorithm)
+	}
+	return newMAC(m, mac.algorithm, mac.block_size), nil
+}
+
+func bytePtr(b []byte) *C.uchar {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.uchar)(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,237 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha3"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMACHMAC(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() hash.Hash
+	}{
+		{"SHA256", sha256.New},
+		{"SHA3-256", func() hash.Hash { return sha3.New256() }},
+	}
+	for _, test := range tests {
+		digest, err := GetDigestByName(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range [][]byte{nil, []byte("key"), bytes.Repeat(
+			[]byte("long key"), 40)} {
+			mac, err := NewHMACFromDigest(key, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := hmac.New(test.new, key)
+			testSHA2(t, func(data []byte) {
+				mac.Reset()
+				expected.Reset()
+				mac.Write(data)
+				expected.Write(data)
+				if !bytes.Equal(mac.Sum(nil), expected.Sum(nil)) {
+					t.Fatalf("%s: exp:%x got:%x", test.name, expected.Sum(nil),
+						mac.Sum(nil))
+				}
+			})
+			if mac.BlockSize() != expected.BlockSize() {
+				t.Fatalf("%s: block size %d", test.name, mac.BlockSize())
+			}
+			mac.Close()
+		}
+	}
+}
+
+func TestMACVectors(t *testing.T) {
+	if !evp_mac_support {
+		t.Skip("EVP_MAC is not supported")
+	}
+	aes_cbc, err := GetCipherByName("AES-128-CBC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kmac_key := mustHex(t, "404142434445464748494a4b4c4d4e4f"+
+		"505152535455565758595a5b5c5d5e5f")
+	key := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		return b
+	}
+	tests := []struct {
+		name      string
+		algorithm MACAlgorithm
+		key       []byte
+		opts      *MACOptions
+		data      string
+		tag       string
+	}{
+		// RFC 4493 example 2
+		{"CMAC", MACCMAC, mustHex(t, "2b7e151628aed2a6abf7158809cf4f3c"),
+			&MACOptions{Cipher: aes_cbc},
+			"6bc1bee22e409f96e93d7e117393172a",
+			"070a16b46b4d4144f79bdd9dd04a287c"},
+		// RFC 8439 section 2.5.2
+		{"Poly1305", MACPoly1305, mustHex(t, "85d6be7857556d337f4452fe42d506a8"+
+			"0103808afb0db2fd4abff6af4149f51b"), nil,
+			hex.EncodeToString([]byte("Cryptographic Forum Research Group")),
+			"a8061dc1305136c6c22b8baf0c0127a9"},
+		// NIST SP 800-185 KMAC samples 1, 2 and 5
+		{"KMAC128", MACKMAC128, kmac_key, &MACOptions{Size: 32}, "00010203",
+			"e5780b0d3ea6f7d3a429c5706aa43a00fadbd7d49628839e3187243f456ee14e"},
+		{"KMAC128 custom", MACKMAC128, kmac_key, &MACOptions{Size: 32,
+			Customization: []byte("My Tagged Application")}, "00010203",
+			"3b1fba963cd8b0b59e8c1a6d71888b7143651af8ba0a7070c0979e2811324aa5"},
+		{"KMAC256", MACKMAC256, kmac_key, &MACOptions{Size: 64,
+			Customization: []byte("My Tagged Application")}, "00010203",
+			"20c570c31346f703c9ac36c61c03cb64c3970d0cfc787e9b79599d273a68d2f7" +
+				"f69d4cc3de9d104a351689f27cf6f5951f0103f33f4f24871024d9c27773a8dd"},
+		// the reference implementation vectors
+		{"SipHash-2-4-64", MACSipHash, key(16), &MACOptions{Size: 8}, "",
+			"310e0edd47db6f72"},
+		{"SipHash-2-4-128", MACSipHash, key(16), nil, "",
+			"a3817f04ba25a8e66df67214c7550293"},
+		{"BLAKE2b", MACBLAKE2b, key(64), nil, "",
+			"10ebb67700b1868efb4417987acf4690ae9d972fb7a590c2f02871799aaa4786" +
+				"b5e996e8f0f4eb981fc214b005f42d2ff4233499391653df7aefcbc13fc51568"},
+		{"BLAKE2s", MACBLAKE2s, key(32), nil, "",
+			"48a8997da407876b3d79c0d92325ad3b89cbb754d86ab71aee047ad345fd2c49"},
+	}
+	for _, test := range tests {
+		mac, err := NewMAC(test.algorithm, test.key, test.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		mac.Write(mustHex(t, test.data))
+		tag := mustHex(t, test.tag)
+		if got := mac.Sum(nil); !bytes.Equal(got, tag) {
+			t.Fatalf("%s: exp:%x got:%x", test.name, tag, got)
+		}
+		if !mac.Verify(tag) {
+			t.Fatalf("%s: tag not verified", test.name)
+		}
+		tag[0] ^= 1
+		if mac.Verify(tag) {
+			t.Fatalf("%s: wrong tag verified", test.name)
+		}
+		tag[0] ^= 1
+		// Reset after a Write starts over with the same key and parameters
+		mac.Write([]byte("discarded"))
+		mac.Reset()
+		mac.Write(mustHex(t, test.data))
+		if got := mac.Sum(nil); !bytes.Equal(got, tag) {
+			t.Fatalf("%s: after Reset exp:%x got:%x", test.name, tag, got)
+		}
+		mac.Close()
+	}
+}
+
+func TestMACGMAC(t *testing.T) {
+	if !evp_mac_support {
+		t.Skip("EVP_MAC is not supported")
+	}
+	aes_gcm, err := GetCipherByName("aes-128-gcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("0123456789abcdef")
+	iv := []byte("unique nonce")
+	data := []byte("authenticated but not encrypted")
+	mac, err := NewMAC(MACGMAC, key, &MACOptions{Cipher: aes_gcm, IV: iv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mac.Close()
+	mac.Write(data)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := gcm.Seal(nil, iv, nil, data)
+	if !mac.Verify(expected) {
+		t.Fatalf("exp:%x got:%x", expected, mac.Sum(nil))
+	}
+}
+
+func TestMACClone(t *testing.T) {
+	digest, err := GetDigestByName("SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, err := NewHMACFromDigest([]byte("key"), digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mac.Close()
+	mac.Write([]byte("common prefix"))
+	clone, err := mac.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	mac.Write([]byte("one"))
+	clone.Write([]byte("two"))
+
+	expected := hmac.New(sha256.New, []byte("key"))
+	expected.Write([]byte("common prefixtwo"))
+	if !clone.Verify(expected.Sum(nil)) {
+		t.Fatal("clone does not continue from the state")
+	}
+	if mac.Verify(expected.Sum(nil)) {
+		t.Fatal("clone shares the state")
+	}
+	// Reset keeps the key
+	clone.Reset()
+	clone.Write([]byte("common prefixtwo"))
+	if !clone.Verify(expected.Sum(nil)) {
+		t.Fatal("reset lost the key")
+	}
+}
+
+func TestMACInvalid(t *testing.T) {
+	if _, err := NewMAC(MACHMAC, []byte("key"), nil); err == nil {
+		t.Fatal("expected HMAC without a digest to fail")
+	}
+	if _, err := NewMAC(MACCMAC, []byte("key"), nil); err == nil {
+		t.Fatal("expected CMAC without a cipher to fail")
+	}
+	if _, err := NewMAC("NO-SUCH-MAC", []byte("key"), nil); err == nil {
+		t.Fatal("expected an unknown MAC to fail")
+	}
+}
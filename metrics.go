@@ -0,0 +1,187 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"time"
+)
+
+// Metrics receives measurements of the connections of a context. It is
+// called from the goroutines using the connections, so implementations must
+// be safe for concurrent use and should not block. Embed NopMetrics to only
+// implement some of the methods.
+type Metrics interface {
+	// HandshakeCompleted is called once the first handshake of c
+	// succeeded. resumed reports whether a session was resumed.
+	HandshakeCompleted(c *Conn, duration time.Duration, resumed bool)
+	// HandshakeFailed is called once the first handshake of c failed.
+	HandshakeFailed(c *Conn, duration time.Duration, cause HandshakeCause)
+	// BytesRead is called with the number of plaintext bytes read from c.
+	BytesRead(c *Conn, n int)
+	// BytesWritten is called with the number of plaintext bytes written to
+	// c.
+	BytesWritten(c *Conn, n int)
+	// Renegotiated is called for every renegotiation on c, by either peer.
+	Renegotiated(c *Conn)
+}
+
+// NopMetrics is a Metrics that discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) HandshakeCompleted(*Conn, time.Duration, bool)        {}
+func (NopMetrics) HandshakeFailed(*Conn, time.Duration, HandshakeCause) {}
+func (NopMetrics) BytesRead(*Conn, int)                                 {}
+func (NopMetrics) BytesWritten(*Conn, int)                              {}
+func (NopMetrics) Renegotiated(*Conn)                                   {}
+
+type metricsHolder struct {
+	m Metrics
+}
+
+// SetMetrics installs m to receive the measurements of connections created
+// from the context afterwards. A nil m disables them.
+func (c *Ctx) SetMetrics(m Metrics) {
+	if m == nil {
+		c.metrics.Store(nil)
+		return
+	}
+	c.metrics.Store(&metricsHolder{m: m})
+}
+
+// currentMetrics returns the Metrics that new connections report to.
+func (c *Ctx) currentMetrics() Metrics {
+	if h := c.metrics.Load(); h != nil {
+		return h.m
+	}
+	return nil
+}
+
+// CtxStats is a snapshot of the session counters OpenSSL keeps for a context.
+// See https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_sess_number.html
+type CtxStats struct {
+	// Sessions is the number of sessions in the internal session cache.
+	Sessions int64
+	// Connect, ConnectGood and ConnectRenegotiate count the handshakes
+	// started, completed and renegotiated in client mode.
+	Connect            int64
+	ConnectGood        int64
+	ConnectRenegotiate int64
+	// Accept, AcceptGood and AcceptRenegotiate count the handshakes
+	// started, completed and renegotiated in server mode.
+	Accept            int64
+	AcceptGood        int64
+	AcceptRenegotiate int64
+	// Hits counts sessions resumed from the internal cache, CallbackHits
+	// those resumed from an external cache, and Misses the sessions
+	// proposed by clients that were not found.
+	Hits         int64
+	CallbackHits int64
+	Misses       int64
+	// Timeouts counts sessions proposed by clients that had expired.
+	Timeouts int64
+	// CacheFull counts sessions dropped because the cache was full.
+	CacheFull int64
+}
+
+// Stats returns the current session counters of the context.
+func (c *Ctx) Stats() CtxStats {
+	var stats [12]C.long
+	C.X_SSL_CTX_get_stats(c.ctx, &stats[0])
+	return CtxStats{
+		Sessions:           int64(stats[0]),
+		Connect:            int64(stats[1]),
+		ConnectGood:        int64(stats[2]),
+		ConnectRenegotiate: int64(stats[3]),
+		Accept:             int64(stats[4]),
+		AcceptGood:         int64(stats[5]),
+		AcceptRenegotiate:  int64(stats[6]),
+		Hits:               int64(stats[7]),
+		CallbackHits:       int64(stats[8]),
+		Misses:             int64(stats[9]),
+		Timeouts:           int64(stats[10]),
+		CacheFull:          int64(stats[11]),
+	}
+}
+
+// handshakeStarted records when the first handshake began.
+func (c *Conn) handshakeStarted() {
+	if c.metrics == nil {
+		return
+	}
+	c.mtx.Lock()
+	if c.hs_start.IsZero() {
+		c.hs_start = time.Now()
+	}
+	c.mtx.Unlock()
+}
+
+// handshakeFinished reports the outcome of the first handshake once. err is
+// the error of the I/O call that ran it.
+func (c *Conn) handshakeFinished(err error) {
+	m := c.metrics
+	if m == nil {
+		return
+	}
+	c.mtx.Lock()
+	if c.hs_reported {
+		c.mtx.Unlock()
+		return
+	}
+	c.hs_reported = true
+	duration := time.Since(c.hs_start)
+	resumed := C.X_SSL_session_reused(c.ssl) == 1
+	if err != nil && C.X_SSL_is_init_finished(c.ssl) == 1 {
+		// the handshake went through, the call failed afterwards
+		err = nil
+	}
+	c.mtx.Unlock()
+
+	if err == nil {
+		m.HandshakeCompleted(c, duration, resumed)
+		return
+	}
+	var herr *HandshakeError
+	if !errors.As(err, &herr) {
+		herr = c.handshakeError(err).(*HandshakeError)
+	}
+	m.HandshakeFailed(c, duration, herr.Cause)
+}
+
+// ioDone reports a successful read or write of n bytes, along with the
+// handshakes it ran.
+func (c *Conn) ioDone(n int, write bool) {
+	m := c.metrics
+	if m == nil {
+		return
+	}
+	c.handshakeFinished(nil)
+	if write {
+		m.BytesWritten(c, n)
+	} else {
+		m.BytesRead(c, n)
+	}
+	c.mtx.Lock()
+	renegotiations := int(C.X_SSL_get_renegotiations(c.ssl))
+	reported := c.renegotiations
+	c.renegotiations = renegotiations
+	c.mtx.Unlock()
+	for ; reported < renegotiations; reported++ {
+		m.Renegotiated(c)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	NopMetrics
+	mu        sync.Mutex
+	completed int
+	failed    []HandshakeCause
+	read      int
+	written   int
+}
+
+func (m *recordingMetrics) HandshakeCompleted(c *Conn, d time.Duration,
+	resumed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completed++
+}
+
+func (m *recordingMetrics) HandshakeFailed(c *Conn, d time.Duration,
+	cause HandshakeCause) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed = append(m.failed, cause)
+}
+
+func (m *recordingMetrics) BytesRead(c *Conn, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read += n
+}
+
+func (m *recordingMetrics) BytesWritten(c *Conn, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written += n
+}
+
+func TestMetrics(t *testing.T) {
+	var m recordingMetrics
+	server_ctx := newTestCtx(t, AnyVersion, true)
+	server_ctx.SetMetrics(&m)
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	client_ctx.SetMetrics(&m)
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+
+	// the handshake runs implicitly, as part of the first write and read
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Write([]byte("hello"))
+		errs <- err
+	}()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completed != 2 || len(m.failed) != 0 {
+		t.Fatalf("%d handshakes completed, failed %v", m.completed, m.failed)
+	}
+	if m.read != 5 || m.written != 5 {
+		t.Fatalf("read %d, written %d", m.read, m.written)
+	}
+}
+
+func TestMetricsSetDuringIO(t *testing.T) {
+	var m, later recordingMetrics
+	server_ctx := newTestCtx(t, AnyVersion, true)
+	server_ctx.SetMetrics(&m)
+	client_ctx := newTestCtx(t, AnyVersion, false)
+
+	server_conn, client_conn := NetPipe(t)
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Write([]byte("hello"))
+		errs <- err
+	}()
+	// existing connections keep reporting to the metrics they started with
+	go server_ctx.SetMetrics(&later)
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completed != 1 || m.read != 5 {
+		t.Fatalf("%d handshakes completed, read %d", m.completed, m.read)
+	}
+	later.mu.Lock()
+	defer later.mu.Unlock()
+	if later.completed != 0 || later.read != 0 {
+		t.Fatal("metrics set afterwards received measurements")
+	}
+}
+
+func TestMetricsHandshakeFailed(t *testing.T) {
+	var m recordingMetrics
+	server_ctx := newTestCtx(t, TLSv1_2, true)
+	server_ctx.SetMetrics(&m)
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	client_ctx.SetOptions(NoTLSv12)
+	client_ctx.SetMetrics(&m)
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	if err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.completed != 0 || len(m.failed) != 2 {
+		t.Fatalf("%d handshakes completed, failed %v", m.completed, m.failed)
+	}
+	for _, cause := range m.failed {
+		if cause != HandshakeCauseVersion {
+			t.Fatalf("unexpected cause %s", cause)
+		}
+	}
+}
+
+func TestCtxStats(t *testing.T) {
+	server_ctx := newTestCtx(t, AnyVersion, true)
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close_both(server, client)
+
+	stats := server_ctx.Stats()
+	if stats.Accept != 1 || stats.AcceptGood != 1 || stats.Connect != 0 {
+		t.Fatalf("unexpected server stats %+v", stats)
+	}
+	stats = client_ctx.Stats()
+	if stats.Connect != 1 || stats.ConnectGood != 1 || stats.Accept != 0 {
+		t.Fatalf("unexpected client stats %+v", stats)
+	}
+}
@@ -17,11 +17,13 @@ package openssl
 import (
 	"errors"
 	"net"
+	"sync"
+	"time"
 )
 
 type listener struct {
 	net.Listener
-	ctx *Ctx
+	ctx func() *Ctx
 }
 
 func (l *listener) Accept() (c net.Conn, err error) {
@@ -29,7 +31,7 @@ func (l *listener) Accept() (c net.Conn, err error) {
 	if err != nil {
 		return nil, err
 	}
-	ssl_c, err := Server(c, l.ctx)
+	ssl_c, err := Server(c, l.ctx())
 	if err != nil {
 		c.Close()
 		return nil, err
@@ -43,7 +45,7 @@ func (l *listener) Accept() (c net.Conn, err error) {
 func NewListener(inner net.Listener, ctx *Ctx) net.Listener {
 	return &listener{
 		Listener: inner,
-		ctx:      ctx}
+		ctx:      func() *Ctx { return ctx }}
 }
 
 // Listen is a wrapper around net.Listen that wraps incoming connections with
@@ -59,6 +61,171 @@ func Listen(network, laddr string, ctx *Ctx) (net.Listener, error) {
 	return NewListener(l, ctx), nil
 }
 
+// defaultMaxHandshakes is used when ListenerConfig.MaxHandshakes is zero.
+const defaultMaxHandshakes = 256
+
+// ListenerConfig configures how a listener created by NewListenerWithConfig
+// or ListenWithConfig handshakes the connections it accepts.
+type ListenerConfig struct {
+	// HandshakeTimeout bounds the time a connection may take to complete its
+	// handshake, counted from when it was accepted. Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// MaxHandshakes bounds the number of handshakes in progress at once,
+	// including handshaken connections not yet returned by Accept. The
+	// listener stops accepting while the limit is reached. Zero means 256.
+	MaxHandshakes int
+
+	// OnHandshakeError, if not nil, is called with the remote address and
+	// the error of every connection whose handshake failed. The connection
+	// has been closed already. It is called from the handshaking goroutine,
+	// so it must be safe for concurrent use.
+	OnHandshakeError func(remote net.Addr, err error)
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+type handshakingListener struct {
+	net.Listener
+	ctx        func() *Ctx
+	config     ListenerConfig
+	slots      chan struct{}
+	results    chan acceptResult
+	done       chan struct{}
+	close_once sync.Once
+}
+
+func (l *handshakingListener) run() {
+	for {
+		select {
+		case l.slots <- struct{}{}:
+		case <-l.done:
+			return
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			<-l.slots
+			// hand the error to Accept and retry only once it has been
+			// consumed, so the caller decides whether to keep accepting
+			select {
+			case l.results <- acceptResult{err: err}:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *handshakingListener) handshake(c net.Conn) {
+	defer func() { <-l.slots }()
+	conn, err := l.serverHandshake(c)
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		} else {
+			c.Close()
+		}
+		if l.config.OnHandshakeError != nil {
+			l.config.OnHandshakeError(c.RemoteAddr(), err)
+		}
+		return
+	}
+	select {
+	case l.results <- acceptResult{conn: conn}:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *handshakingListener) serverHandshake(c net.Conn) (*Conn, error) {
+	conn, err := Server(c, l.ctx())
+	if err != nil {
+		return nil, err
+	}
+	timeout := l.config.HandshakeTimeout
+	if timeout > 0 {
+		err = c.SetDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return conn, err
+		}
+	}
+	err = conn.Handshake()
+	if err != nil {
+		return conn, err
+	}
+	if timeout > 0 {
+		err = c.SetDeadline(time.Time{})
+		if err != nil {
+			return conn, err
+		}
+	}
+	return conn, nil
+}
+
+// Accept returns the next connection that completed its handshake.
+func (l *handshakingListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-l.results:
+		return r.conn, r.err
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(),
+			Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close closes the underlying listener. Handshakes in progress run to
+// completion or timeout, after which their connections are closed.
+func (l *handshakingListener) Close() error {
+	l.close_once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// NewListenerWithConfig is like NewListener, but the returned listener
+// handshakes accepted connections eagerly in the background as configured by
+// config, and its Accept method only returns connections that completed their
+// handshake.
+func NewListenerWithConfig(inner net.Listener, ctx *Ctx,
+	config ListenerConfig) net.Listener {
+	return newHandshakingListener(inner, func() *Ctx { return ctx }, config)
+}
+
+func newHandshakingListener(inner net.Listener, ctx func() *Ctx,
+	config ListenerConfig) net.Listener {
+
+	max_handshakes := config.MaxHandshakes
+	if max_handshakes <= 0 {
+		max_handshakes = defaultMaxHandshakes
+	}
+	l := &handshakingListener{
+		Listener: inner,
+		ctx:      ctx,
+		config:   config,
+		slots:    make(chan struct{}, max_handshakes),
+		results:  make(chan acceptResult),
+		done:     make(chan struct{})}
+	go l.run()
+	return l
+}
+
+// ListenWithConfig is like Listen, but uses NewListenerWithConfig to wrap the
+// listener.
+func ListenWithConfig(network, laddr string, ctx *Ctx,
+	config ListenerConfig) (net.Listener, error) {
+	if ctx == nil {
+		return nil, errors.New("no ssl context provided")
+	}
+	l, err := net.Listen(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListenerWithConfig(l, ctx, config), nil
+}
+
 type DialFlags int
 
 const (
@@ -0,0 +1,217 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type handshakeFailure struct {
+	remote net.Addr
+	err    error
+}
+
+func listenWithConfig(t *testing.T, config ListenerConfig) (
+	net.Listener, chan handshakeFailure) {
+	ctx, err := NewCtxFromKeys(certBytes, keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := make(chan handshakeFailure, 10)
+	config.OnHandshakeError = func(remote net.Addr, err error) {
+		failures <- handshakeFailure{remote: remote, err: err}
+	}
+	l, err := ListenWithConfig("tcp", "localhost:0", ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, failures
+}
+
+func dialListener(t *testing.T, l net.Listener) *Conn {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Dial(l.Addr().Network(), l.Addr().String(), ctx,
+		InsecureSkipHostVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func acceptTimeout(t *testing.T, l net.Listener, timeout time.Duration) (
+	net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- result{conn, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for Accept")
+		return nil, nil
+	}
+}
+
+func TestListenerConfigHandshake(t *testing.T) {
+	l, failures := listenWithConfig(t, ListenerConfig{
+		HandshakeTimeout: 5 * time.Second})
+	defer l.Close()
+
+	client := dialListener(t, l)
+	defer client.Close()
+
+	conn, err := acceptTimeout(t, l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the handshake has happened before Accept returned
+	if _, err := conn.(*Conn).CurrentCipher(); err != nil {
+		t.Fatal(err)
+	}
+
+	go client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("read %q", buf)
+	}
+	select {
+	case f := <-failures:
+		t.Fatalf("unexpected handshake failure: %v", f.err)
+	default:
+	}
+}
+
+func TestListenerConfigHandshakeFailure(t *testing.T) {
+	l, failures := listenWithConfig(t, ListenerConfig{
+		HandshakeTimeout: 5 * time.Second})
+	defer l.Close()
+
+	bad, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	if _, err := bad.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case f := <-failures:
+		if f.err == nil {
+			t.Fatal("expected an error")
+		}
+		if f.remote.String() != bad.LocalAddr().String() {
+			t.Fatalf("failure reported for %s, expected %s", f.remote,
+				bad.LocalAddr())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake failure was not reported")
+	}
+
+	// the failed connection is never returned by Accept
+	client := dialListener(t, l)
+	defer client.Close()
+	conn, err := acceptTimeout(t, l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Fatalf("accepted %s, expected %s", conn.RemoteAddr(),
+			client.LocalAddr())
+	}
+}
+
+func TestListenerConfigHandshakeTimeout(t *testing.T) {
+	l, failures := listenWithConfig(t, ListenerConfig{
+		HandshakeTimeout: 100 * time.Millisecond,
+		MaxHandshakes:    1})
+	defer l.Close()
+
+	// a client that never starts a handshake holds the only slot until it
+	// times out
+	stalled, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+
+	select {
+	case f := <-failures:
+		if nerr, ok := f.err.(net.Error); !ok || !nerr.Timeout() {
+			t.Fatalf("expected a timeout, got %v", f.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake timeout was not reported")
+	}
+
+	client := dialListener(t, l)
+	defer client.Close()
+	conn, err := acceptTimeout(t, l, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the handshake deadline must not leak into the accepted connection
+	time.Sleep(200 * time.Millisecond)
+	go client.Write([]byte("x"))
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenerConfigClose(t *testing.T) {
+	l, _ := listenWithConfig(t, ListenerConfig{})
+	l.Close()
+	_, err := acceptTimeout(t, l, 5*time.Second)
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestListenerConfigLotsOfConns(t *testing.T) {
+	ctx, err := NewCtxFromKeys(certBytes, keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.SetCipherList("AES128-SHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	LotsOfConns(t, 1024*64, 10, 100, 0*time.Second,
+		func(l net.Listener) net.Listener {
+			return NewListenerWithConfig(l, ctx, ListenerConfig{
+				HandshakeTimeout: 10 * time.Second,
+				MaxHandshakes:    8})
+		}, func(c net.Conn) (net.Conn, error) {
+			return Client(c, ctx)
+		})
+}
@@ -204,6 +204,7 @@ const (
 	NID_sha224                             NID = 675
 	NID_hmac                               NID = 855
 	NID_cmac                               NID = 894
+	NID_rsassaPss                          NID = 912
 	NID_dhpublicnumber                     NID = 920
 	NID_tls1_prf                           NID = 1021
 	NID_hkdf                               NID = 1036
@@ -0,0 +1,280 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package openssltest provides utilities for testing code that uses the
+// openssl package: a certificate authority issuing test certificates,
+// preconfigured contexts, an in-memory connection pair and an echo server.
+package openssltest
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ssgreg/openssl"
+)
+
+// KeyType selects the algorithm of generated keys.
+type KeyType int
+
+const (
+	RSA2048 KeyType = iota
+	ECDSAP256
+	ECDSAP384
+)
+
+func generateKey(key_type KeyType) (openssl.PrivateKey, error) {
+	switch key_type {
+	case RSA2048:
+		return openssl.GenerateRSAKey(2048)
+	case ECDSAP256:
+		return openssl.GenerateECKey(openssl.Prime256v1)
+	case ECDSAP384:
+		return openssl.GenerateECKey(openssl.Secp384r1)
+	}
+	return nil, fmt.Errorf("openssltest: unknown key type %d", key_type)
+}
+
+// CertOptions describes a certificate to issue. The zero value is valid.
+type CertOptions struct {
+	// CommonName is the subject common name. Server certificates default to
+	// "localhost".
+	CommonName string
+	// DNSNames and IPAddresses are the subject alternative names. Server
+	// certificates without any default to localhost, 127.0.0.1 and ::1.
+	DNSNames    []string
+	IPAddresses []net.IP
+	// Issued and Expires are the validity period relative to the current
+	// time. Expires defaults to one day. Negative values make certificates
+	// that are expired already.
+	Issued  time.Duration
+	Expires time.Duration
+	// KeyType is the algorithm of the generated key, RSA2048 by default.
+	KeyType KeyType
+}
+
+// KeyPair is an issued certificate with its private key.
+type KeyPair struct {
+	Cert *openssl.Certificate
+	Key  openssl.PrivateKey
+	// Chain holds the intermediate CA certificates to send along with Cert,
+	// starting with its issuer.
+	Chain []*openssl.Certificate
+}
+
+// CertPEM returns the PEM encoding of Cert followed by Chain.
+func (p *KeyPair) CertPEM() ([]byte, error) {
+	var pem_blocks []byte
+	for _, cert := range append([]*openssl.Certificate{p.Cert}, p.Chain...) {
+		pem_block, err := cert.MarshalPEM()
+		if err != nil {
+			return nil, err
+		}
+		pem_blocks = append(pem_blocks, pem_block...)
+	}
+	return pem_blocks, nil
+}
+
+// KeyPEM returns the PEM encoding of Key.
+func (p *KeyPair) KeyPEM() ([]byte, error) {
+	return p.Key.MarshalPKCS1PrivateKeyPEM()
+}
+
+// CA is a certificate authority issuing certificates for tests. A CA is safe
+// for concurrent use.
+type CA struct {
+	KeyPair
+	root   *openssl.Certificate
+	serial *atomic.Int64
+	depth  int
+}
+
+// NewCA creates a self-signed root CA. opts may be nil.
+func NewCA(opts *CertOptions) (*CA, error) {
+	if opts == nil {
+		opts = &CertOptions{}
+	}
+	ca := &CA{serial: new(atomic.Int64)}
+	name := opts.CommonName
+	if name == "" {
+		name = "openssltest root CA"
+	}
+	cert, key, err := ca.issue(opts, name, []extension{
+		{openssl.NID_basic_constraints, "critical,CA:TRUE"},
+		{openssl.NID_key_usage, "critical,keyCertSign,cRLSign"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ca.Cert, ca.Key, ca.root = cert, key, cert
+	return ca, nil
+}
+
+// Root returns the root certificate, which peers have to trust.
+func (ca *CA) Root() *openssl.Certificate {
+	return ca.root
+}
+
+// Intermediate issues an intermediate CA. Certificates it issues carry it,
+// and any intermediates above it, in their Chain. opts may be nil.
+func (ca *CA) Intermediate(opts *CertOptions) (*CA, error) {
+	if opts == nil {
+		opts = &CertOptions{}
+	}
+	name := opts.CommonName
+	if name == "" {
+		name = fmt.Sprintf("openssltest intermediate CA %d", ca.depth+1)
+	}
+	cert, key, err := ca.issue(opts, name, []extension{
+		{openssl.NID_basic_constraints, "critical,CA:TRUE"},
+		{openssl.NID_key_usage, "critical,keyCertSign,cRLSign"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		KeyPair: KeyPair{Cert: cert, Key: key, Chain: ca.chainOf()},
+		root:    ca.root,
+		serial:  ca.serial,
+		depth:   ca.depth + 1,
+	}, nil
+}
+
+// IssueServer issues a certificate for TLS servers. opts may be nil.
+func (ca *CA) IssueServer(opts *CertOptions) (*KeyPair, error) {
+	if opts == nil {
+		opts = &CertOptions{}
+	}
+	name := opts.CommonName
+	if name == "" {
+		name = "localhost"
+	}
+	dns_names, ips := opts.DNSNames, opts.IPAddresses
+	if len(dns_names) == 0 && len(ips) == 0 {
+		dns_names = []string{"localhost"}
+		ips = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+	return ca.issueLeaf(opts, name, "serverAuth", dns_names, ips)
+}
+
+// IssueClient issues a certificate for TLS clients. opts may be nil.
+func (ca *CA) IssueClient(opts *CertOptions) (*KeyPair, error) {
+	if opts == nil {
+		opts = &CertOptions{}
+	}
+	name := opts.CommonName
+	if name == "" {
+		name = "openssltest client"
+	}
+	return ca.issueLeaf(opts, name, "clientAuth", opts.DNSNames,
+		opts.IPAddresses)
+}
+
+func (ca *CA) issueLeaf(opts *CertOptions, name, usage string,
+	dns_names []string, ips []net.IP) (*KeyPair, error) {
+	key_usage := "critical,digitalSignature"
+	if opts.KeyType == RSA2048 {
+		key_usage += ",keyEncipherment"
+	}
+	exts := []extension{
+		{openssl.NID_basic_constraints, "critical,CA:FALSE"},
+		{openssl.NID_key_usage, key_usage},
+		{openssl.NID_ext_key_usage, usage},
+	}
+	var sans []string
+	for _, dns_name := range dns_names {
+		sans = append(sans, "DNS:"+dns_name)
+	}
+	for _, ip := range ips {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	if len(sans) > 0 {
+		exts = append(exts,
+			extension{openssl.NID_subject_alt_name, strings.Join(sans, ",")})
+	}
+	cert, key, err := ca.issue(opts, name, exts)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Cert:  cert,
+		Key:   key,
+		Chain: ca.chainOf(),
+	}, nil
+}
+
+// chainOf returns a new slice of the intermediates to send with certificates
+// issued by ca.
+func (ca *CA) chainOf() []*openssl.Certificate {
+	if ca.Cert == ca.root {
+		return nil
+	}
+	return append([]*openssl.Certificate{ca.Cert}, ca.Chain...)
+}
+
+type extension struct {
+	nid   openssl.NID
+	value string
+}
+
+// issue creates a certificate signed by ca, or a self-signed one if ca has
+// no certificate yet.
+func (ca *CA) issue(opts *CertOptions, name string, exts []extension) (
+	*openssl.Certificate, openssl.PrivateKey, error) {
+	key, err := generateKey(opts.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	expires := opts.Expires
+	if expires == 0 {
+		expires = 24 * time.Hour
+	}
+	cert, err := openssl.NewCertificate(&openssl.CertificateInfo{
+		Serial:       big.NewInt(ca.serial.Add(1)),
+		Issued:       opts.Issued,
+		Expires:      expires,
+		Country:      "US",
+		Organization: "openssltest",
+		CommonName:   name,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cert.SetVersion(openssl.X509_V3); err != nil {
+		return nil, nil, err
+	}
+	sign_key := key
+	if ca.Cert != nil {
+		if err := cert.SetIssuer(ca.Cert); err != nil {
+			return nil, nil, err
+		}
+		sign_key = ca.Key
+	}
+	exts = append(exts,
+		extension{openssl.NID_subject_key_identifier, "hash"},
+		extension{openssl.NID_authority_key_identifier, "keyid:always"})
+	for _, ext := range exts {
+		if err := cert.AddExtension(ext.nid, ext.value); err != nil {
+			return nil, nil, fmt.Errorf("openssltest: extension %d=%q: %v",
+				ext.nid, ext.value, err)
+		}
+	}
+	if err := cert.Sign(sign_key, openssl.EVP_SHA256); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
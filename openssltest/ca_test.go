@@ -0,0 +1,220 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssltest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ssgreg/openssl"
+)
+
+func handshake(t *testing.T, server_ctx, client_ctx *openssl.Ctx) error {
+	server, client, err := ConnPair(server_ctx, client_ctx)
+	if err != nil {
+		return err
+	}
+	server.Close()
+	client.Close()
+	return nil
+}
+
+func TestCtxPair(t *testing.T) {
+	for _, mutual := range []bool{false, true} {
+		p, err := NewCtxPair(mutual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, client, err := ConnPair(p.Server, p.Client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.VerifyHostname("localhost"); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.VerifyHostname("127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = server.PeerCertificate()
+		if mutual != (err == nil) {
+			t.Fatalf("mutual %t, server peer certificate error: %v", mutual,
+				err)
+		}
+		server.Close()
+		client.Close()
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	ca, err := NewCA(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := ca.IssueServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx, err := ca.ServerCtx(pair, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := ca.ClientCtx(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, server_ctx, client_ctx); err == nil {
+		t.Fatal("expected a client without a certificate to be rejected")
+	}
+}
+
+func TestIntermediate(t *testing.T) {
+	root, err := NewCA(&CertOptions{KeyType: ECDSAP384})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := root.Intermediate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := first.Intermediate(&CertOptions{KeyType: ECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Root() != root.Root() {
+		t.Fatal("intermediate does not share the root")
+	}
+	server_pair, err := second.IssueServer(&CertOptions{
+		DNSNames:    []string{"example.test"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server_pair.Chain) != 2 {
+		t.Fatalf("chain has %d certificates", len(server_pair.Chain))
+	}
+	client_pair, err := first.IssueClient(&CertOptions{CommonName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx, err := second.ServerCtx(server_pair, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := root.ClientCtx(client_pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client, err := ConnPair(server_ctx, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	defer client.Close()
+
+	if err := client.VerifyHostname("example.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifyHostname("192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifyHostname("localhost"); err == nil {
+		t.Fatal("expected the default names to be replaced")
+	}
+	peer, err := server.PeerCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := peer.GetSubjectName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, _ := name.GetEntry(openssl.NID_commonName); cn != "alice" {
+		t.Fatalf("client common name is %q", cn)
+	}
+}
+
+func TestExpiredCertificate(t *testing.T) {
+	ca, err := NewCA(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := ca.IssueServer(&CertOptions{
+		Issued:  -2 * time.Hour,
+		Expires: -time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx, err := ca.ServerCtx(pair, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := ca.ClientCtx(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, server_ctx, client_ctx); err == nil {
+		t.Fatal("expected the expired certificate to be rejected")
+	}
+}
+
+func TestUntrustedCA(t *testing.T) {
+	p, err := NewCtxPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCA(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_ctx, err := other.ClientCtx(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(t, p.Server, client_ctx); err == nil {
+		t.Fatal("expected a certificate from another CA to be rejected")
+	}
+}
+
+func TestKeyPairPEM(t *testing.T) {
+	ca, err := NewCA(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediate, err := ca.Intermediate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := intermediate.IssueServer(&CertOptions{KeyType: ECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert_pem, err := pair.CertPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_pem, err := pair.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openssl.LoadCertificateFromPEM(cert_pem); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openssl.LoadPrivateKeyFromPEM(key_pem); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssltest
+
+import (
+	"github.com/ssgreg/openssl"
+)
+
+func newCtx(pair *KeyPair, root *openssl.Certificate) (*openssl.Ctx, error) {
+	ctx, err := openssl.NewCtx()
+	if err != nil {
+		return nil, err
+	}
+	if pair != nil {
+		if err := ctx.UseCertificate(pair.Cert); err != nil {
+			return nil, err
+		}
+		for _, cert := range pair.Chain {
+			// the context takes ownership of chain certificates, which are
+			// shared between the pairs a CA issues
+			pem_block, err := cert.MarshalPEM()
+			if err != nil {
+				return nil, err
+			}
+			cert, err = openssl.LoadCertificateFromPEM(pem_block)
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.AddChainCertificate(cert); err != nil {
+				return nil, err
+			}
+		}
+		if err := ctx.UsePrivateKey(pair.Key); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.GetCertificateStore().AddCertificate(root); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// ServerCtx returns a server context presenting pair. If verify_clients is
+// set, clients have to present a certificate issued by the CA.
+func (ca *CA) ServerCtx(pair *KeyPair, verify_clients bool) (*openssl.Ctx,
+	error) {
+	ctx, err := newCtx(pair, ca.root)
+	if err != nil {
+		return nil, err
+	}
+	if verify_clients {
+		ctx.SetVerify(openssl.VerifyPeer|openssl.VerifyFailIfNoPeerCert, nil)
+	}
+	return ctx, nil
+}
+
+// ClientCtx returns a client context verifying servers against the CA,
+// presenting pair if it is not nil.
+func (ca *CA) ClientCtx(pair *KeyPair) (*openssl.Ctx, error) {
+	ctx, err := newCtx(pair, ca.root)
+	if err != nil {
+		return nil, err
+	}
+	ctx.SetVerify(openssl.VerifyPeer, nil)
+	return ctx, nil
+}
+
+// CtxPair is a server and a client context trusting the same CA.
+type CtxPair struct {
+	CA     *CA
+	Server *openssl.Ctx
+	Client *openssl.Ctx
+	// ServerPair is the certificate of the server, valid for localhost.
+	ServerPair *KeyPair
+	// ClientPair is the certificate of the client, nil unless mutual
+	// authentication was asked for.
+	ClientPair *KeyPair
+}
+
+// NewCtxPair creates a CA and contexts for a server and a client that
+// verify each other. If mutual is set, the client presents a certificate,
+// which the server requires.
+func NewCtxPair(mutual bool) (*CtxPair, error) {
+	ca, err := NewCA(nil)
+	if err != nil {
+		return nil, err
+	}
+	p := &CtxPair{CA: ca}
+	p.ServerPair, err = ca.IssueServer(nil)
+	if err != nil {
+		return nil, err
+	}
+	if mutual {
+		p.ClientPair, err = ca.IssueClient(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.Server, err = ca.ServerCtx(p.ServerPair, mutual)
+	if err != nil {
+		return nil, err
+	}
+	p.Client, err = ca.ClientCtx(p.ClientPair)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssltest
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/ssgreg/openssl"
+)
+
+// EchoServer is a TLS server on the loopback interface that writes back
+// everything it reads.
+type EchoServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mtx    sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewEchoServer starts an echo server with ctx on a free loopback port.
+func NewEchoServer(ctx *openssl.Ctx) (*EchoServer, error) {
+	listener, err := openssl.Listen("tcp", "127.0.0.1:0", ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &EchoServer{
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *EchoServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Dial connects to the server with ctx.
+func (s *EchoServer) Dial(ctx *openssl.Ctx) (*openssl.Conn, error) {
+	return openssl.Dial("tcp", s.Addr(), ctx, 0)
+}
+
+func (s *EchoServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mtx.Unlock()
+		go s.echo(conn)
+	}
+}
+
+func (s *EchoServer) echo(conn net.Conn) {
+	defer s.wg.Done()
+	io.Copy(conn, conn)
+	conn.Close()
+	s.mtx.Lock()
+	delete(s.conns, conn)
+	s.mtx.Unlock()
+}
+
+// Close stops the server, closes the open connections and waits for them
+// to finish.
+func (s *EchoServer) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
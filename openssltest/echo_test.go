@@ -0,0 +1,83 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssltest
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestEchoServer(t *testing.T) {
+	p, err := NewCtxPair(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewEchoServer(p.Server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := server.Dial(p.Client)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			if _, err := conn.Write([]byte("hello")); err != nil {
+				t.Error(err)
+				return
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if string(buf) != "hello" {
+				t.Errorf("echoed %q", buf)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEchoServerCloseWithOpenConns(t *testing.T) {
+	p, err := NewCtxPair(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewEchoServer(p.Server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := server.Dial(p.Client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("x"))
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	// must not hang on the open connection
+	server.Close()
+}
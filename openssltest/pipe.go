@@ -0,0 +1,217 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssltest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ssgreg/openssl"
+)
+
+// pipeBuffer is one direction of a pipe. Writes never block, so both ends
+// can write at the same time, as TLS peers do.
+type pipeBuffer struct {
+	mtx    sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+	// ready is closed and replaced whenever data arrives or the writer
+	// closes
+	ready chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{ready: make(chan struct{})}
+}
+
+func (b *pipeBuffer) signalLocked() {
+	close(b.ready)
+	b.ready = make(chan struct{})
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+type pipeConn struct {
+	r, w *pipeBuffer
+
+	mtx            sync.Mutex
+	closed         bool
+	read_deadline  time.Time
+	write_deadline time.Time
+	// deadline_set is closed and replaced when the read deadline changes
+	deadline_set chan struct{}
+}
+
+// Pipe returns the two ends of an in-memory connection. Unlike net.Pipe,
+// writes are buffered and never block. Deadlines are supported.
+func Pipe() (net.Conn, net.Conn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: a, w: b, deadline_set: make(chan struct{})},
+		&pipeConn{r: b, w: a, deadline_set: make(chan struct{})}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	for {
+		c.mtx.Lock()
+		closed, deadline, deadline_set := c.closed, c.read_deadline,
+			c.deadline_set
+		c.mtx.Unlock()
+		if closed {
+			return 0, net.ErrClosed
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		c.r.mtx.Lock()
+		if c.r.buf.Len() > 0 {
+			n, err := c.r.buf.Read(p)
+			c.r.mtx.Unlock()
+			return n, err
+		}
+		if c.r.closed {
+			c.r.mtx.Unlock()
+			return 0, io.EOF
+		}
+		ready := c.r.ready
+		c.r.mtx.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+		select {
+		case <-ready:
+		case <-deadline_set:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	c.mtx.Lock()
+	closed, deadline := c.closed, c.write_deadline
+	c.mtx.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	c.w.mtx.Lock()
+	defer c.w.mtx.Unlock()
+	if c.w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.w.buf.Write(p)
+	c.w.signalLocked()
+	return len(p), nil
+}
+
+// Close closes the connection. The peer reads what was written before and
+// then io.EOF.
+func (c *pipeConn) Close() error {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	close(c.deadline_set)
+	c.deadline_set = make(chan struct{})
+	c.mtx.Unlock()
+	for _, b := range []*pipeBuffer{c.r, c.w} {
+		b.mtx.Lock()
+		if !b.closed {
+			b.closed = true
+			b.signalLocked()
+		}
+		b.mtx.Unlock()
+	}
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.SetWriteDeadline(t)
+	return c.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.read_deadline = t
+	close(c.deadline_set)
+	c.deadline_set = make(chan struct{})
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.write_deadline = t
+	return nil
+}
+
+// ConnPair connects a server and a client over a Pipe and runs the
+// handshake on both. The connections are closed if it fails.
+func ConnPair(server_ctx, client_ctx *openssl.Ctx) (server,
+	client *openssl.Conn, err error) {
+	server_conn, client_conn := Pipe()
+	server, err = openssl.Server(server_conn, server_ctx)
+	if err != nil {
+		server_conn.Close()
+		client_conn.Close()
+		return nil, nil, err
+	}
+	client, err = openssl.Client(client_conn, client_ctx)
+	if err != nil {
+		server.Close()
+		client_conn.Close()
+		return nil, nil, err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Handshake()
+	}()
+	err = client.Handshake()
+	if err != nil {
+		// unblock the server
+		client_conn.Close()
+	}
+	if server_err := <-errs; err == nil {
+		err = server_err
+	}
+	if err != nil {
+		server.Close()
+		client.Close()
+		return nil, nil, err
+	}
+	return server, client, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <stdlib.h>
+#include <string.h>
+#include <openssl/ssl.h>
+#include "_cgo_export.h"
+
+static void *x_psk_ctx(SSL *ssl) {
+	return SSL_CTX_get_ex_data(SSL_get_SSL_CTX(ssl), get_ssl_ctx_idx());
+}
+
+static unsigned int x_psk_client_cb(SSL *ssl, const char *hint,
+		char *identity, unsigned int max_identity_len,
+		unsigned char *psk, unsigned int max_psk_len) {
+	return go_psk_client_cb_thunk(x_psk_ctx(ssl), ssl, (char *)hint,
+		identity, max_identity_len, psk, max_psk_len);
+}
+
+static unsigned int x_psk_server_cb(SSL *ssl, const char *identity,
+		unsigned char *psk, unsigned int max_psk_len) {
+	return go_psk_server_cb_thunk(x_psk_ctx(ssl), ssl, (char *)identity,
+		psk, max_psk_len);
+}
+
+void X_SSL_CTX_set_psk_client_callback(SSL_CTX *ctx, int enable) {
+	SSL_CTX_set_psk_client_callback(ctx, enable ? x_psk_client_cb : NULL);
+}
+
+void X_SSL_CTX_set_psk_server_callback(SSL_CTX *ctx, int enable) {
+	SSL_CTX_set_psk_server_callback(ctx, enable ? x_psk_server_cb : NULL);
+}
+
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+
+const int X_TLS13_PSK_SUPPORT = 1;
+
+// holds the identity last offered by a client, which OpenSSL doesn't copy
+static int x_psk_identity_idx = -1;
+
+static void x_psk_identity_free(void *parent, void *ptr, CRYPTO_EX_DATA *ad,
+		int idx, long argl, void *argp) {
+	free(ptr);
+}
+
+int X_psk_init() {
+	x_psk_identity_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL,
+		x_psk_identity_free);
+	return x_psk_identity_idx;
+}
+
+// x_psk_session builds the session OpenSSL takes an external psk as.
+static SSL_SESSION *x_psk_session(SSL *ssl, unsigned char *key,
+		size_t key_len, int cipher_id) {
+	unsigned char id[2] = { (cipher_id >> 8) & 0xff, cipher_id & 0xff };
+	const SSL_CIPHER *cipher = SSL_CIPHER_find(ssl, id);
+	SSL_SESSION *sess;
+	if (cipher == NULL) {
+		return NULL;
+	}
+	sess = SSL_SESSION_new();
+	if (sess == NULL) {
+		return NULL;
+	}
+	if (!SSL_SESSION_set1_master_key(sess, key, key_len) ||
+			!SSL_SESSION_set_cipher(sess, cipher) ||
+			!SSL_SESSION_set_protocol_version(sess, TLS1_3_VERSION)) {
+		SSL_SESSION_free(sess);
+		return NULL;
+	}
+	return sess;
+}
+
+static int x_psk_use_session_cb(SSL *ssl, const EVP_MD *md,
+		const unsigned char **id, size_t *idlen, SSL_SESSION **sess) {
+	unsigned char *identity = NULL, *key = NULL;
+	size_t identity_len = 0, key_len = 0;
+	int cipher_id = 0;
+	SSL_SESSION *s;
+
+	*sess = NULL;
+	go_psk_use_session_cb_thunk(x_psk_ctx(ssl), ssl, &identity,
+		&identity_len, &key, &key_len, &cipher_id);
+	if (key == NULL) {
+		free(identity);
+		return 1;
+	}
+	s = x_psk_session(ssl, key, key_len, cipher_id);
+	OPENSSL_cleanse(key, key_len);
+	free(key);
+	if (s == NULL) {
+		free(identity);
+		return 0;
+	}
+	// after a hello retry request, the psk must fit the chosen suite
+	if (md != NULL &&
+			SSL_CIPHER_get_handshake_digest(SSL_SESSION_get0_cipher(s)) != md) {
+		SSL_SESSION_free(s);
+		free(identity);
+		return 1;
+	}
+	free(SSL_get_ex_data(ssl, x_psk_identity_idx));
+	SSL_set_ex_data(ssl, x_psk_identity_idx, identity);
+	*id = identity;
+	*idlen = identity_len;
+	*sess = s;
+	return 1;
+}
+
+static int x_psk_find_session_cb(SSL *ssl, const unsigned char *identity,
+		size_t identity_len, SSL_SESSION **sess) {
+	unsigned char *key = NULL;
+	size_t key_len = 0;
+	int cipher_id = 0;
+
+	*sess = NULL;
+	go_psk_find_session_cb_thunk(x_psk_ctx(ssl), ssl,
+		(unsigned char *)identity, identity_len, &key, &key_len, &cipher_id);
+	if (key == NULL) {
+		return 1;
+	}
+	*sess = x_psk_session(ssl, key, key_len, cipher_id);
+	OPENSSL_cleanse(key, key_len);
+	free(key);
+	return *sess != NULL;
+}
+
+void X_SSL_CTX_set_psk_use_session_callback(SSL_CTX *ctx, int enable) {
+	SSL_CTX_set_psk_use_session_callback(ctx,
+		enable ? x_psk_use_session_cb : NULL);
+}
+
+void X_SSL_CTX_set_psk_find_session_callback(SSL_CTX *ctx, int enable) {
+	SSL_CTX_set_psk_find_session_callback(ctx,
+		enable ? x_psk_find_session_cb : NULL);
+}
+
+#else
+
+const int X_TLS13_PSK_SUPPORT = 0;
+
+int X_psk_init() {
+	return -1;
+}
+
+void X_SSL_CTX_set_psk_use_session_callback(SSL_CTX *ctx, int enable) {
+}
+
+void X_SSL_CTX_set_psk_find_session_callback(SSL_CTX *ctx, int enable) {
+}
+
+#endif
@@ -0,0 +1,221 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"os"
+	"unsafe"
+)
+
+var (
+	tls13_psk_support = C.X_TLS13_PSK_SUPPORT != 0
+	// ex data index of the identity a TLS 1.3 client offered, owned by C
+	psk_identity_idx = C.X_psk_init()
+)
+
+func cBool(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// PSKClientCallback returns the identity and the pre-shared key a TLS 1.2
+// client authenticates with to a server that sent the identity hint hint,
+// which is empty if it sent none. A nil psk aborts the handshake.
+type PSKClientCallback func(ssl *SSL, hint string) (identity string, psk []byte)
+
+// PSKServerCallback returns the pre-shared key of the identity a TLS 1.2
+// client sent, or nil if the identity is unknown.
+type PSKServerCallback func(ssl *SSL, identity string) []byte
+
+// ExternalPSK is a TLS 1.3 external pre-shared key.
+type ExternalPSK struct {
+	Identity []byte
+	Key      []byte
+	// CipherSuite is the TLS 1.3 cipher suite the key is used with, such as
+	// tls.TLS_AES_128_GCM_SHA256. Its hash is the one the key is bound to.
+	CipherSuite uint16
+}
+
+// PSKUseSessionCallback returns the external pre-shared key a TLS 1.3 client
+// offers, or nil to offer none.
+type PSKUseSessionCallback func(ssl *SSL) *ExternalPSK
+
+// PSKFindSessionCallback returns the external pre-shared key of the identity
+// a TLS 1.3 client offered, or nil if the identity is unknown. The Identity
+// of the result is not used.
+type PSKFindSessionCallback func(ssl *SSL, identity []byte) *ExternalPSK
+
+//export go_psk_client_cb_thunk
+func go_psk_client_cb_thunk(p unsafe.Pointer, con *C.SSL, hint *C.char,
+	identity *C.char, max_identity_len C.uint, psk *C.uchar,
+	max_psk_len C.uint) C.uint {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: psk client callback panic'd: %v", err)
+			os.Exit(1)
+		}
+	}()
+	psk_client_cb := (*Ctx)(p).psk_client_cb
+	if psk_client_cb == nil {
+		return 0
+	}
+	var hint_str string
+	if hint != nil {
+		hint_str = C.GoString(hint)
+	}
+	id, key := psk_client_cb(callbackSSL(con), hint_str)
+	// the identity is passed nul terminated
+	if len(key) == 0 || len(key) > int(max_psk_len) ||
+		len(id) >= int(max_identity_len) {
+		return 0
+	}
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+	C.memcpy(unsafe.Pointer(identity), unsafe.Pointer(cid), C.size_t(len(id)+1))
+	C.memcpy(unsafe.Pointer(psk), unsafe.Pointer(&key[0]), C.size_t(len(key)))
+	return C.uint(len(key))
+}
+
+//export go_psk_server_cb_thunk
+func go_psk_server_cb_thunk(p unsafe.Pointer, con *C.SSL, identity *C.char,
+	psk *C.uchar, max_psk_len C.uint) C.uint {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: psk server callback panic'd: %v", err)
+			os.Exit(1)
+		}
+	}()
+	psk_server_cb := (*Ctx)(p).psk_server_cb
+	if psk_server_cb == nil || identity == nil {
+		return 0
+	}
+	key := psk_server_cb(callbackSSL(con), C.GoString(identity))
+	if len(key) == 0 || len(key) > int(max_psk_len) {
+		return 0
+	}
+	C.memcpy(unsafe.Pointer(psk), unsafe.Pointer(&key[0]), C.size_t(len(key)))
+	return C.uint(len(key))
+}
+
+// returnExternalPSK hands key and cipher suite of psk to C, which frees the
+// key.
+func returnExternalPSK(psk *ExternalPSK, key **C.uchar, key_len *C.size_t,
+	cipher_id *C.int) {
+	if psk == nil || len(psk.Key) == 0 {
+		return
+	}
+	*key = (*C.uchar)(C.CBytes(psk.Key))
+	*key_len = C.size_t(len(psk.Key))
+	*cipher_id = C.int(psk.CipherSuite)
+}
+
+//export go_psk_use_session_cb_thunk
+func go_psk_use_session_cb_thunk(p unsafe.Pointer, con *C.SSL,
+	identity **C.uchar, identity_len *C.size_t, key **C.uchar,
+	key_len *C.size_t, cipher_id *C.int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: psk use session callback panic'd: %v", err)
+			os.Exit(1)
+		}
+	}()
+	psk_use_session_cb := (*Ctx)(p).psk_use_session_cb
+	if psk_use_session_cb == nil {
+		return
+	}
+	psk := psk_use_session_cb(callbackSSL(con))
+	if psk == nil || len(psk.Identity) == 0 {
+		return
+	}
+	*identity = (*C.uchar)(C.CBytes(psk.Identity))
+	*identity_len = C.size_t(len(psk.Identity))
+	returnExternalPSK(psk, key, key_len, cipher_id)
+}
+
+//export go_psk_find_session_cb_thunk
+func go_psk_find_session_cb_thunk(p unsafe.Pointer, con *C.SSL,
+	identity *C.uchar, identity_len C.size_t, key **C.uchar,
+	key_len *C.size_t, cipher_id *C.int) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: psk find session callback panic'd: %v", err)
+			os.Exit(1)
+		}
+	}()
+	psk_find_session_cb := (*Ctx)(p).psk_find_session_cb
+	if psk_find_session_cb == nil {
+		return
+	}
+	psk := psk_find_session_cb(callbackSSL(con),
+		C.GoBytes(unsafe.Pointer(identity), C.int(identity_len)))
+	returnExternalPSK(psk, key, key_len, cipher_id)
+}
+
+// SetPSKClientCallback sets the callback a TLS 1.2 client gets its identity
+// and pre-shared key from, which enables the PSK cipher suites of the cipher
+// list. Unless a PSKUseSessionCallback is set, OpenSSL 1.1.1 and later also
+// use it for TLS 1.3, with the key bound to SHA-256. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set_psk_client_callback.html
+func (c *Ctx) SetPSKClientCallback(psk_client_cb PSKClientCallback) {
+	c.psk_client_cb = psk_client_cb
+	C.X_SSL_CTX_set_psk_client_callback(c.ctx, cBool(psk_client_cb != nil))
+}
+
+// SetPSKServerCallback sets the callback a TLS 1.2 server looks up the
+// pre-shared keys of client identities with, see SetPSKClientCallback.
+func (c *Ctx) SetPSKServerCallback(psk_server_cb PSKServerCallback) {
+	c.psk_server_cb = psk_server_cb
+	C.X_SSL_CTX_set_psk_server_callback(c.ctx, cBool(psk_server_cb != nil))
+}
+
+// UsePSKIdentityHint sets the identity hint a TLS 1.2 server sends to help
+// clients pick their identity.
+func (c *Ctx) UsePSKIdentityHint(hint string) error {
+	chint := C.CString(hint)
+	defer C.free(unsafe.Pointer(chint))
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_use_psk_identity_hint(c.ctx, chint, &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// SetPSKUseSessionCallback sets the callback a TLS 1.3 client gets its
+// external pre-shared key from. Requires OpenSSL 1.1.1 or later. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set_psk_use_session_callback.html
+func (c *Ctx) SetPSKUseSessionCallback(
+	psk_use_session_cb PSKUseSessionCallback) {
+	c.psk_use_session_cb = psk_use_session_cb
+	C.X_SSL_CTX_set_psk_use_session_callback(c.ctx,
+		cBool(psk_use_session_cb != nil))
+}
+
+// SetPSKFindSessionCallback sets the callback a TLS 1.3 server looks up the
+// external pre-shared keys of client identities with. A server that only
+// authenticates with pre-shared keys needs no certificate. Requires OpenSSL
+// 1.1.1 or later. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set_psk_find_session_callback.html
+func (c *Ctx) SetPSKFindSessionCallback(
+	psk_find_session_cb PSKFindSessionCallback) {
+	c.psk_find_session_cb = psk_find_session_cb
+	C.X_SSL_CTX_set_psk_find_session_callback(c.ctx,
+		cBool(psk_find_session_cb != nil))
+}
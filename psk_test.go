@@ -0,0 +1,134 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+)
+
+var testPSK = []byte("0123456789abcdef0123456789abcdef")
+
+func pskExchange(t *testing.T, server, client *Conn) {
+	defer close_both(server, client)
+	go client.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := server.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("read %q", buf)
+	}
+}
+
+func TestPSKTLS12(t *testing.T) {
+	server_ctx := newTestCtx(t, TLSv1_2, false)
+	client_ctx := newTestCtx(t, TLSv1_2, false)
+	for _, ctx := range []*Ctx{server_ctx, client_ctx} {
+		if err := ctx.SetCipherList("PSK-AES128-GCM-SHA256"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server_ctx.UsePSKIdentityHint("devices"); err != nil {
+		t.Fatal(err)
+	}
+	server_ctx.SetPSKServerCallback(func(ssl *SSL, identity string) []byte {
+		if identity != "device-1" {
+			return nil
+		}
+		return testPSK
+	})
+	var hints []string
+	client_psk := testPSK
+	client_ctx.SetPSKClientCallback(func(ssl *SSL, hint string) (
+		string, []byte) {
+		hints = append(hints, hint)
+		return "device-1", client_psk
+	})
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hints) != 1 || hints[0] != "devices" {
+		t.Fatalf("client got hints %q", hints)
+	}
+	suite, err := client.CurrentCipherSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suite.Name != "PSK-AES128-GCM-SHA256" {
+		t.Fatalf("negotiated %s", suite.Name)
+	}
+	pskExchange(t, server, client)
+
+	client_psk = bytes.Repeat([]byte{1}, 32)
+	server, client, err = handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	if err == nil {
+		t.Fatal("expected a wrong key to fail the handshake")
+	}
+}
+
+func TestPSKTLS13(t *testing.T) {
+	if !tls13_psk_support {
+		t.Skip("TLS 1.3 external pre-shared keys are not supported")
+	}
+	// neither side has a certificate
+	server_ctx := newTestCtx(t, AnyVersion, false)
+	client_ctx := newTestCtx(t, AnyVersion, false)
+	var identities []string
+	server_ctx.SetPSKFindSessionCallback(func(ssl *SSL,
+		identity []byte) *ExternalPSK {
+		identities = append(identities, string(identity))
+		if string(identity) != "device-1" {
+			return nil
+		}
+		return &ExternalPSK{
+			Key:         testPSK,
+			CipherSuite: tls.TLS_AES_256_GCM_SHA384}
+	})
+	client_identity := "device-1"
+	client_ctx.SetPSKUseSessionCallback(func(ssl *SSL) *ExternalPSK {
+		return &ExternalPSK{
+			Identity:    []byte(client_identity),
+			Key:         testPSK,
+			CipherSuite: tls.TLS_AES_256_GCM_SHA384}
+	})
+
+	server, client, err := handshakePair(t, server_ctx, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0] != "device-1" {
+		t.Fatalf("server got identities %q", identities)
+	}
+	suite, err := server.CurrentCipherSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if suite.ID != tls.TLS_AES_256_GCM_SHA384 {
+		t.Fatalf("negotiated %s", suite.Name)
+	}
+	pskExchange(t, server, client)
+
+	client_identity = "device-2"
+	server, client, err = handshakePair(t, server_ctx, client_ctx)
+	close_both(server, client)
+	if err == nil {
+		t.Fatal("expected an unknown identity to fail the handshake")
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader builds a Ctx from certificate, chain and key files and
+// builds a fresh one whenever Reload is called or, with Watch, the files
+// change. Listeners created by its NewListener methods use the current Ctx for
+// each new connection, so certificates can be rotated without restarting
+// them. Connections keep the Ctx they were created with.
+type CertificateReloader struct {
+	cert_file  string
+	key_file   string
+	chain_file string
+	new_ctx    func() (*Ctx, error)
+
+	reload_mtx sync.Mutex
+	stamps     []fileStamp // of the files the current ctx was loaded from
+
+	mtx         sync.RWMutex
+	ctx         *Ctx
+	expire_date time.Time
+}
+
+type fileStamp struct {
+	mod_time time.Time
+	size     int64
+}
+
+// NewCertificateReloader loads the PEM encoded certificate from cert_file and
+// the private key from key_file. cert_file may contain the chain after the
+// certificate; chain_file is optional and is appended to it. Every Ctx is
+// created by new_ctx, which lets the caller apply their settings; nil means
+// NewCtx.
+func NewCertificateReloader(cert_file, key_file, chain_file string,
+	new_ctx func() (*Ctx, error)) (*CertificateReloader, error) {
+
+	if new_ctx == nil {
+		new_ctx = NewCtx
+	}
+	r := &CertificateReloader{
+		cert_file:  cert_file,
+		key_file:   key_file,
+		chain_file: chain_file,
+		new_ctx:    new_ctx}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) files() []string {
+	files := []string{r.cert_file, r.key_file}
+	if r.chain_file != "" {
+		files = append(files, r.chain_file)
+	}
+	return files
+}
+
+func (r *CertificateReloader) statFiles() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, file := range r.files() {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{
+			mod_time: fi.ModTime(),
+			size:     fi.Size()})
+	}
+	return stamps, nil
+}
+
+// Reload reads the files again and, if the key matches the certificate,
+// makes a new Ctx built from them the current one. On error the current Ctx
+// stays in use.
+func (r *CertificateReloader) Reload() error {
+	r.reload_mtx.Lock()
+	defer r.reload_mtx.Unlock()
+
+	// stat before reading, so a change racing with the read is seen again
+	stamps, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+	cert_bytes, err := ioutil.ReadFile(r.cert_file)
+	if err != nil {
+		return err
+	}
+	if r.chain_file != "" {
+		chain_bytes, err := ioutil.ReadFile(r.chain_file)
+		if err != nil {
+			return err
+		}
+		cert_bytes = append(append(cert_bytes, '\n'), chain_bytes...)
+	}
+	key_bytes, err := ioutil.ReadFile(r.key_file)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := r.new_ctx()
+	if err != nil {
+		return err
+	}
+	err = ctx.useKeys(cert_bytes, key_bytes)
+	if err != nil {
+		return err
+	}
+	err = ctx.CheckPrivateKey()
+	if err != nil {
+		return err
+	}
+	expire_date, err := ctx.GetCertificate().GetExpireDate()
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	r.ctx = ctx
+	r.expire_date = expire_date
+	r.mtx.Unlock()
+	r.stamps = stamps
+	return nil
+}
+
+func (r *CertificateReloader) changed() bool {
+	r.reload_mtx.Lock()
+	defer r.reload_mtx.Unlock()
+	stamps, err := r.statFiles()
+	if err != nil {
+		// let Reload report it
+		return true
+	}
+	for i := range stamps {
+		if !stamps[i].mod_time.Equal(r.stamps[i].mod_time) ||
+			stamps[i].size != r.stamps[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files every interval and reloads them when one of them
+// changed. Failed reloads are reported to on_error, if not nil, and retried on
+// the next poll, so a certificate and key that are replaced one after the
+// other are picked up once both are in place. Call the returned function to
+// stop watching.
+func (r *CertificateReloader) Watch(interval time.Duration,
+	on_error func(error)) (stop func()) {
+
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			if !r.changed() {
+				continue
+			}
+			err := r.Reload()
+			if err != nil && on_error != nil {
+				on_error(err)
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// Ctx returns the current context.
+func (r *CertificateReloader) Ctx() *Ctx {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.ctx
+}
+
+// ExpireDate returns the end of the validity period of the current
+// certificate.
+func (r *CertificateReloader) ExpireDate() time.Time {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.expire_date
+}
+
+// NewListener is like the package level NewListener, but wraps each accepted
+// connection using the current context.
+func (r *CertificateReloader) NewListener(inner net.Listener) net.Listener {
+	return &listener{
+		Listener: inner,
+		ctx:      r.Ctx}
+}
+
+// NewListenerWithConfig is like the package level NewListenerWithConfig, but
+// wraps each accepted connection using the current context.
+func (r *CertificateReloader) NewListenerWithConfig(inner net.Listener,
+	config ListenerConfig) net.Listener {
+	return newHandshakingListener(inner, r.Ctx, config)
+}
@@ -0,0 +1,267 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testKeyPair struct {
+	cert     *Certificate
+	cert_pem []byte
+	key_pem  []byte
+}
+
+func newTestKeyPair(t *testing.T, serial int64,
+	expires time.Duration) testKeyPair {
+	key, err := GenerateRSAKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &CertificateInfo{
+		Serial:       big.NewInt(serial),
+		Issued:       0,
+		Expires:      expires,
+		Country:      "US",
+		Organization: "Test",
+		CommonName:   "localhost",
+	}
+	cert, err := NewCertificate(info, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Sign(key, EVP_SHA256); err != nil {
+		t.Fatal(err)
+	}
+	cert_pem, err := cert.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_pem, err := key.MarshalPKCS1PrivateKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeyPair{cert: cert, cert_pem: cert_pem, key_pem: key_pem}
+}
+
+type reloaderFiles struct {
+	dir       string
+	cert_file string
+	key_file  string
+	writes    int
+}
+
+func newReloaderFiles(t *testing.T) *reloaderFiles {
+	dir, err := ioutil.TempDir("", "openssl-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &reloaderFiles{
+		dir:       dir,
+		cert_file: filepath.Join(dir, "tls.crt"),
+		key_file:  filepath.Join(dir, "tls.key")}
+}
+
+func (f *reloaderFiles) write(t *testing.T, cert_pem, key_pem []byte) {
+	// bump the modification time explicitly, writes within the timestamp
+	// granularity of the file system would go unnoticed otherwise
+	f.writes++
+	mod_time := time.Now().Add(time.Duration(f.writes) * time.Second)
+	for file, data := range map[string][]byte{
+		f.cert_file: cert_pem,
+		f.key_file:  key_pem} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod_time, mod_time); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (f *reloaderFiles) Close() {
+	os.RemoveAll(f.dir)
+}
+
+func peerSerial(t *testing.T, l net.Listener) string {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.(*Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	client, err := Dial(l.Addr().Network(), l.Addr().String(), ctx,
+		InsecureSkipHostVerification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cert, err := client.PeerCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.GetSerialNumberHex()
+}
+
+func checkExpireDate(t *testing.T, r *CertificateReloader, pair testKeyPair) {
+	expected, err := pair.cert.GetExpireDate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ExpireDate(); !got.Equal(expected) {
+		t.Fatalf("expire date is %s, expected %s", got, expected)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	files := newReloaderFiles(t)
+	defer files.Close()
+	pair1 := newTestKeyPair(t, 1, 24*time.Hour)
+	pair2 := newTestKeyPair(t, 2, 48*time.Hour)
+
+	files.write(t, pair1.cert_pem, pair1.key_pem)
+	r, err := NewCertificateReloader(files.cert_file, files.key_file, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpireDate(t, r, pair1)
+
+	tcp_listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := r.NewListener(tcp_listener)
+	defer l.Close()
+	if serial := peerSerial(t, l); serial != pair1.cert.GetSerialNumberHex() {
+		t.Fatalf("served serial %s before reload", serial)
+	}
+
+	ctx1 := r.Ctx()
+	files.write(t, pair2.cert_pem, pair2.key_pem)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Ctx() == ctx1 {
+		t.Fatal("context was not replaced")
+	}
+	checkExpireDate(t, r, pair2)
+	if serial := peerSerial(t, l); serial != pair2.cert.GetSerialNumberHex() {
+		t.Fatalf("served serial %s after reload", serial)
+	}
+}
+
+func TestCertificateReloaderMismatch(t *testing.T) {
+	files := newReloaderFiles(t)
+	defer files.Close()
+	pair1 := newTestKeyPair(t, 1, 24*time.Hour)
+	pair2 := newTestKeyPair(t, 2, 48*time.Hour)
+
+	files.write(t, pair1.cert_pem, pair2.key_pem)
+	_, err := NewCertificateReloader(files.cert_file, files.key_file, "", nil)
+	if err == nil {
+		t.Fatal("expected mismatched key to fail")
+	}
+
+	files.write(t, pair1.cert_pem, pair1.key_pem)
+	r, err := NewCertificateReloader(files.cert_file, files.key_file, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := r.Ctx()
+	// only the certificate got rotated so far
+	files.write(t, pair2.cert_pem, pair1.key_pem)
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected mismatched key to fail")
+	}
+	if r.Ctx() != ctx {
+		t.Fatal("context was replaced by a failed reload")
+	}
+	checkExpireDate(t, r, pair1)
+}
+
+func TestCertificateReloaderChain(t *testing.T) {
+	files := newReloaderFiles(t)
+	defer files.Close()
+	pair := newTestKeyPair(t, 1, 24*time.Hour)
+	intermediate := newTestKeyPair(t, 2, 24*time.Hour)
+	chain_file := filepath.Join(files.dir, "chain.crt")
+	err := ioutil.WriteFile(chain_file, intermediate.cert_pem, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files.write(t, pair.cert_pem, pair.key_pem)
+	r, err := NewCertificateReloader(files.cert_file, files.key_file,
+		chain_file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Ctx().chain) != 1 {
+		t.Fatalf("expected 1 chain certificate, got %d", len(r.Ctx().chain))
+	}
+}
+
+func TestCertificateReloaderWatch(t *testing.T) {
+	files := newReloaderFiles(t)
+	defer files.Close()
+	pair1 := newTestKeyPair(t, 1, 24*time.Hour)
+	pair2 := newTestKeyPair(t, 2, 48*time.Hour)
+
+	files.write(t, pair1.cert_pem, pair1.key_pem)
+	r, err := NewCertificateReloader(files.cert_file, files.key_file, "",
+		func() (*Ctx, error) {
+			ctx, err := NewCtx()
+			if err == nil {
+				ctx.SetOptions(NoTicket)
+			}
+			return ctx, err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 100)
+	stop := r.Watch(10*time.Millisecond, func(err error) { errs <- err })
+	defer stop()
+
+	// a key that does not match yet is reported and retried
+	files.write(t, pair2.cert_pem, pair1.key_pem)
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("mismatched key was not reported")
+	}
+	files.write(t, pair2.cert_pem, pair2.key_pem)
+	deadline := time.Now().Add(5 * time.Second)
+	for r.ExpireDate().Before(time.Now().Add(36 * time.Hour)) {
+		if time.Now().After(deadline) {
+			t.Fatal("watch did not pick up the new certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.Ctx().GetOptions()&NoTicket == 0 {
+		t.Fatal("reloaded context was not created by new_ctx")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+)
+
+var (
+	rpk_support = C.X_RPK_SUPPORT != 0
+)
+
+// CertificateType is the type of the credentials a peer authenticates with,
+// as defined by RFC 7250.
+type CertificateType int
+
+const (
+	CertificateTypeX509         CertificateType = C.TLSEXT_cert_type_x509
+	CertificateTypeRawPublicKey CertificateType = C.TLSEXT_cert_type_rpk
+)
+
+func (t CertificateType) String() string {
+	switch t {
+	case CertificateTypeX509:
+		return "X.509"
+	case CertificateTypeRawPublicKey:
+		return "RawPublicKey"
+	}
+	return "unknown"
+}
+
+func certificateTypes(types []CertificateType) []C.uchar {
+	c_types := make([]C.uchar, len(types)+1)
+	for i, t := range types {
+		c_types[i] = C.uchar(t)
+	}
+	return c_types
+}
+
+// SetServerCertificateTypes sets the types of credentials the server may
+// authenticate with, in order of preference. On a client it lists the types
+// it accepts from the server. Without arguments only X.509 certificates are
+// used, which is the default. Requires OpenSSL 3.2 or later.
+// See https://www.openssl.org/docs/man3.2/man3/SSL_CTX_set1_server_cert_type.html
+func (c *Ctx) SetServerCertificateTypes(types ...CertificateType) error {
+	if !rpk_support {
+		return errors.New("certificate types require OpenSSL 3.2")
+	}
+	c_types := certificateTypes(types)
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_CTX_set1_server_cert_type(c.ctx, &c_types[0],
+		C.size_t(len(types)), &res) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// SetClientCertificateTypes sets the types of credentials the client may
+// authenticate with, in order of preference. On a server it lists the types
+// it accepts from clients. Requires OpenSSL 3.2 or later.
+func (c *Ctx) SetClientCertificateTypes(types ...CertificateType) error {
+	if !rpk_support {
+		return errors.New("certificate types require OpenSSL 3.2")
+	}
+	c_types := certificateTypes(types)
+	var res C.X_result
+	defer releaseResult(&res)
+	if C.X_SSL_CTX_set1_client_cert_type(c.ctx, &c_types[0],
+		C.size_t(len(types)), &res) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// UseRawPublicKey configures the context to authenticate with key alone. Its
+// public half is sent to peers that negotiated CertificateTypeRawPublicKey,
+// no certificate is needed. Requires OpenSSL 3.2 or later.
+func (c *Ctx) UseRawPublicKey(key PrivateKey) error {
+	if !rpk_support {
+		return errors.New("raw public keys require OpenSSL 3.2")
+	}
+	return c.UsePrivateKey(key)
+}
+
+// RawPublicKeyVerifyCallback decides whether the raw public key a peer
+// authenticated with is trusted. ok is OpenSSL's verdict, which is false
+// unless the key was expected.
+type RawPublicKeyVerifyCallback func(ok bool, key PublicKey) bool
+
+// SetRawPublicKeyVerify controls peer verification like SetVerify, but hands
+// the peer's raw public key to verify_cb instead of a CertificateStoreCtx.
+// Peers authenticating with an X.509 certificate keep OpenSSL's verdict.
+func (c *Ctx) SetRawPublicKeyVerify(options VerifyOptions,
+	verify_cb RawPublicKeyVerifyCallback) {
+	if verify_cb == nil {
+		c.SetVerify(options, nil)
+		return
+	}
+	c.SetVerify(options, func(ok bool, store *CertificateStoreCtx) bool {
+		key := store.RawPublicKey()
+		if key == nil {
+			return ok
+		}
+		return verify_cb(ok, key)
+	})
+}
+
+// RawPublicKey returns the raw public key being verified, or nil if the peer
+// authenticated with a certificate.
+func (self *CertificateStoreCtx) RawPublicKey() PublicKey {
+	return newRawPublicKey(C.X_X509_STORE_CTX_get1_rpk(self.ctx))
+}
+
+func newRawPublicKey(pkey *C.EVP_PKEY) PublicKey {
+	if pkey == nil {
+		return nil
+	}
+	key := &pKey{key: pkey}
+	runtime.SetFinalizer(key, func(key *pKey) {
+		C.EVP_PKEY_free(key.key)
+	})
+	return key
+}
+
+// ServerCertificateType returns the type of credentials the server
+// authenticated with. Only valid after a handshake.
+func (c *Conn) ServerCertificateType() CertificateType {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return CertificateType(C.X_SSL_get_negotiated_server_cert_type(c.ssl))
+}
+
+// ClientCertificateType returns the type of credentials the client
+// authenticated with, if it was asked to. Only valid after a handshake.
+func (c *Conn) ClientCertificateType() CertificateType {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return CertificateType(C.X_SSL_get_negotiated_client_cert_type(c.ssl))
+}
+
+// PeerRawPublicKey returns the raw public key the peer authenticated with.
+// Check it against the keys you trust, the handshake only does so in a
+// verify callback. Only valid after a handshake.
+func (c *Conn) PeerRawPublicKey() (PublicKey, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.is_shutdown {
+		return nil, errors.New("connection closed")
+	}
+	key := newRawPublicKey(C.X_SSL_get1_peer_rpk(c.ssl))
+	if key == nil {
+		return nil, errors.New("no peer raw public key found")
+	}
+	return key, nil
+}
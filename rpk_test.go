@@ -0,0 +1,125 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newRawPublicKeyServerCtx(t *testing.T, key PrivateKey) *Ctx {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UseRawPublicKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetServerCertificateTypes(
+		CertificateTypeRawPublicKey); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func newRawPublicKeyClientCtx(t *testing.T, pinned []byte) *Ctx {
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetServerCertificateTypes(CertificateTypeRawPublicKey,
+		CertificateTypeX509); err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetRawPublicKeyVerify(VerifyPeer, func(ok bool, key PublicKey) bool {
+		der, err := key.MarshalPKIXPublicKeyDER()
+		return err == nil && bytes.Equal(der, pinned)
+	})
+	return ctx
+}
+
+func TestRawPublicKey(t *testing.T) {
+	if !rpk_support {
+		t.Skip("raw public keys are not supported")
+	}
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_ctx := newRawPublicKeyServerCtx(t, key)
+
+	server, client, err := handshakePair(t, server_ctx,
+		newRawPublicKeyClientCtx(t, pinned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close_both(server, client)
+	if typ := client.ServerCertificateType(); typ != CertificateTypeRawPublicKey {
+		t.Fatalf("negotiated %s", typ)
+	}
+	peer_key, err := client.PeerRawPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := peer_key.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, pinned) {
+		t.Fatal("peer key does not match the server key")
+	}
+}
+
+func TestRawPublicKeyNotPinned(t *testing.T) {
+	if !rpk_support {
+		t.Skip("raw public keys are not supported")
+	}
+	key, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateECKey(Prime256v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := other.MarshalPKIXPublicKeyDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client, err := handshakePair(t, newRawPublicKeyServerCtx(t, key),
+		newRawPublicKeyClientCtx(t, pinned))
+	close_both(server, client)
+	if err == nil {
+		t.Fatal("expected an unpinned key to fail the handshake")
+	}
+}
+
+func TestRawPublicKeyUnsupported(t *testing.T) {
+	if rpk_support {
+		t.Skip("raw public keys are supported")
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.SetServerCertificateTypes(CertificateTypeRawPublicKey)
+	if err == nil {
+		t.Fatal("expected an error without raw public key support")
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+var (
+	security_level_support = C.X_SECURITY_LEVEL_SUPPORT != 0
+)
+
+// SecurityOp identifies the check a SecurityCallback is asked about. Checks
+// made on what the peer sent have SecOpPeer set.
+type SecurityOp int
+
+const (
+	SecOpCipherSupported SecurityOp = C.SSL_SECOP_CIPHER_SUPPORTED
+	SecOpCipherShared    SecurityOp = C.SSL_SECOP_CIPHER_SHARED
+	SecOpCipherCheck     SecurityOp = C.SSL_SECOP_CIPHER_CHECK
+	SecOpCurveSupported  SecurityOp = C.SSL_SECOP_CURVE_SUPPORTED
+	SecOpCurveShared     SecurityOp = C.SSL_SECOP_CURVE_SHARED
+	SecOpCurveCheck      SecurityOp = C.SSL_SECOP_CURVE_CHECK
+	SecOpTmpDH           SecurityOp = C.SSL_SECOP_TMP_DH
+	SecOpVersion         SecurityOp = C.SSL_SECOP_VERSION
+	SecOpTicket          SecurityOp = C.SSL_SECOP_TICKET
+	SecOpSigalgSupported SecurityOp = C.SSL_SECOP_SIGALG_SUPPORTED
+	SecOpSigalgShared    SecurityOp = C.SSL_SECOP_SIGALG_SHARED
+	SecOpSigalgCheck     SecurityOp = C.SSL_SECOP_SIGALG_CHECK
+	SecOpSigalgMask      SecurityOp = C.SSL_SECOP_SIGALG_MASK
+	SecOpCompression     SecurityOp = C.SSL_SECOP_COMPRESSION
+	SecOpEEKey           SecurityOp = C.SSL_SECOP_EE_KEY
+	SecOpCAKey           SecurityOp = C.SSL_SECOP_CA_KEY
+	SecOpCAMD            SecurityOp = C.SSL_SECOP_CA_MD
+
+	SecOpPeer SecurityOp = C.SSL_SECOP_PEER
+)
+
+var security_op_names = map[SecurityOp]string{
+	SecOpCipherSupported: "cipher supported",
+	SecOpCipherShared:    "cipher shared",
+	SecOpCipherCheck:     "cipher check",
+	SecOpCurveSupported:  "curve supported",
+	SecOpCurveShared:     "curve shared",
+	SecOpCurveCheck:      "curve check",
+	SecOpTmpDH:           "temporary dh key",
+	SecOpVersion:         "version",
+	SecOpTicket:          "session ticket",
+	SecOpSigalgSupported: "signature algorithm supported",
+	SecOpSigalgShared:    "signature algorithm shared",
+	SecOpSigalgCheck:     "signature algorithm check",
+	SecOpSigalgMask:      "signature algorithm mask",
+	SecOpCompression:     "compression",
+	SecOpEEKey:           "end entity key",
+	SecOpCAKey:           "ca key",
+	SecOpCAMD:            "ca signature digest",
+}
+
+// Peer reports whether the check is on something the peer sent.
+func (op SecurityOp) Peer() bool {
+	return op&SecOpPeer != 0
+}
+
+func (op SecurityOp) String() string {
+	name, ok := security_op_names[op&^SecOpPeer]
+	if !ok {
+		name = fmt.Sprintf("op 0x%x", int(op&^SecOpPeer))
+	}
+	if op.Peer() {
+		return "peer " + name
+	}
+	return name
+}
+
+// SecurityCallback decides whether an algorithm or parameter may be used.
+// ssl is nil for checks of the context itself, such as of the certificates
+// it's configured with. bits is the security strength in bits, which OpenSSL
+// compares against the security level, and nid, unless NID_undef, identifies
+// the algorithm, such as a curve or a digest. allowed is the decision of
+// OpenSSL's default callback at the current security level.
+type SecurityCallback func(ssl *SSL, op SecurityOp, bits int, nid NID,
+	allowed bool) bool
+
+//export go_security_cb_thunk
+func go_security_cb_thunk(p unsafe.Pointer, con *C.SSL, op, bits, nid,
+	allowed C.int) C.int {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Critf("openssl: security callback panic'd: %v", err)
+			os.Exit(1)
+		}
+	}()
+	if p == nil {
+		return allowed
+	}
+	security_cb := (*Ctx)(p).security_cb
+	if security_cb == nil {
+		return allowed
+	}
+	var s *SSL
+	if con != nil {
+		s = callbackSSL(con)
+	}
+	if security_cb(s, SecurityOp(op), int(bits), NID(nid), allowed == 1) {
+		return 1
+	}
+	return 0
+}
+
+// SetSecurityCallback installs security_cb to make the security checks of
+// the context and of the connections created from it afterwards; nil
+// restores OpenSSL's default callback. Requires OpenSSL 1.1.0 or later. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set_security_callback.html
+func (c *Ctx) SetSecurityCallback(security_cb SecurityCallback) {
+	c.security_cb = security_cb
+	if security_cb != nil {
+		C.X_SSL_CTX_set_security_callback(c.ctx, 1)
+	} else {
+		C.X_SSL_CTX_set_security_callback(c.ctx, 0)
+	}
+}
+
+// SetSecurityLevel sets the security level, from 0, which allows anything,
+// to 5, of the context and of the connections created from it afterwards.
+// Requires OpenSSL 1.1.0 or later. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set_security_level.html
+func (c *Ctx) SetSecurityLevel(level int) {
+	C.X_SSL_CTX_set_security_level(c.ctx, C.int(level))
+}
+
+// GetSecurityLevel returns the security level of the context.
+func (c *Ctx) GetSecurityLevel() int {
+	return int(C.X_SSL_CTX_get_security_level(c.ctx))
+}
+
+// SetSecurityLevel sets the security level of the connection, see
+// Ctx.SetSecurityLevel.
+func (s *SSL) SetSecurityLevel(level int) {
+	C.X_SSL_set_security_level(s.ssl, C.int(level))
+}
+
+// GetSecurityLevel returns the security level of the connection.
+func (s *SSL) GetSecurityLevel() int {
+	return int(C.X_SSL_get_security_level(s.ssl))
+}
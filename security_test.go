@@ -0,0 +1,137 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCtxSecurityLevel(t *testing.T) {
+	if !security_level_support {
+		t.Skip("security levels are not supported")
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetSecurityLevel(3)
+	if level := ctx.GetSecurityLevel(); level != 3 {
+		t.Fatalf("security level is %d, expected 3", level)
+	}
+
+	// a 2048 bit rsa key has a strength of 112 bits, level 3 requires 128
+	pair := newTestKeyPair(t, 1, 24*time.Hour)
+	if err := ctx.UseCertificate(pair.cert); err == nil {
+		t.Fatal("expected the certificate to be rejected")
+	}
+
+	var ops []SecurityOp
+	ctx.SetSecurityCallback(func(ssl *SSL, op SecurityOp, bits int, nid NID,
+		allowed bool) bool {
+		ops = append(ops, op)
+		if ssl != nil {
+			t.Errorf("%s: got a connection for a context check", op)
+		}
+		return allowed || (op == SecOpEEKey && bits >= 112)
+	})
+	if err := ctx.UseCertificate(pair.cert); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) == 0 || ops[0] != SecOpEEKey {
+		t.Fatalf("unexpected security checks %v", ops)
+	}
+
+	ctx.SetSecurityCallback(nil)
+	if err := ctx.UseCertificate(pair.cert); err == nil {
+		t.Fatal("expected the default callback to reject the certificate")
+	}
+}
+
+func TestSecurityCallbackHandshake(t *testing.T) {
+	if !security_level_support {
+		t.Skip("security levels are not supported")
+	}
+	pair := newTestKeyPair(t, 1, 24*time.Hour)
+	server_ctx, err := NewCtxFromKeys(pair.cert_pem, pair.key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, deny := range []bool{false, true} {
+		client_ctx, err := NewCtx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mtx sync.Mutex
+		checks := 0
+		// the client checks the cipher suite the server picked
+		client_ctx.SetSecurityCallback(func(ssl *SSL, op SecurityOp, bits int,
+			nid NID, allowed bool) bool {
+			if op != SecOpCipherCheck {
+				return allowed
+			}
+			if ssl == nil {
+				t.Errorf("%s: got no connection", op)
+			}
+			mtx.Lock()
+			checks++
+			mtx.Unlock()
+			return !deny
+		})
+
+		server_conn, client_conn := NetPipe(t)
+		server, err := Server(server_conn, server_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := Client(client_conn, client_ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.Handshake()
+		}()
+		err = client.Handshake()
+		client.Close()
+		<-errs
+		server.Close()
+
+		if deny && err == nil {
+			t.Fatal("expected the denied cipher suite to fail the handshake")
+		}
+		if !deny && err != nil {
+			t.Fatal(err)
+		}
+		if checks != 1 {
+			t.Fatalf("cipher suite was checked %d times, expected once",
+				checks)
+		}
+	}
+}
+
+func TestSecurityOpString(t *testing.T) {
+	if !security_level_support {
+		t.Skip("security levels are not supported")
+	}
+	if s := (SecOpEEKey | SecOpPeer).String(); s != "peer end entity key" {
+		t.Fatalf("got %q", s)
+	}
+	if s := SecOpCipherShared.String(); s != "cipher shared" {
+		t.Fatalf("got %q", s)
+	}
+}
@@ -15,6 +15,9 @@
  *
  */
 
+#include <errno.h>
+#include <limits.h>
+#include <stdint.h>
 #include <string.h>
 
 #include <openssl/conf.h>
@@ -24,8 +27,17 @@
 #include <openssl/engine.h>
 #include <openssl/err.h>
 #include <openssl/evp.h>
+#include <openssl/md5.h>
+#include <openssl/sha.h>
 #include <openssl/ssl.h>
 
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+#include <openssl/kdf.h>
+#endif
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+#include <openssl/core_names.h>
+#endif
+
 #include "_cgo_export.h"
 
 /*
@@ -69,6 +81,18 @@ int X_EVP_DigestVerify(EVP_MD_CTX *ctx, const unsigned char *sigret,
 	return EVP_DigestVerify(ctx, sigret, siglen, tbs, tbslen);
 }
 
+const char *X_SSL_CIPHER_standard_name(const SSL_CIPHER *c) {
+	return SSL_CIPHER_standard_name(c);
+}
+
+int X_SSL_CIPHER_get_protocol_id(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_protocol_id(c);
+}
+
+int X_SSL_CIPHER_is_aead(const SSL_CIPHER *c) {
+	return SSL_CIPHER_is_aead(c);
+}
+
 #else
 
 const int X_ED25519_SUPPORT = 0;
@@ -95,6 +119,18 @@ int X_EVP_DigestVerify(EVP_MD_CTX *ctx, const unsigned char *sigret,
 	return 0;
 }
 
+const char *X_SSL_CIPHER_standard_name(const SSL_CIPHER *c) {
+	return NULL;
+}
+
+int X_SSL_CIPHER_get_protocol_id(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_id(c) & 0xffff;
+}
+
+int X_SSL_CIPHER_is_aead(const SSL_CIPHER *c) {
+	return 0;
+}
+
 #endif
 
 /*
@@ -220,6 +256,22 @@ int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CI
 	return PEM_write_bio_PrivateKey_traditional(bio, key, enc, kstr, klen, cb, u);
 }
 
+int X_SSL_CIPHER_get_kx_nid(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_kx_nid(c);
+}
+
+int X_SSL_CIPHER_get_auth_nid(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_auth_nid(c);
+}
+
+int X_SSL_CIPHER_get_cipher_nid(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_cipher_nid(c);
+}
+
+int X_SSL_CIPHER_get_digest_nid(const SSL_CIPHER *c) {
+	return SSL_CIPHER_get_digest_nid(c);
+}
+
 #endif
 
 /*
@@ -364,6 +416,22 @@ int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CI
 		pem_type_str, bio, key, enc, kstr, klen, cb, u);
 }
 
+int X_SSL_CIPHER_get_kx_nid(const SSL_CIPHER *c) {
+	return NID_undef;
+}
+
+int X_SSL_CIPHER_get_auth_nid(const SSL_CIPHER *c) {
+	return NID_undef;
+}
+
+int X_SSL_CIPHER_get_cipher_nid(const SSL_CIPHER *c) {
+	return NID_undef;
+}
+
+int X_SSL_CIPHER_get_digest_nid(const SSL_CIPHER *c) {
+	return NID_undef;
+}
+
 #endif
 
 /*
@@ -372,6 +440,99 @@ int X_PEM_write_bio_PrivateKey_traditional(BIO *bio, EVP_PKEY *key, const EVP_CI
  ************************************************
  */
 
+static void x_result_begin(X_result *res) {
+	ERR_clear_error();
+	errno = 0;
+	res->ssl_error = SSL_ERROR_NONE;
+	res->sys_errno = 0;
+	res->nerrs = 0;
+	res->errs = NULL;
+}
+
+void X_err_queue_free(X_err_queue *errs) {
+	free(errs);
+}
+
+static short x_err_queue_add_str(X_err_queue *errs, const char *str) {
+	size_t len;
+	short off;
+	if (str == NULL) {
+		return -1;
+	}
+	len = strlen(str) + 1;
+	if (len > (size_t)(X_ERR_STRS_MAX - errs->strs_len)) {
+		return -1;
+	}
+	off = (short)errs->strs_len;
+	memcpy(errs->strs + off, str, len);
+	errs->strs_len += (int)len;
+	return off;
+}
+
+static void x_result_end(X_result *res) {
+	unsigned long code;
+	const char *file, *data;
+	int line, flags, i;
+	res->sys_errno = errno;
+	// drain the whole queue so nothing leaks into the next call on this
+	// thread, even if it does not fit into res
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	while ((code = ERR_get_error_all(&file, &line, NULL, &data, &flags)) != 0) {
+#else
+	while ((code = ERR_get_error_line_data(&file, &line, &data, &flags)) != 0) {
+#endif
+		if (res->errs == NULL) {
+			// the first entry, only failed calls pay for the copy
+			res->errs = malloc(sizeof(*res->errs));
+			if (res->errs == NULL) {
+				continue;
+			}
+			res->errs->strs_len = 0;
+		}
+		if (res->nerrs < X_ERR_QUEUE_MAX) {
+			i = res->nerrs++;
+			res->errs->codes[i] = code;
+			res->errs->lines[i] = line;
+			res->errs->file_offs[i] = x_err_queue_add_str(res->errs, file);
+			res->errs->data_offs[i] = (flags & ERR_TXT_STRING) ?
+				x_err_queue_add_str(res->errs, data) : -1;
+		}
+	}
+}
+
+static void x_result_end_ssl(X_result *res, SSL *ssl, int ret) {
+	int saved_errno = errno;
+	// SSL_get_error inspects the error queue, so it has to run before
+	// x_result_end drains it
+	res->ssl_error = SSL_get_error(ssl, ret);
+	errno = saved_errno;
+	x_result_end(res);
+}
+
+static int x_result_is_eof(const X_result *res, int ret) {
+	if (res->ssl_error == SSL_ERROR_SYSCALL && res->nerrs == 0 && ret == 0) {
+		return 1;
+	}
+#ifdef SSL_R_UNEXPECTED_EOF_WHILE_READING
+	// OpenSSL 3.0 reports a transport EOF as a regular SSL error
+	if (res->ssl_error == SSL_ERROR_SSL) {
+		int i;
+		for (i = 0; i < res->nerrs; i++) {
+			if (ERR_GET_REASON(res->errs->codes[i]) == SSL_R_UNEXPECTED_EOF_WHILE_READING) {
+				return 1;
+			}
+		}
+	}
+#endif
+	return 0;
+}
+
+static void x_io_result_end(X_io_result *r, SSL *ssl) {
+	int ret = r->ret > 0 ? 1 : (int)r->ret;
+	x_result_end_ssl(&r->res, ssl, ret);
+	r->eof = x_result_is_eof(&r->res, ret);
+}
+
 int X_shim_init() {
 	int rc = 0;
 
@@ -417,8 +578,12 @@ long X_SSL_clear_options(SSL* ssl, long options) {
 	return SSL_clear_options(ssl, options);
 }
 
-long X_SSL_set_tlsext_host_name(SSL *ssl, const char *name) {
-   return SSL_set_tlsext_host_name(ssl, name);
+long X_SSL_set_tlsext_host_name(SSL *ssl, const char *name, X_result *res) {
+	long rv;
+	x_result_begin(res);
+	rv = SSL_set_tlsext_host_name(ssl, name);
+	x_result_end(res);
+	return rv;
 }
 const char * X_SSL_get_cipher_name(const SSL *ssl) {
    return SSL_get_cipher_name(ssl);
@@ -427,255 +592,1985 @@ int X_SSL_session_reused(SSL *ssl) {
     return SSL_session_reused(ssl);
 }
 
-int X_SSL_new_index() {
-	return SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+int X_SSL_is_init_finished(SSL *ssl) {
+    return SSL_is_init_finished(ssl);
 }
 
-int X_SSL_verify_cb(int ok, X509_STORE_CTX* store) {
-	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
-			SSL_get_ex_data_X509_STORE_CTX_idx());
-	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
-	// get the pointer to the go Ctx object and pass it back into the thunk
-	return go_ssl_verify_cb_thunk(p, ok, store);
+SSL *X_SSL_new(SSL_CTX *ctx, X_result *res) {
+	SSL *ssl;
+	x_result_begin(res);
+	ssl = SSL_new(ctx);
+	x_result_end(res);
+	return ssl;
 }
 
-const SSL_METHOD *X_SSLv23_method() {
-	return SSLv23_method();
+X_io_result X_SSL_do_handshake(SSL *ssl) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.ret = SSL_do_handshake(ssl);
+	x_io_result_end(&r, ssl);
+	return r;
 }
 
-const SSL_METHOD *X_SSLv3_method() {
-#ifndef OPENSSL_NO_SSL3_METHOD
-	return SSLv3_method();
-#else
-	return NULL;
-#endif
+X_io_result X_SSL_read(SSL *ssl, void *buf, int num) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.ret = SSL_read(ssl, buf, num);
+	x_io_result_end(&r, ssl);
+	return r;
 }
 
-const SSL_METHOD *X_TLSv1_method() {
-	return TLSv1_method();
+X_io_result X_SSL_write(SSL *ssl, const void *buf, int num) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.ret = SSL_write(ssl, buf, num);
+	x_io_result_end(&r, ssl);
+	return r;
 }
 
-const SSL_METHOD *X_TLSv1_1_method() {
-#if defined(TLS1_1_VERSION) && !defined(OPENSSL_SYSNAME_MACOSX)
-	return TLSv1_1_method();
-#else
-	return NULL;
-#endif
+X_io_result X_SSL_shutdown(SSL *ssl) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.ret = SSL_shutdown(ssl);
+	x_io_result_end(&r, ssl);
+	return r;
 }
 
-const SSL_METHOD *X_TLSv1_2_method() {
-#if defined(TLS1_2_VERSION) && !defined(OPENSSL_SYSNAME_MACOSX)
-	return TLSv1_2_method();
-#else
-	return NULL;
-#endif
+SSL_SESSION *X_d2i_SSL_SESSION(const unsigned char *der, long len, X_result *res) {
+	SSL_SESSION *session;
+	x_result_begin(res);
+	session = d2i_SSL_SESSION(NULL, &der, len);
+	x_result_end(res);
+	return session;
 }
 
-int X_SSL_CTX_new_index() {
-	return SSL_CTX_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+int X_SSL_set_session(SSL *ssl, SSL_SESSION *session, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_set_session(ssl, session);
+	x_result_end(res);
+	return rv;
 }
 
-long X_SSL_CTX_set_options(SSL_CTX* ctx, long options) {
-	return SSL_CTX_set_options(ctx, options);
+int X_SSL_set_fd(SSL *ssl, int fd, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_set_fd(ssl, fd);
+	x_result_end(res);
+	return rv;
 }
 
-long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options) {
-	return SSL_CTX_clear_options(ctx, options);
+int X_ERR_GET_LIB(unsigned long code) {
+	return ERR_GET_LIB(code);
 }
 
-long X_SSL_CTX_get_options(SSL_CTX* ctx) {
-	return SSL_CTX_get_options(ctx);
+int X_ERR_GET_REASON(unsigned long code) {
+	return ERR_GET_REASON(code);
 }
 
-long X_SSL_CTX_set_mode(SSL_CTX* ctx, long modes) {
-	return SSL_CTX_set_mode(ctx, modes);
-}
+// ex data indexes of the last fatal alerts sent and received and of the last
+// handshake state entered, which outlive the state machine failing
+static int x_alert_sent_idx = -1;
+static int x_alert_recv_idx = -1;
+static int x_hs_state_idx = -1;
+// ex data index counting the handshakes started before TLS 1.3, which
+// signals post-handshake messages as handshakes as well
+static int x_hs_count_idx = -1;
 
-long X_SSL_CTX_get_mode(SSL_CTX* ctx) {
-	return SSL_CTX_get_mode(ctx);
+int X_handshake_info_init() {
+	x_alert_sent_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+	x_alert_recv_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+	x_hs_state_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+	x_hs_count_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+	if (x_alert_sent_idx < 0 || x_alert_recv_idx < 0 || x_hs_state_idx < 0 ||
+			x_hs_count_idx < 0) {
+		return -1;
+	}
+	return 0;
 }
 
-long X_SSL_CTX_set_session_cache_mode(SSL_CTX* ctx, long modes) {
-	return SSL_CTX_set_session_cache_mode(ctx, modes);
+static void x_handshake_info_cb(const SSL *ssl, int where, int ret) {
+	// the callback of the SSL object hides the one of its context
+	void (*ctx_cb)(const SSL *, int, int) =
+		SSL_CTX_get_info_callback(SSL_get_SSL_CTX(ssl));
+	if ((where & SSL_CB_HANDSHAKE_START) && SSL_version(ssl) != TLS1_3_VERSION) {
+		intptr_t count = (intptr_t)SSL_get_ex_data(ssl, x_hs_count_idx);
+		SSL_set_ex_data((SSL *)ssl, x_hs_count_idx, (void *)(count + 1));
+	}
+	if (where & SSL_CB_LOOP) {
+		// the state strings are static, so only the pointer is kept
+		SSL_set_ex_data((SSL *)ssl, x_hs_state_idx,
+			(void *)SSL_state_string_long(ssl));
+	} else if ((where & SSL_CB_ALERT) && (ret >> 8) == SSL3_AL_FATAL) {
+		SSL_set_ex_data((SSL *)ssl,
+			(where & SSL_CB_WRITE) ? x_alert_sent_idx : x_alert_recv_idx,
+			(void *)(intptr_t)ret);
+	}
+	if (ctx_cb != NULL) {
+		ctx_cb(ssl, where, ret);
+	}
 }
 
-long X_SSL_CTX_sess_set_cache_size(SSL_CTX* ctx, long t) {
-	return SSL_CTX_sess_set_cache_size(ctx, t);
+void X_SSL_track_handshake(SSL *ssl) {
+	SSL_set_info_callback(ssl, x_handshake_info_cb);
 }
 
-long X_SSL_CTX_sess_get_cache_size(SSL_CTX* ctx) {
-	return SSL_CTX_sess_get_cache_size(ctx);
+int X_SSL_get_fatal_alert(SSL *ssl, int sent) {
+	return (int)(intptr_t)SSL_get_ex_data(ssl,
+		sent ? x_alert_sent_idx : x_alert_recv_idx);
 }
 
-long X_SSL_CTX_set_timeout(SSL_CTX* ctx, long t) {
-	return SSL_CTX_set_timeout(ctx, t);
+const char *X_SSL_get_handshake_state(SSL *ssl) {
+	return SSL_get_ex_data(ssl, x_hs_state_idx);
 }
 
-long X_SSL_CTX_get_timeout(SSL_CTX* ctx) {
-	return SSL_CTX_get_timeout(ctx);
+int X_SSL_get_renegotiations(SSL *ssl) {
+	int count = (int)(intptr_t)SSL_get_ex_data(ssl, x_hs_count_idx);
+	return count > 1 ? count - 1 : 0;
 }
 
-long X_SSL_CTX_add_extra_chain_cert(SSL_CTX* ctx, X509 *cert) {
-	return SSL_CTX_add_extra_chain_cert(ctx, cert);
-}
+/*
+ ************************************************
+ * custom extensions, v1.1.1 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
 
-long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key) {
-	return SSL_CTX_set_tmp_ecdh(ctx, key);
-}
+const int X_CUSTOM_EXT_SUPPORT = 1;
 
-int X_SSL_CTX_set_verify_param_flags(SSL_CTX* ctx, unsigned long flags)
-{
-	X509_VERIFY_PARAM* param = X509_VERIFY_PARAM_new();
-	X509_VERIFY_PARAM_set_flags(param, flags);
-	SSL_CTX_set1_param(ctx, param);
-	X509_VERIFY_PARAM_free(param);
+static int x_custom_ext_add_cb(SSL *s, unsigned int ext_type,
+		unsigned int context, const unsigned char **out, size_t *outlen,
+		X509 *x, size_t chainidx, int *al, void *add_arg) {
+	return go_custom_ext_add_cb_thunk(s, ext_type, context,
+		(unsigned char **)out, outlen, (int)(intptr_t)add_arg, al);
 }
 
-long X_SSL_CTX_set_tlsext_servername_callback(
-		SSL_CTX* ctx, int (*cb)(SSL *con, int *ad, void *args)) {
-	return SSL_CTX_set_tlsext_servername_callback(ctx, cb);
+static void x_custom_ext_free_cb(SSL *s, unsigned int ext_type,
+		unsigned int context, const unsigned char *out, void *add_arg) {
+	free((void *)out);
+	go_custom_ext_free_cb_thunk(s, ext_type, context, (int)(intptr_t)add_arg);
 }
 
-int X_SSL_CTX_verify_cb(int ok, X509_STORE_CTX* store) {
-	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
-			SSL_get_ex_data_X509_STORE_CTX_idx());
-	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
-	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
-	// get the pointer to the go Ctx object and pass it back into the thunk
-	return go_ssl_ctx_verify_cb_thunk(p, ok, store);
+static int x_custom_ext_parse_cb(SSL *s, unsigned int ext_type,
+		unsigned int context, const unsigned char *in, size_t inlen,
+		X509 *x, size_t chainidx, int *al, void *parse_arg) {
+	return go_custom_ext_parse_cb_thunk(s, ext_type, context,
+		(unsigned char *)in, inlen, (int)(intptr_t)parse_arg, al);
 }
 
-STACK_OF(X509_CRL)* X_STORE_lookup_crls_cb(X509_STORE_CTX *store, X509_NAME *nm) {
-	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
-			SSL_get_ex_data_X509_STORE_CTX_idx());
-	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
-	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
-	// get the pointer to the go Ctx object and pass it back into the thunk
-	X509_CRL* crl = go_store_lookup_crls(p, store, nm);
-	if (!crl)
-		return NULL;
-	STACK_OF(X509_CRL) *crls = sk_X509_CRL_new_null();
-	if (!crls)
-        return NULL;
-	sk_X509_CRL_push(crls, crl);
-	return crls;
+int X_SSL_CTX_add_custom_ext(SSL_CTX *ctx, unsigned int ext_type,
+		unsigned int context, int id, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_add_custom_ext(ctx, ext_type, context,
+		x_custom_ext_add_cb, x_custom_ext_free_cb, (void *)(intptr_t)id,
+		x_custom_ext_parse_cb, (void *)(intptr_t)id);
+	x_result_end(res);
+	return rv;
 }
 
-long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh) {
-    return SSL_CTX_set_tmp_dh(ctx, dh);
-}
+#else
 
-long X_PEM_read_DHparams(SSL_CTX* ctx, DH *dh) {
-    return SSL_CTX_set_tmp_dh(ctx, dh);
-}
+const int X_CUSTOM_EXT_SUPPORT = 0;
 
-int X_SSL_CTX_set_tlsext_ticket_key_cb(SSL_CTX *sslctx,
-        int (*cb)(SSL *s, unsigned char key_name[16],
-                  unsigned char iv[EVP_MAX_IV_LENGTH],
-                  EVP_CIPHER_CTX *ctx, HMAC_CTX *hctx, int enc)) {
-    return SSL_CTX_set_tlsext_ticket_key_cb(sslctx, cb);
+int X_SSL_CTX_add_custom_ext(SSL_CTX *ctx, unsigned int ext_type,
+		unsigned int context, int id, X_result *res) {
+	x_result_begin(res);
+	return 0;
 }
 
-int X_SSL_CTX_ticket_key_cb(SSL *s, unsigned char key_name[16],
-		unsigned char iv[EVP_MAX_IV_LENGTH],
-		EVP_CIPHER_CTX *cctx, HMAC_CTX *hctx, int enc) {
+#endif
 
-	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(s);
-	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
-	// get the pointer to the go Ctx object and pass it back into the thunk
-	return go_ticket_key_cb_thunk(p, s, key_name, iv, cctx, hctx, enc);
+/*
+ ************************************************
+ * certificate compression, v3.2 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30200000L && !defined(OPENSSL_NO_COMP_ALG)
+
+#include <openssl/comp.h>
+
+const int X_CERT_COMP_SUPPORT = 1;
+
+// ex data index flagging connections that received a compressed certificate
+static int x_cert_comp_idx = -1;
+
+int X_cert_comp_init() {
+	x_cert_comp_idx = SSL_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+	return x_cert_comp_idx;
 }
 
-int X_BIO_get_flags(BIO *b) {
-	return BIO_get_flags(b);
+// the compression libraries may be loaded at runtime, this is the check
+// OpenSSL itself makes before offering an algorithm
+int X_cert_comp_available(int alg) {
+	switch (alg) {
+#ifndef OPENSSL_NO_ZLIB
+	case TLSEXT_comp_cert_zlib:
+		return BIO_f_zlib() != NULL;
+#endif
+#ifndef OPENSSL_NO_BROTLI
+	case TLSEXT_comp_cert_brotli:
+		return BIO_f_brotli() != NULL;
+#endif
+#ifndef OPENSSL_NO_ZSTD
+	case TLSEXT_comp_cert_zstd:
+		return BIO_f_zstd() != NULL;
+#endif
+	}
+	return 0;
 }
 
-void X_BIO_set_flags(BIO *b, int flags) {
-	return BIO_set_flags(b, flags);
+static void x_cert_comp_msg_cb(int write_p, int version, int content_type,
+		const void *buf, size_t len, SSL *ssl, void *arg) {
+	if (!write_p && content_type == SSL3_RT_HANDSHAKE && len > 0 &&
+			((const unsigned char *)buf)[0] == SSL3_MT_COMPRESSED_CERTIFICATE) {
+		SSL_set_ex_data(ssl, x_cert_comp_idx, (void *)1);
+	}
 }
 
-void X_BIO_clear_flags(BIO *b, int flags) {
-	BIO_clear_flags(b, flags);
+void X_SSL_CTX_track_cert_comp(SSL_CTX *ctx) {
+	SSL_CTX_set_msg_callback(ctx, x_cert_comp_msg_cb);
 }
 
-int X_BIO_read(BIO *b, void *buf, int len) {
-	return BIO_read(b, buf, len);
+int X_SSL_CTX_set_cert_comp(SSL_CTX *ctx, int *algs, size_t len,
+		X_result *res) {
+	const uint64_t opts = SSL_OP_NO_TX_CERTIFICATE_COMPRESSION |
+		SSL_OP_NO_RX_CERTIFICATE_COMPRESSION;
+	int rv = 1;
+	x_result_begin(res);
+	if (len == 0) {
+		SSL_CTX_set_options(ctx, opts);
+	} else {
+		SSL_CTX_clear_options(ctx, opts);
+		rv = SSL_CTX_set1_cert_comp_preference(ctx, algs, len);
+	}
+	x_result_end(res);
+	return rv;
 }
 
-int X_BIO_write(BIO *b, const void *buf, int len) {
-	return BIO_write(b, buf, len);
+int X_SSL_CTX_compress_certs(SSL_CTX *ctx, int alg, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_compress_certs(ctx, alg);
+	x_result_end(res);
+	return rv;
 }
 
-BIO *X_BIO_new_write_bio() {
-	return BIO_new(BIO_s_writeBio());
+int X_SSL_peer_cert_compressed(SSL *ssl) {
+	return SSL_get_ex_data(ssl, x_cert_comp_idx) != NULL;
 }
 
-BIO *X_BIO_new_read_bio() {
-	return BIO_new(BIO_s_readBio());
+#else
+
+const int X_CERT_COMP_SUPPORT = 0;
+
+int X_cert_comp_init() {
+	return -1;
 }
 
-const EVP_MD *X_EVP_get_digestbyname(const char *name) {
-	return EVP_get_digestbyname(name);
+int X_cert_comp_available(int alg) {
+	return 0;
 }
 
-const EVP_MD *X_EVP_md_null() {
-	return EVP_md_null();
+void X_SSL_CTX_track_cert_comp(SSL_CTX *ctx) {
 }
 
-const EVP_MD *X_EVP_md5() {
-	return EVP_md5();
+int X_SSL_CTX_set_cert_comp(SSL_CTX *ctx, int *algs, size_t len,
+		X_result *res) {
+	x_result_begin(res);
+	return 0;
 }
 
-const EVP_MD *X_EVP_md4() {
-	return EVP_md4();
+int X_SSL_CTX_compress_certs(SSL_CTX *ctx, int alg, X_result *res) {
+	x_result_begin(res);
+	return 0;
 }
 
-const EVP_MD *X_EVP_ripemd160() {
-	return EVP_ripemd160();
+int X_SSL_peer_cert_compressed(SSL *ssl) {
+	return 0;
 }
 
-const EVP_MD *X_EVP_sha224() {
-	return EVP_sha224();
+#endif
+
+/*
+ ************************************************
+ * raw public keys, v3.2 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30200000L
+
+const int X_RPK_SUPPORT = 1;
+
+int X_SSL_CTX_set1_server_cert_type(SSL_CTX *ctx, const unsigned char *val,
+		size_t len, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set1_server_cert_type(ctx, val, len);
+	x_result_end(res);
+	return rv;
 }
 
-const EVP_MD *X_EVP_sha1() {
-	return EVP_sha1();
+int X_SSL_CTX_set1_client_cert_type(SSL_CTX *ctx, const unsigned char *val,
+		size_t len, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set1_client_cert_type(ctx, val, len);
+	x_result_end(res);
+	return rv;
 }
 
-const EVP_MD *X_EVP_sha256() {
-	return EVP_sha256();
+int X_SSL_get_negotiated_server_cert_type(SSL *ssl) {
+	return SSL_get_negotiated_server_cert_type(ssl);
 }
 
-const EVP_MD *X_EVP_sha384() {
-	return EVP_sha384();
+int X_SSL_get_negotiated_client_cert_type(SSL *ssl) {
+	return SSL_get_negotiated_client_cert_type(ssl);
 }
 
-const EVP_MD *X_EVP_sha512() {
-	return EVP_sha512();
+EVP_PKEY *X_SSL_get1_peer_rpk(SSL *ssl) {
+	EVP_PKEY *pkey = SSL_get0_peer_rpk(ssl);
+	if (pkey == NULL || EVP_PKEY_up_ref(pkey) != 1) {
+		return NULL;
+	}
+	return pkey;
 }
 
-int X_EVP_MD_size(const EVP_MD *md) {
-	return EVP_MD_size(md);
+EVP_PKEY *X_X509_STORE_CTX_get1_rpk(X509_STORE_CTX *ctx) {
+	EVP_PKEY *pkey = X509_STORE_CTX_get0_rpk(ctx);
+	if (pkey == NULL || EVP_PKEY_up_ref(pkey) != 1) {
+		return NULL;
+	}
+	return pkey;
 }
 
-int X_EVP_DigestInit_ex(EVP_MD_CTX *ctx, const EVP_MD *type, ENGINE *impl) {
-	return EVP_DigestInit_ex(ctx, type, impl);
+#else
+
+const int X_RPK_SUPPORT = 0;
+
+int X_SSL_CTX_set1_server_cert_type(SSL_CTX *ctx, const unsigned char *val,
+		size_t len, X_result *res) {
+	x_result_begin(res);
+	return 0;
 }
 
-int X_EVP_DigestUpdate(EVP_MD_CTX *ctx, const void *d, size_t cnt) {
-	return EVP_DigestUpdate(ctx, d, cnt);
+int X_SSL_CTX_set1_client_cert_type(SSL_CTX *ctx, const unsigned char *val,
+		size_t len, X_result *res) {
+	x_result_begin(res);
+	return 0;
 }
 
-int X_EVP_DigestFinal_ex(EVP_MD_CTX *ctx, unsigned char *md, unsigned int *s) {
-	return EVP_DigestFinal_ex(ctx, md, s);
+int X_SSL_get_negotiated_server_cert_type(SSL *ssl) {
+	return TLSEXT_cert_type_x509;
 }
 
-int X_EVP_SignInit(EVP_MD_CTX *ctx, const EVP_MD *type) {
-	return EVP_SignInit(ctx, type);
+int X_SSL_get_negotiated_client_cert_type(SSL *ssl) {
+	return TLSEXT_cert_type_x509;
 }
 
-int X_EVP_SignUpdate(EVP_MD_CTX *ctx, const void *d, unsigned int cnt) {
-	return EVP_SignUpdate(ctx, d, cnt);
+EVP_PKEY *X_SSL_get1_peer_rpk(SSL *ssl) {
+	return NULL;
+}
+
+EVP_PKEY *X_X509_STORE_CTX_get1_rpk(X509_STORE_CTX *ctx) {
+	return NULL;
+}
+
+#endif
+
+/*
+ ************************************************
+ * v1.0.2 and later implementation
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x10002000L
+
+const int X_CERT_CHAIN_SUPPORT = 1;
+const int X_GROUPS_SUPPORT = 1;
+const int X_SIGALGS_SUPPORT = 1;
+
+long X_SSL_CTX_add1_chain_cert(SSL_CTX *ctx, X509 *cert, X_result *res) {
+	long rv;
+	x_result_begin(res);
+	rv = SSL_CTX_add1_chain_cert(ctx, cert);
+	x_result_end(res);
+	return rv;
+}
+
+long X_SSL_CTX_clear_chain_certs(SSL_CTX *ctx) {
+	return SSL_CTX_clear_chain_certs(ctx);
+}
+
+STACK_OF(SSL_CIPHER) *X_SSL_get1_supported_ciphers(SSL *ssl) {
+	return SSL_get1_supported_ciphers(ssl);
+}
+
+int X_SSL_CTX_set1_groups_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = (int)SSL_CTX_set1_curves_list(ctx, (char *)list);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_set1_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = (int)SSL_CTX_set1_sigalgs_list(ctx, (char *)list);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_set1_client_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = (int)SSL_CTX_set1_client_sigalgs_list(ctx, (char *)list);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_get_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code) {
+	unsigned char rsig, rhash;
+	int n = SSL_get_sigalgs(ssl, idx, sign, hash, NULL, &rsig, &rhash);
+	*code = (rhash << 8) | rsig;
+	return n;
+}
+
+int X_SSL_get_shared_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code) {
+	unsigned char rsig, rhash;
+	int n = SSL_get_shared_sigalgs(ssl, idx, sign, hash, NULL, &rsig, &rhash);
+	*code = (rhash << 8) | rsig;
+	return n;
+}
+
+const SSL_CIPHER *X_SSL_CIPHER_find(SSL *ssl, int id) {
+	unsigned char ptr[2] = { (id >> 8) & 0xff, id & 0xff };
+	return SSL_CIPHER_find(ssl, ptr);
+}
+
+#else
+
+const int X_CERT_CHAIN_SUPPORT = 0;
+const int X_GROUPS_SUPPORT = 0;
+const int X_SIGALGS_SUPPORT = 0;
+
+long X_SSL_CTX_add1_chain_cert(SSL_CTX *ctx, X509 *cert, X_result *res) {
+	x_result_begin(res);
+	return 0;
+}
+
+long X_SSL_CTX_clear_chain_certs(SSL_CTX *ctx) {
+	return 0;
+}
+
+STACK_OF(SSL_CIPHER) *X_SSL_get1_supported_ciphers(SSL *ssl) {
+	STACK_OF(SSL_CIPHER) *sk = SSL_get_ciphers(ssl);
+	return sk == NULL ? NULL : sk_SSL_CIPHER_dup(sk);
+}
+
+int X_SSL_CTX_set1_groups_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	x_result_begin(res);
+	return 0;
+}
+
+int X_SSL_CTX_set1_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	x_result_begin(res);
+	return 0;
+}
+
+int X_SSL_CTX_set1_client_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	x_result_begin(res);
+	return 0;
+}
+
+int X_SSL_get_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code) {
+	return 0;
+}
+
+int X_SSL_get_shared_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code) {
+	return 0;
+}
+
+const SSL_CIPHER *X_SSL_CIPHER_find(SSL *ssl, int id) {
+	return NULL;
+}
+
+#endif
+
+/*
+ ************************************************
+ * certificate sets, v1.1.1 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+
+const int X_CERT_AND_KEY_SUPPORT = 1;
+
+// checks everything before it changes the context
+int X_SSL_CTX_use_cert_and_key(SSL_CTX *ctx, X509 *cert, EVP_PKEY *key,
+		X509 **chain, int nchain, X_result *res) {
+	STACK_OF(X509) *sk;
+	int i, rv = 0;
+	x_result_begin(res);
+	sk = sk_X509_new_null();
+	if (sk != NULL) {
+		for (i = 0; i < nchain; i++) {
+			if (!sk_X509_push(sk, chain[i])) {
+				break;
+			}
+		}
+		// the context takes its own references of the chain
+		if (i == nchain) {
+			rv = SSL_CTX_use_cert_and_key(ctx, cert, key, sk, 1);
+		}
+		sk_X509_free(sk);
+	}
+	x_result_end(res);
+	return rv;
+}
+
+#else
+
+const int X_CERT_AND_KEY_SUPPORT = 0;
+
+int X_SSL_CTX_use_cert_and_key(SSL_CTX *ctx, X509 *cert, EVP_PKEY *key,
+		X509 **chain, int nchain, X_result *res) {
+	x_result_begin(res);
+	return 0;
+}
+
+#endif
+
+/*
+ ************************************************
+ * security levels, v1.1.0 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+
+const int X_SECURITY_LEVEL_SUPPORT = 1;
+
+typedef int (*x_security_cb_fn)(const SSL *s, const SSL_CTX *ctx, int op,
+		int bits, int nid, void *other, void *ex);
+
+static x_security_cb_fn x_default_security_cb;
+
+static int x_security_cb(const SSL *s, const SSL_CTX *ctx, int op, int bits,
+		int nid, void *other, void *ex) {
+	int allowed = x_default_security_cb(s, ctx, op, bits, nid, other, ex);
+	// checks on a connection don't get its context passed
+	if (s != NULL) {
+		ctx = SSL_get_SSL_CTX(s);
+	}
+	void* p = SSL_CTX_get_ex_data(ctx, get_ssl_ctx_idx());
+	return go_security_cb_thunk(p, (SSL *)s, op, bits, nid, allowed);
+}
+
+void X_SSL_CTX_set_security_callback(SSL_CTX *ctx, int enable) {
+	// every context starts out with the default callback
+	if (x_default_security_cb == NULL) {
+		x_default_security_cb = SSL_CTX_get_security_callback(ctx);
+	}
+	SSL_CTX_set_security_callback(ctx,
+		enable ? x_security_cb : x_default_security_cb);
+}
+
+void X_SSL_CTX_set_security_level(SSL_CTX *ctx, int level) {
+	SSL_CTX_set_security_level(ctx, level);
+}
+
+int X_SSL_CTX_get_security_level(SSL_CTX *ctx) {
+	return SSL_CTX_get_security_level(ctx);
+}
+
+void X_SSL_set_security_level(SSL *ssl, int level) {
+	SSL_set_security_level(ssl, level);
+}
+
+int X_SSL_get_security_level(SSL *ssl) {
+	return SSL_get_security_level(ssl);
+}
+
+#else
+
+const int X_SECURITY_LEVEL_SUPPORT = 0;
+
+void X_SSL_CTX_set_security_callback(SSL_CTX *ctx, int enable) {
+}
+
+void X_SSL_CTX_set_security_level(SSL_CTX *ctx, int level) {
+}
+
+int X_SSL_CTX_get_security_level(SSL_CTX *ctx) {
+	return 0;
+}
+
+void X_SSL_set_security_level(SSL *ssl, int level) {
+}
+
+int X_SSL_get_security_level(SSL *ssl) {
+	return 0;
+}
+
+#endif
+
+/*
+ ************************************************
+ * v3.0 and later implementation
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+
+const int X_NEGOTIATED_GROUP_SUPPORT = 1;
+
+const char *X_SSL_get_negotiated_group_name(SSL *ssl) {
+	int nid = SSL_get_negotiated_group(ssl);
+	if (nid == NID_undef) {
+		return NULL;
+	}
+	return SSL_group_to_name(ssl, nid);
+}
+
+#else
+
+const int X_NEGOTIATED_GROUP_SUPPORT = 0;
+
+const char *X_SSL_get_negotiated_group_name(SSL *ssl) {
+	return NULL;
+}
+
+#endif
+
+/*
+ ************************************************
+ * low-level digest states
+ ************************************************
+ */
+
+// The EVP interface keeps digest states opaque, marshalable hashes run on
+// the low-level contexts of these digests instead. The marshaled
+// form is the one of the Go standard library: a magic, the chaining values
+// in big-endian, the pending block padded with zeros and the length hashed
+// so far in bytes.
+
+typedef union {
+	MD5_CTX md5;
+	SHA_CTX sha1;
+	SHA256_CTX sha256;
+	SHA512_CTX sha512;
+} x_md_state;
+
+size_t X_md_state_size(int nid) {
+	switch (nid) {
+	case NID_md5:
+	case NID_sha1:
+	case NID_sha224:
+	case NID_sha256:
+	case NID_sha384:
+	case NID_sha512:
+		return sizeof(x_md_state);
+	}
+	return 0;
+}
+
+// The low-level functions bypass the FIPS provider, or abort in the FIPS
+// mode of 1.0.x.
+int X_md_state_allowed(void) {
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	return !EVP_default_properties_is_fips_enabled(NULL);
+#else
+	return !FIPS_mode();
+#endif
+}
+
+int X_md_state_init(int nid, void *state) {
+	x_md_state *s = state;
+	switch (nid) {
+	case NID_md5:
+		return MD5_Init(&s->md5);
+	case NID_sha1:
+		return SHA1_Init(&s->sha1);
+	case NID_sha224:
+		return SHA224_Init(&s->sha256);
+	case NID_sha256:
+		return SHA256_Init(&s->sha256);
+	case NID_sha384:
+		return SHA384_Init(&s->sha512);
+	case NID_sha512:
+		return SHA512_Init(&s->sha512);
+	}
+	return 0;
+}
+
+int X_md_state_update(int nid, void *state, const void *data, size_t len) {
+	x_md_state *s = state;
+	switch (nid) {
+	case NID_md5:
+		return MD5_Update(&s->md5, data, len);
+	case NID_sha1:
+		return SHA1_Update(&s->sha1, data, len);
+	case NID_sha224:
+		return SHA224_Update(&s->sha256, data, len);
+	case NID_sha256:
+		return SHA256_Update(&s->sha256, data, len);
+	case NID_sha384:
+		return SHA384_Update(&s->sha512, data, len);
+	case NID_sha512:
+		return SHA512_Update(&s->sha512, data, len);
+	}
+	return 0;
+}
+
+// X_md_state_sum finalizes a copy of state, leaving state unchanged.
+int X_md_state_sum(int nid, const void *state, unsigned char *md) {
+	x_md_state s = *(const x_md_state *)state;
+	switch (nid) {
+	case NID_md5:
+		return MD5_Final(md, &s.md5);
+	case NID_sha1:
+		return SHA1_Final(md, &s.sha1);
+	case NID_sha224:
+		return SHA224_Final(md, &s.sha256);
+	case NID_sha256:
+		return SHA256_Final(md, &s.sha256);
+	case NID_sha384:
+		return SHA384_Final(md, &s.sha512);
+	case NID_sha512:
+		return SHA512_Final(md, &s.sha512);
+	}
+	return 0;
+}
+
+static unsigned char *x_put_u32(unsigned char *p, uint32_t v) {
+	*p++ = v >> 24;
+	*p++ = v >> 16;
+	*p++ = v >> 8;
+	*p++ = v;
+	return p;
+}
+
+static unsigned char *x_put_u64(unsigned char *p, uint64_t v) {
+	p = x_put_u32(p, v >> 32);
+	return x_put_u32(p, v);
+}
+
+static const unsigned char *x_get_u32(const unsigned char *p, uint32_t *v) {
+	*v = (uint32_t)p[0] << 24 | (uint32_t)p[1] << 16 |
+		(uint32_t)p[2] << 8 | (uint32_t)p[3];
+	return p + 4;
+}
+
+static const unsigned char *x_get_u64(const unsigned char *p, uint64_t *v) {
+	uint32_t hi, lo;
+	p = x_get_u32(p, &hi);
+	p = x_get_u32(p, &lo);
+	*v = (uint64_t)hi << 32 | lo;
+	return p;
+}
+
+static unsigned char *x_put_block(unsigned char *p, const void *data,
+		size_t num, size_t block_size) {
+	memcpy(p, data, num);
+	memset(p + num, 0, block_size - num);
+	return p + block_size;
+}
+
+static const char *x_md_state_magic(int nid) {
+	switch (nid) {
+	case NID_md5:
+		return "md5\x01";
+	case NID_sha1:
+		return "sha\x01";
+	case NID_sha224:
+		return "sha\x02";
+	case NID_sha256:
+		return "sha\x03";
+	case NID_sha384:
+		return "sha\x04";
+	case NID_sha512:
+		return "sha\x07";
+	}
+	return NULL;
+}
+
+// X_md_state_marshal writes state to out, which has room for
+// X_MD_STATE_MARSHALED_MAX bytes, and returns the length written.
+int X_md_state_marshal(int nid, const void *state, unsigned char *out) {
+	const x_md_state *s = state;
+	const char *magic = x_md_state_magic(nid);
+	unsigned char *p = out;
+	int i;
+	if (magic == NULL) {
+		return 0;
+	}
+	memcpy(p, magic, 4);
+	p += 4;
+	switch (nid) {
+	case NID_md5:
+		p = x_put_u32(p, s->md5.A);
+		p = x_put_u32(p, s->md5.B);
+		p = x_put_u32(p, s->md5.C);
+		p = x_put_u32(p, s->md5.D);
+		p = x_put_block(p, s->md5.data, s->md5.num, MD5_CBLOCK);
+		p = x_put_u64(p, ((uint64_t)s->md5.Nh << 32 | s->md5.Nl) >> 3);
+		break;
+	case NID_sha1:
+		p = x_put_u32(p, s->sha1.h0);
+		p = x_put_u32(p, s->sha1.h1);
+		p = x_put_u32(p, s->sha1.h2);
+		p = x_put_u32(p, s->sha1.h3);
+		p = x_put_u32(p, s->sha1.h4);
+		p = x_put_block(p, s->sha1.data, s->sha1.num, SHA_CBLOCK);
+		p = x_put_u64(p, ((uint64_t)s->sha1.Nh << 32 | s->sha1.Nl) >> 3);
+		break;
+	case NID_sha224:
+	case NID_sha256:
+		for (i = 0; i < 8; i++) {
+			p = x_put_u32(p, s->sha256.h[i]);
+		}
+		p = x_put_block(p, s->sha256.data, s->sha256.num, SHA256_CBLOCK);
+		p = x_put_u64(p,
+			((uint64_t)s->sha256.Nh << 32 | s->sha256.Nl) >> 3);
+		break;
+	case NID_sha384:
+	case NID_sha512:
+		for (i = 0; i < 8; i++) {
+			p = x_put_u64(p, s->sha512.h[i]);
+		}
+		p = x_put_block(p, s->sha512.u.p, s->sha512.num, SHA512_CBLOCK);
+		p = x_put_u64(p, s->sha512.Nh << 61 | s->sha512.Nl >> 3);
+		break;
+	}
+	return p - out;
+}
+
+int X_md_state_unmarshal(int nid, void *state, const unsigned char *in,
+		size_t len) {
+	x_md_state *s = state;
+	const char *magic = x_md_state_magic(nid);
+	const unsigned char *p = in;
+	uint64_t hashed;
+	size_t size, block_size;
+	int i;
+	if (magic == NULL) {
+		return 0;
+	}
+	switch (nid) {
+	case NID_md5:
+		block_size = MD5_CBLOCK;
+		size = 4 + 4 * 4 + block_size + 8;
+		break;
+	case NID_sha1:
+		block_size = SHA_CBLOCK;
+		size = 4 + 5 * 4 + block_size + 8;
+		break;
+	case NID_sha224:
+	case NID_sha256:
+		block_size = SHA256_CBLOCK;
+		size = 4 + 8 * 4 + block_size + 8;
+		break;
+	default:
+		block_size = SHA512_CBLOCK;
+		size = 4 + 8 * 8 + block_size + 8;
+		break;
+	}
+	if (len != size || memcmp(p, magic, 4) != 0) {
+		return 0;
+	}
+	p += 4;
+	// initializing sets the output length of truncated variants
+	if (X_md_state_init(nid, state) != 1) {
+		return 0;
+	}
+	x_get_u64(in + size - 8, &hashed);
+	switch (nid) {
+	case NID_md5:
+		p = x_get_u32(p, &s->md5.A);
+		p = x_get_u32(p, &s->md5.B);
+		p = x_get_u32(p, &s->md5.C);
+		p = x_get_u32(p, &s->md5.D);
+		memcpy(s->md5.data, p, block_size);
+		s->md5.num = hashed % block_size;
+		s->md5.Nl = (uint32_t)(hashed << 3);
+		s->md5.Nh = (uint32_t)(hashed >> 29);
+		break;
+	case NID_sha1:
+		p = x_get_u32(p, &s->sha1.h0);
+		p = x_get_u32(p, &s->sha1.h1);
+		p = x_get_u32(p, &s->sha1.h2);
+		p = x_get_u32(p, &s->sha1.h3);
+		p = x_get_u32(p, &s->sha1.h4);
+		memcpy(s->sha1.data, p, block_size);
+		s->sha1.num = hashed % block_size;
+		s->sha1.Nl = (uint32_t)(hashed << 3);
+		s->sha1.Nh = (uint32_t)(hashed >> 29);
+		break;
+	case NID_sha224:
+	case NID_sha256:
+		for (i = 0; i < 8; i++) {
+			p = x_get_u32(p, &s->sha256.h[i]);
+		}
+		memcpy(s->sha256.data, p, block_size);
+		s->sha256.num = hashed % block_size;
+		s->sha256.Nl = (uint32_t)(hashed << 3);
+		s->sha256.Nh = (uint32_t)(hashed >> 29);
+		break;
+	default:
+		for (i = 0; i < 8; i++) {
+			uint64_t h;
+			p = x_get_u64(p, &h);
+			s->sha512.h[i] = h;
+		}
+		memcpy(s->sha512.u.p, p, block_size);
+		s->sha512.num = hashed % block_size;
+		s->sha512.Nl = hashed << 3;
+		s->sha512.Nh = hashed >> 61;
+		break;
+	}
+	return 1;
+}
+
+/*
+ ************************************************
+ * extendable-output functions, v1.1.1 and later
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+
+const int X_XOF_SUPPORT = 1;
+
+int X_EVP_MD_is_xof(const EVP_MD *md) {
+	return (EVP_MD_flags(md) & EVP_MD_FLAG_XOF) != 0;
+}
+
+int X_EVP_DigestFinalXOF(EVP_MD_CTX *ctx, unsigned char *md, size_t len) {
+	return EVP_DigestFinalXOF(ctx, md, len);
+}
+
+#else
+
+const int X_XOF_SUPPORT = 0;
+
+int X_EVP_MD_is_xof(const EVP_MD *md) {
+	return 0;
+}
+
+int X_EVP_DigestFinalXOF(EVP_MD_CTX *ctx, unsigned char *md, size_t len) {
+	return 0;
+}
+
+#endif
+
+// OpenSSL 3.3 squeezes incrementally, before only a single final call is
+// allowed
+#if OPENSSL_VERSION_NUMBER >= 0x30300000L
+
+const int X_XOF_SQUEEZE_SUPPORT = 1;
+
+int X_EVP_DigestSqueeze(EVP_MD_CTX *ctx, unsigned char *out, size_t len) {
+	return EVP_DigestSqueeze(ctx, out, len);
+}
+
+#else
+
+const int X_XOF_SQUEEZE_SUPPORT = 0;
+
+int X_EVP_DigestSqueeze(EVP_MD_CTX *ctx, unsigned char *out, size_t len) {
+	return 0;
+}
+
+#endif
+
+/*
+ ************************************************
+ * message authentication codes
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+
+const int X_EVP_MAC_SUPPORT = 1;
+
+// init is the freshly keyed context. Reset starts over from a copy of it,
+// since some MACs, such as Poly1305, can't be reinitialized without the key.
+struct x_mac_st {
+	EVP_MAC_CTX *ctx;
+	EVP_MAC_CTX *init;
+};
+
+X_MAC *X_MAC_new(const char *alg, const unsigned char *key, size_t key_len,
+		const EVP_MD *md, const EVP_CIPHER *cipher, const unsigned char *iv,
+		size_t iv_len, const unsigned char *custom, size_t custom_len,
+		size_t size, X_result *res) {
+	OSSL_PARAM params[6], *p = params;
+	EVP_MAC *mac;
+	X_MAC *m = NULL;
+	x_result_begin(res);
+	if (md != NULL) {
+		*p++ = OSSL_PARAM_construct_utf8_string(OSSL_MAC_PARAM_DIGEST,
+			(char *)EVP_MD_get0_name(md), 0);
+	}
+	if (cipher != NULL) {
+		*p++ = OSSL_PARAM_construct_utf8_string(OSSL_MAC_PARAM_CIPHER,
+			(char *)EVP_CIPHER_get0_name(cipher), 0);
+	}
+	if (iv_len != 0) {
+		*p++ = OSSL_PARAM_construct_octet_string(OSSL_MAC_PARAM_IV,
+			(void *)iv, iv_len);
+	}
+	if (custom_len != 0) {
+		*p++ = OSSL_PARAM_construct_octet_string(OSSL_MAC_PARAM_CUSTOM,
+			(void *)custom, custom_len);
+	}
+	if (size != 0) {
+		*p++ = OSSL_PARAM_construct_size_t(OSSL_MAC_PARAM_SIZE, &size);
+	}
+	*p = OSSL_PARAM_construct_end();
+
+	mac = EVP_MAC_fetch(NULL, alg, NULL);
+	if (mac == NULL) {
+		goto end;
+	}
+	m = OPENSSL_zalloc(sizeof(*m));
+	if (m == NULL) {
+		goto end;
+	}
+	m->init = EVP_MAC_CTX_new(mac);
+	if (m->init == NULL || !EVP_MAC_init(m->init, key, key_len, params) ||
+			!X_MAC_reset(m)) {
+		X_MAC_free(m);
+		m = NULL;
+	}
+end:
+	EVP_MAC_free(mac);
+	x_result_end(res);
+	return m;
+}
+
+void X_MAC_free(X_MAC *m) {
+	if (m != NULL) {
+		EVP_MAC_CTX_free(m->ctx);
+		EVP_MAC_CTX_free(m->init);
+		OPENSSL_free(m);
+	}
+}
+
+X_MAC *X_MAC_dup(X_MAC *m) {
+	X_MAC *dup = OPENSSL_zalloc(sizeof(*dup));
+	if (dup == NULL) {
+		return NULL;
+	}
+	dup->ctx = EVP_MAC_CTX_dup(m->ctx);
+	dup->init = EVP_MAC_CTX_dup(m->init);
+	if (dup->ctx == NULL || dup->init == NULL) {
+		X_MAC_free(dup);
+		return NULL;
+	}
+	return dup;
+}
+
+size_t X_MAC_size(X_MAC *m) {
+	return EVP_MAC_CTX_get_mac_size(m->ctx);
+}
+
+int X_MAC_reset(X_MAC *m) {
+	EVP_MAC_CTX *ctx = EVP_MAC_CTX_dup(m->init);
+	if (ctx == NULL) {
+		return 0;
+	}
+	EVP_MAC_CTX_free(m->ctx);
+	m->ctx = ctx;
+	return 1;
+}
+
+int X_MAC_update(X_MAC *m, const void *data, size_t len) {
+	return EVP_MAC_update(m->ctx, data, len);
+}
+
+int X_MAC_sum(X_MAC *m, unsigned char *out, size_t out_size) {
+	size_t out_len;
+	int rv;
+	EVP_MAC_CTX *ctx = EVP_MAC_CTX_dup(m->ctx);
+	if (ctx == NULL) {
+		return 0;
+	}
+	rv = EVP_MAC_final(ctx, out, &out_len, out_size);
+	EVP_MAC_CTX_free(ctx);
+	return rv == 1 && out_len == out_size;
+}
+
+#else
+
+const int X_EVP_MAC_SUPPORT = 0;
+
+// before 3.0 MACs are computed with signing contexts and MAC keys
+struct x_mac_st {
+	EVP_MD_CTX *ctx;
+	EVP_PKEY *pkey;
+	const EVP_MD *md;
+};
+
+static EVP_PKEY *x_mac_pkey(const char *alg, const unsigned char *key,
+		size_t key_len, const EVP_CIPHER *cipher) {
+	if (strcmp(alg, "HMAC") == 0) {
+		return EVP_PKEY_new_mac_key(EVP_PKEY_HMAC, NULL, key, key_len);
+	}
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+	if (strcmp(alg, "CMAC") == 0 && cipher != NULL) {
+		return EVP_PKEY_new_CMAC_key(NULL, key, key_len, cipher);
+	}
+	if (strcmp(alg, "POLY1305") == 0) {
+		return EVP_PKEY_new_raw_private_key(EVP_PKEY_POLY1305, NULL, key,
+			key_len);
+	}
+	if (strcmp(alg, "SIPHASH") == 0) {
+		return EVP_PKEY_new_raw_private_key(EVP_PKEY_SIPHASH, NULL, key,
+			key_len);
+	}
+#endif
+	return NULL;
+}
+
+X_MAC *X_MAC_new(const char *alg, const unsigned char *key, size_t key_len,
+		const EVP_MD *md, const EVP_CIPHER *cipher, const unsigned char *iv,
+		size_t iv_len, const unsigned char *custom, size_t custom_len,
+		size_t size, X_result *res) {
+	X_MAC *m = NULL;
+	x_result_begin(res);
+	// the parameters only the provider implementations take
+	if (iv_len != 0 || custom_len != 0 || size != 0) {
+		goto end;
+	}
+	m = OPENSSL_malloc(sizeof(*m));
+	if (m == NULL) {
+		goto end;
+	}
+	m->md = md;
+	m->ctx = X_EVP_MD_CTX_new();
+	m->pkey = x_mac_pkey(alg, key, key_len, cipher);
+	if (m->ctx == NULL || m->pkey == NULL || !X_MAC_reset(m)) {
+		X_MAC_free(m);
+		m = NULL;
+	}
+end:
+	x_result_end(res);
+	return m;
+}
+
+void X_MAC_free(X_MAC *m) {
+	if (m != NULL) {
+		if (m->ctx != NULL) {
+			X_EVP_MD_CTX_free(m->ctx);
+		}
+		EVP_PKEY_free(m->pkey);
+		OPENSSL_free(m);
+	}
+}
+
+X_MAC *X_MAC_dup(X_MAC *m) {
+	X_MAC *dup = OPENSSL_malloc(sizeof(*dup));
+	if (dup == NULL) {
+		return NULL;
+	}
+	dup->md = m->md;
+	dup->pkey = NULL;
+	dup->ctx = X_EVP_MD_CTX_new();
+	if (dup->ctx == NULL || !EVP_MD_CTX_copy_ex(dup->ctx, m->ctx)) {
+		X_MAC_free(dup);
+		return NULL;
+	}
+	dup->pkey = m->pkey;
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+	EVP_PKEY_up_ref(dup->pkey);
+#else
+	CRYPTO_add(&dup->pkey->references, 1, CRYPTO_LOCK_EVP_PKEY);
+#endif
+	return dup;
+}
+
+size_t X_MAC_size(X_MAC *m) {
+	size_t len = 0;
+	if (!EVP_DigestSignFinal(m->ctx, NULL, &len)) {
+		return 0;
+	}
+	return len;
+}
+
+int X_MAC_reset(X_MAC *m) {
+	return EVP_DigestSignInit(m->ctx, NULL, m->md, NULL, m->pkey);
+}
+
+int X_MAC_update(X_MAC *m, const void *data, size_t len) {
+	return EVP_DigestSignUpdate(m->ctx, data, len);
+}
+
+int X_MAC_sum(X_MAC *m, unsigned char *out, size_t out_size) {
+	size_t out_len = out_size;
+	int rv;
+	EVP_MD_CTX *ctx = X_EVP_MD_CTX_new();
+	if (ctx == NULL) {
+		return 0;
+	}
+	rv = EVP_MD_CTX_copy_ex(ctx, m->ctx) &&
+		EVP_DigestSignFinal(ctx, out, &out_len);
+	X_EVP_MD_CTX_free(ctx);
+	return rv == 1 && out_len == out_size;
+}
+
+#endif
+
+/*
+ ************************************************
+ * key derivation functions
+ ************************************************
+ */
+
+// scrypt memory limit, the 3.0 provider default, so that N = 2^20 and r = 8
+// still fit
+#define X_SCRYPT_MAXMEM ((uint64_t)1025 * 1024 * 1024)
+
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+
+const int X_KDF_SUPPORT = 1;
+const int X_EVP_KDF_SUPPORT = 1;
+
+// Argon2 and its parameters are only provided since 3.2
+#if OPENSSL_VERSION_NUMBER >= 0x30200000L
+const int X_ARGON2_SUPPORT = 1;
+#else
+const int X_ARGON2_SUPPORT = 0;
+#define OSSL_KDF_PARAM_ARGON2_LANES "lanes"
+#define OSSL_KDF_PARAM_ARGON2_MEMCOST "memcost"
+#endif
+
+int X_KDF_derive(const char *alg, X_KDF_params *kp, unsigned char *out,
+		size_t out_len, X_result *res) {
+	OSSL_PARAM params[10], *p = params;
+	int password = strcmp(alg, "PBKDF2") == 0 ||
+		strcmp(alg, "SCRYPT") == 0 || strcmp(alg, "ARGON2ID") == 0;
+	int tls1_prf = strcmp(alg, "TLS1-PRF") == 0;
+	int pkcs5 = 1;
+	uint64_t scrypt_maxmem = X_SCRYPT_MAXMEM;
+	EVP_KDF *kdf;
+	EVP_KDF_CTX *ctx = NULL;
+	int rv = 0;
+	x_result_begin(res);
+	if (kp->md != NULL) {
+		*p++ = OSSL_PARAM_construct_utf8_string(OSSL_KDF_PARAM_DIGEST,
+			(char *)EVP_MD_get0_name(kp->md), 0);
+	}
+	if (kp->mode >= 0) {
+		*p++ = OSSL_PARAM_construct_int(OSSL_KDF_PARAM_MODE, &kp->mode);
+	}
+	*p++ = OSSL_PARAM_construct_octet_string(password ?
+		OSSL_KDF_PARAM_PASSWORD : tls1_prf ?
+		OSSL_KDF_PARAM_SECRET : OSSL_KDF_PARAM_KEY,
+		(void *)kp->key, kp->key_len);
+	// password hashes need a salt, even an empty one
+	if (password || kp->salt_len != 0) {
+		*p++ = OSSL_PARAM_construct_octet_string(OSSL_KDF_PARAM_SALT,
+			(void *)kp->salt, kp->salt_len);
+	}
+	if (kp->info_len != 0) {
+		*p++ = OSSL_PARAM_construct_octet_string(tls1_prf ?
+			OSSL_KDF_PARAM_SEED : OSSL_KDF_PARAM_INFO,
+			(void *)kp->info, kp->info_len);
+	}
+	if (kp->iter != 0) {
+		*p++ = OSSL_PARAM_construct_uint64(OSSL_KDF_PARAM_ITER, &kp->iter);
+	}
+	if (strcmp(alg, "PBKDF2") == 0) {
+		// the SP 800-132 lower bounds are not part of PBKDF2 itself
+		*p++ = OSSL_PARAM_construct_int(OSSL_KDF_PARAM_PKCS5, &pkcs5);
+	}
+	if (kp->n != 0) {
+		*p++ = OSSL_PARAM_construct_uint64(OSSL_KDF_PARAM_SCRYPT_N, &kp->n);
+		*p++ = OSSL_PARAM_construct_uint32(OSSL_KDF_PARAM_SCRYPT_R, &kp->r);
+		*p++ = OSSL_PARAM_construct_uint32(OSSL_KDF_PARAM_SCRYPT_P, &kp->p);
+		*p++ = OSSL_PARAM_construct_uint64(OSSL_KDF_PARAM_SCRYPT_MAXMEM,
+			&scrypt_maxmem);
+	}
+	if (kp->lanes != 0) {
+		*p++ = OSSL_PARAM_construct_uint32(OSSL_KDF_PARAM_ARGON2_LANES,
+			&kp->lanes);
+		*p++ = OSSL_PARAM_construct_uint32(OSSL_KDF_PARAM_ARGON2_MEMCOST,
+			&kp->memcost);
+	}
+	*p = OSSL_PARAM_construct_end();
+
+	kdf = EVP_KDF_fetch(NULL, alg, NULL);
+	if (kdf == NULL) {
+		goto end;
+	}
+	ctx = EVP_KDF_CTX_new(kdf);
+	if (ctx == NULL) {
+		goto end;
+	}
+	rv = EVP_KDF_derive(ctx, out, out_len, params);
+end:
+	EVP_KDF_CTX_free(ctx);
+	EVP_KDF_free(kdf);
+	x_result_end(res);
+	return rv;
+}
+
+#else
+
+const int X_EVP_KDF_SUPPORT = 0;
+const int X_ARGON2_SUPPORT = 0;
+
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+
+const int X_KDF_SUPPORT = 1;
+
+static int x_kdf_pkey_derive(const char *alg, X_KDF_params *kp,
+		unsigned char *out, size_t out_len) {
+	int hkdf = strcmp(alg, "HKDF") == 0;
+	EVP_PKEY_CTX *ctx = EVP_PKEY_CTX_new_id(hkdf ?
+		EVP_PKEY_HKDF : EVP_PKEY_TLS1_PRF, NULL);
+	int rv = 0;
+	if (ctx == NULL || EVP_PKEY_derive_init(ctx) <= 0) {
+		goto end;
+	}
+	if (hkdf) {
+#if OPENSSL_VERSION_NUMBER >= 0x1010100fL
+		if (EVP_PKEY_CTX_hkdf_mode(ctx, kp->mode) <= 0) {
+			goto end;
+		}
+#else
+		if (kp->mode != 0) {
+			goto end;
+		}
+#endif
+		if (EVP_PKEY_CTX_set_hkdf_md(ctx, kp->md) <= 0 ||
+				EVP_PKEY_CTX_set1_hkdf_key(ctx, kp->key, kp->key_len) <= 0 ||
+				(kp->salt_len != 0 && EVP_PKEY_CTX_set1_hkdf_salt(ctx,
+					kp->salt, kp->salt_len) <= 0) ||
+				(kp->info_len != 0 && EVP_PKEY_CTX_add1_hkdf_info(ctx,
+					kp->info, kp->info_len) <= 0)) {
+			goto end;
+		}
+	} else {
+		if (EVP_PKEY_CTX_set_tls1_prf_md(ctx, kp->md) <= 0 ||
+				EVP_PKEY_CTX_set1_tls1_prf_secret(ctx, kp->key,
+					kp->key_len) <= 0 ||
+				EVP_PKEY_CTX_add1_tls1_prf_seed(ctx, kp->info,
+					kp->info_len) <= 0) {
+			goto end;
+		}
+	}
+	rv = EVP_PKEY_derive(ctx, out, &out_len);
+end:
+	EVP_PKEY_CTX_free(ctx);
+	return rv;
+}
+
+#else
+
+// only PBKDF2 is available
+const int X_KDF_SUPPORT = 0;
+
+#endif
+
+int X_KDF_derive(const char *alg, X_KDF_params *kp, unsigned char *out,
+		size_t out_len, X_result *res) {
+	int rv = 0;
+	x_result_begin(res);
+	if (strcmp(alg, "PBKDF2") == 0) {
+		rv = PKCS5_PBKDF2_HMAC((const char *)kp->key, kp->key_len, kp->salt,
+			kp->salt_len, kp->iter, kp->md, out_len, out);
+#if OPENSSL_VERSION_NUMBER >= 0x1010000fL
+	} else if (strcmp(alg, "HKDF") == 0 || strcmp(alg, "TLS1-PRF") == 0) {
+		rv = x_kdf_pkey_derive(alg, kp, out, out_len);
+	} else if (strcmp(alg, "SCRYPT") == 0) {
+		rv = EVP_PBE_scrypt((const char *)kp->key, kp->key_len, kp->salt,
+			kp->salt_len, kp->n, kp->r, kp->p, X_SCRYPT_MAXMEM, out,
+			out_len);
+#endif
+	}
+	x_result_end(res);
+	return rv;
+}
+
+#endif
+
+/*
+ ************************************************
+ * authenticated encryption
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30200000L
+const int X_AEAD_GCM_SIV_SUPPORT = 1;
+#else
+const int X_AEAD_GCM_SIV_SUPPORT = 0;
+#endif
+
+// the AEAD controls were GCM specific and OCB did not exist before 1.1.0
+#if OPENSSL_VERSION_NUMBER < 0x1010000fL
+#define EVP_CTRL_AEAD_SET_IVLEN EVP_CTRL_GCM_SET_IVLEN
+#define EVP_CTRL_AEAD_GET_TAG EVP_CTRL_GCM_GET_TAG
+#define EVP_CTRL_AEAD_SET_TAG EVP_CTRL_GCM_SET_TAG
+#define EVP_CIPH_OCB_MODE -1
+#endif
+
+struct x_aead_st {
+	EVP_CIPHER *fetched;
+	EVP_CIPHER_CTX *enc;
+	EVP_CIPHER_CTX *dec;
+	size_t tag_len;
+	int ccm;
+};
+
+// x_aead_init keys ctx once, later operations only set the nonce. The nonce
+// and, for CCM and OCB, the tag length have to be set before the key. The
+// nonce length CCM reports is not the one it defaults to.
+static int x_aead_init(EVP_CIPHER_CTX *ctx, const EVP_CIPHER *cipher,
+		const unsigned char *key, size_t nonce_len, size_t tag_len,
+		int enc) {
+	int mode = EVP_CIPHER_mode(cipher);
+	if (EVP_CipherInit_ex(ctx, cipher, NULL, NULL, NULL, enc) != 1) {
+		return 0;
+	}
+	if ((mode == EVP_CIPH_CCM_MODE ||
+			nonce_len != (size_t)EVP_CIPHER_iv_length(cipher)) &&
+			EVP_CIPHER_CTX_ctrl(ctx, EVP_CTRL_AEAD_SET_IVLEN,
+				(int)nonce_len, NULL) != 1) {
+		return 0;
+	}
+	if ((mode == EVP_CIPH_CCM_MODE || mode == EVP_CIPH_OCB_MODE) &&
+			EVP_CIPHER_CTX_ctrl(ctx, EVP_CTRL_AEAD_SET_TAG, (int)tag_len,
+				NULL) != 1) {
+		return 0;
+	}
+	return EVP_CipherInit_ex(ctx, NULL, NULL, key, NULL, enc);
+}
+
+void X_AEAD_free(X_AEAD *a) {
+	if (a == NULL) {
+		return;
+	}
+	EVP_CIPHER_CTX_free(a->enc);
+	EVP_CIPHER_CTX_free(a->dec);
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	EVP_CIPHER_free(a->fetched);
+#endif
+	OPENSSL_free(a);
+}
+
+X_AEAD *X_AEAD_new(const char *name, const unsigned char *key, size_t key_len,
+		size_t nonce_len, size_t tag_len, X_result *res) {
+	const EVP_CIPHER *cipher;
+	X_AEAD *a;
+	x_result_begin(res);
+	a = OPENSSL_malloc(sizeof(*a));
+	if (a == NULL) {
+		goto err;
+	}
+	memset(a, 0, sizeof(*a));
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+	// fetch once rather than on every initialization
+	a->fetched = EVP_CIPHER_fetch(NULL, name, NULL);
+	cipher = a->fetched;
+#else
+	cipher = EVP_get_cipherbyname(name);
+#endif
+	if (cipher == NULL || key_len != (size_t)EVP_CIPHER_key_length(cipher)) {
+		goto err;
+	}
+	a->tag_len = tag_len;
+	a->ccm = EVP_CIPHER_mode(cipher) == EVP_CIPH_CCM_MODE;
+	a->enc = EVP_CIPHER_CTX_new();
+	a->dec = EVP_CIPHER_CTX_new();
+	if (a->enc == NULL || a->dec == NULL ||
+			!x_aead_init(a->enc, cipher, key, nonce_len, tag_len, 1) ||
+			!x_aead_init(a->dec, cipher, key, nonce_len, tag_len, 0)) {
+		goto err;
+	}
+	x_result_end(res);
+	return a;
+err:
+	X_AEAD_free(a);
+	x_result_end(res);
+	return NULL;
+}
+
+// x_aead_update passes the total length for CCM, then the additional data
+// and the text. CCM needs the text update even when it is empty.
+static int x_aead_update(X_AEAD *a, EVP_CIPHER_CTX *ctx,
+		const unsigned char *ad, size_t ad_len, const unsigned char *in,
+		size_t in_len, unsigned char *out, int *out_len) {
+	unsigned char empty = 0;
+	int len;
+	*out_len = 0;
+	if (ad_len > INT_MAX || in_len > INT_MAX) {
+		return 0;
+	}
+	if (a->ccm && EVP_CipherUpdate(ctx, NULL, &len, NULL,
+			(int)in_len) != 1) {
+		return 0;
+	}
+	if (ad_len != 0 && EVP_CipherUpdate(ctx, NULL, &len, ad,
+			(int)ad_len) != 1) {
+		return 0;
+	}
+	if (in_len == 0 && !a->ccm) {
+		return 1;
+	}
+	return EVP_CipherUpdate(ctx, out != NULL ? out : &empty, out_len,
+		in != NULL ? in : &empty, (int)in_len);
+}
+
+int X_AEAD_seal(X_AEAD *a, const unsigned char *nonce,
+		const unsigned char *ad, size_t ad_len, const unsigned char *in,
+		size_t in_len, unsigned char *out, unsigned char *tag) {
+	unsigned char empty = 0;
+	int len, final_len;
+	if (EVP_CipherInit_ex(a->enc, NULL, NULL, NULL, nonce, 1) != 1 ||
+			!x_aead_update(a, a->enc, ad, ad_len, in, in_len, out, &len) ||
+			EVP_CipherFinal_ex(a->enc, out != NULL ? out + len : &empty,
+				&final_len) != 1) {
+		return 0;
+	}
+	return EVP_CIPHER_CTX_ctrl(a->enc, EVP_CTRL_AEAD_GET_TAG,
+		(int)a->tag_len, tag);
+}
+
+int X_AEAD_open(X_AEAD *a, const unsigned char *nonce,
+		const unsigned char *ad, size_t ad_len, const unsigned char *in,
+		size_t in_len, unsigned char *out, const unsigned char *tag) {
+	unsigned char empty = 0;
+	int len, final_len;
+	// the tag is set first, CCM verifies it in the text update already
+	if (EVP_CipherInit_ex(a->dec, NULL, NULL, NULL, nonce, 0) != 1 ||
+			EVP_CIPHER_CTX_ctrl(a->dec, EVP_CTRL_AEAD_SET_TAG,
+				(int)a->tag_len, (void *)tag) != 1 ||
+			!x_aead_update(a, a->dec, ad, ad_len, in, in_len, out, &len)) {
+		return 0;
+	}
+	if (a->ccm) {
+		return 1;
+	}
+	return EVP_CipherFinal_ex(a->dec, out != NULL ? out + len : &empty,
+		&final_len);
+}
+
+/*
+ ************************************************
+ * kernel TLS, v3.0 and later built with kTLS
+ ************************************************
+ */
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L && !defined(OPENSSL_NO_KTLS)
+
+const int X_KTLS_SUPPORT = 1;
+
+int X_SSL_get_ktls_send(SSL *ssl) {
+	return BIO_get_ktls_send(SSL_get_wbio(ssl));
+}
+
+int X_SSL_get_ktls_recv(SSL *ssl) {
+	return BIO_get_ktls_recv(SSL_get_rbio(ssl));
+}
+
+X_io_result X_SSL_sendfile(SSL *ssl, int fd, long long offset, size_t size) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.ret = (long)SSL_sendfile(ssl, fd, (off_t)offset, size, 0);
+	x_io_result_end(&r, ssl);
+	return r;
+}
+
+#else
+
+const int X_KTLS_SUPPORT = 0;
+
+int X_SSL_get_ktls_send(SSL *ssl) {
+	return 0;
+}
+
+int X_SSL_get_ktls_recv(SSL *ssl) {
+	return 0;
+}
+
+X_io_result X_SSL_sendfile(SSL *ssl, int fd, long long offset, size_t size) {
+	X_io_result r;
+	x_result_begin(&r.res);
+	r.res.ssl_error = SSL_ERROR_SSL;
+	r.ret = -1;
+	r.eof = 0;
+	return r;
+}
+
+#endif
+
+// The ex data of SSL objects is a handle of the Go SSL struct, released
+// together with the SSL object.
+static void x_ssl_ex_free(void *parent, void *ptr, CRYPTO_EX_DATA *ad,
+		int idx, long argl, void *argp) {
+	if (ptr != NULL) {
+		go_ssl_ex_free(ptr);
+	}
+}
+
+int X_SSL_new_index() {
+	return SSL_get_ex_new_index(0, NULL, NULL, NULL, x_ssl_ex_free);
+}
+
+int X_SSL_verify_cb(int ok, X509_STORE_CTX* store) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	void* p = SSL_get_ex_data(ssl, get_ssl_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	return go_ssl_verify_cb_thunk(p, ok, store);
+}
+
+const SSL_METHOD *X_SSLv23_method() {
+	return SSLv23_method();
+}
+
+const SSL_METHOD *X_SSLv3_method() {
+#ifndef OPENSSL_NO_SSL3_METHOD
+	return SSLv3_method();
+#else
+	return NULL;
+#endif
+}
+
+const SSL_METHOD *X_TLSv1_method() {
+	return TLSv1_method();
+}
+
+const SSL_METHOD *X_TLSv1_1_method() {
+#if defined(TLS1_1_VERSION) && !defined(OPENSSL_SYSNAME_MACOSX)
+	return TLSv1_1_method();
+#else
+	return NULL;
+#endif
+}
+
+const SSL_METHOD *X_TLSv1_2_method() {
+#if defined(TLS1_2_VERSION) && !defined(OPENSSL_SYSNAME_MACOSX)
+	return TLSv1_2_method();
+#else
+	return NULL;
+#endif
+}
+
+SSL_CTX *X_SSL_CTX_new(const SSL_METHOD *method, X_result *res) {
+	SSL_CTX *ctx;
+	x_result_begin(res);
+	ctx = SSL_CTX_new(method);
+	x_result_end(res);
+	return ctx;
+}
+
+int X_SSL_CTX_new_index() {
+	return SSL_CTX_get_ex_new_index(0, NULL, NULL, NULL, NULL);
+}
+
+long X_SSL_CTX_set_options(SSL_CTX* ctx, long options) {
+	return SSL_CTX_set_options(ctx, options);
+}
+
+long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options) {
+	return SSL_CTX_clear_options(ctx, options);
+}
+
+long X_SSL_CTX_get_options(SSL_CTX* ctx) {
+	return SSL_CTX_get_options(ctx);
+}
+
+long X_SSL_CTX_set_mode(SSL_CTX* ctx, long modes) {
+	return SSL_CTX_set_mode(ctx, modes);
+}
+
+long X_SSL_CTX_get_mode(SSL_CTX* ctx) {
+	return SSL_CTX_get_mode(ctx);
+}
+
+long X_SSL_CTX_set_session_cache_mode(SSL_CTX* ctx, long modes) {
+	return SSL_CTX_set_session_cache_mode(ctx, modes);
+}
+
+long X_SSL_CTX_sess_set_cache_size(SSL_CTX* ctx, long t) {
+	return SSL_CTX_sess_set_cache_size(ctx, t);
+}
+
+long X_SSL_CTX_sess_get_cache_size(SSL_CTX* ctx) {
+	return SSL_CTX_sess_get_cache_size(ctx);
+}
+
+void X_SSL_CTX_get_stats(SSL_CTX* ctx, long *stats) {
+	stats[0] = SSL_CTX_sess_number(ctx);
+	stats[1] = SSL_CTX_sess_connect(ctx);
+	stats[2] = SSL_CTX_sess_connect_good(ctx);
+	stats[3] = SSL_CTX_sess_connect_renegotiate(ctx);
+	stats[4] = SSL_CTX_sess_accept(ctx);
+	stats[5] = SSL_CTX_sess_accept_good(ctx);
+	stats[6] = SSL_CTX_sess_accept_renegotiate(ctx);
+	stats[7] = SSL_CTX_sess_hits(ctx);
+	stats[8] = SSL_CTX_sess_cb_hits(ctx);
+	stats[9] = SSL_CTX_sess_misses(ctx);
+	stats[10] = SSL_CTX_sess_timeouts(ctx);
+	stats[11] = SSL_CTX_sess_cache_full(ctx);
+}
+
+long X_SSL_CTX_set_timeout(SSL_CTX* ctx, long t) {
+	return SSL_CTX_set_timeout(ctx, t);
+}
+
+long X_SSL_CTX_get_timeout(SSL_CTX* ctx) {
+	return SSL_CTX_get_timeout(ctx);
+}
+
+long X_SSL_CTX_add_extra_chain_cert(SSL_CTX* ctx, X509 *cert, X_result *res) {
+	long rv;
+	x_result_begin(res);
+	rv = SSL_CTX_add_extra_chain_cert(ctx, cert);
+	x_result_end(res);
+	return rv;
+}
+
+long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key, X_result *res) {
+	long rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set_tmp_ecdh(ctx, key);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_use_certificate(SSL_CTX *ctx, X509 *cert, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_use_certificate(ctx, cert);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_use_PrivateKey(SSL_CTX *ctx, EVP_PKEY *key, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_use_PrivateKey(ctx, key);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_check_private_key(SSL_CTX *ctx, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_check_private_key(ctx);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_use_psk_identity_hint(SSL_CTX *ctx, const char *hint, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_use_psk_identity_hint(ctx, hint);
+	x_result_end(res);
+	return rv;
+}
+
+int X_X509_check_private_key(X509 *cert, EVP_PKEY *key, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = X509_check_private_key(cert, key);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_load_verify_locations(SSL_CTX *ctx, const char *ca_file,
+		const char *ca_path, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_load_verify_locations(ctx, ca_file, ca_path);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_set_session_id_context(SSL_CTX *ctx, const unsigned char *sid,
+		unsigned int len, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set_session_id_context(ctx, sid, len);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_set_cipher_list(SSL_CTX *ctx, const char *list, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set_cipher_list(ctx, list);
+	x_result_end(res);
+	return rv;
+}
+
+int X_SSL_CTX_set_verify_param_flags(SSL_CTX* ctx, unsigned long flags)
+{
+	X509_VERIFY_PARAM* param = X509_VERIFY_PARAM_new();
+	X509_VERIFY_PARAM_set_flags(param, flags);
+	SSL_CTX_set1_param(ctx, param);
+	X509_VERIFY_PARAM_free(param);
+}
+
+long X_SSL_CTX_set_tlsext_servername_callback(
+		SSL_CTX* ctx, int (*cb)(SSL *con, int *ad, void *args)) {
+	return SSL_CTX_set_tlsext_servername_callback(ctx, cb);
+}
+
+int X_SSL_CTX_verify_cb(int ok, X509_STORE_CTX* store) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	return go_ssl_ctx_verify_cb_thunk(p, ok, store);
+}
+
+STACK_OF(X509_CRL)* X_STORE_lookup_crls_cb(X509_STORE_CTX *store, X509_NAME *nm) {
+	SSL* ssl = (SSL *)X509_STORE_CTX_get_ex_data(store,
+			SSL_get_ex_data_X509_STORE_CTX_idx());
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(ssl);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	X509_CRL* crl = go_store_lookup_crls(p, store, nm);
+	if (!crl)
+		return NULL;
+	STACK_OF(X509_CRL) *crls = sk_X509_CRL_new_null();
+	if (!crls)
+        return NULL;
+	sk_X509_CRL_push(crls, crl);
+	return crls;
+}
+
+long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh, X_result *res) {
+	long rv;
+	x_result_begin(res);
+	rv = SSL_CTX_set_tmp_dh(ctx, dh);
+	x_result_end(res);
+	return rv;
+}
+
+long X_PEM_read_DHparams(SSL_CTX* ctx, DH *dh) {
+    return SSL_CTX_set_tmp_dh(ctx, dh);
+}
+
+int X_SSL_CTX_set_tlsext_ticket_key_cb(SSL_CTX *sslctx,
+        int (*cb)(SSL *s, unsigned char key_name[16],
+                  unsigned char iv[EVP_MAX_IV_LENGTH],
+                  EVP_CIPHER_CTX *ctx, HMAC_CTX *hctx, int enc)) {
+    return SSL_CTX_set_tlsext_ticket_key_cb(sslctx, cb);
+}
+
+int X_SSL_CTX_ticket_key_cb(SSL *s, unsigned char key_name[16],
+		unsigned char iv[EVP_MAX_IV_LENGTH],
+		EVP_CIPHER_CTX *cctx, HMAC_CTX *hctx, int enc) {
+
+	SSL_CTX* ssl_ctx = SSL_get_SSL_CTX(s);
+	void* p = SSL_CTX_get_ex_data(ssl_ctx, get_ssl_ctx_idx());
+	// get the pointer to the go Ctx object and pass it back into the thunk
+	return go_ticket_key_cb_thunk(p, s, key_name, iv, cctx, hctx, enc);
+}
+
+int X_BIO_get_flags(BIO *b) {
+	return BIO_get_flags(b);
+}
+
+void X_BIO_set_flags(BIO *b, int flags) {
+	return BIO_set_flags(b, flags);
+}
+
+void X_BIO_clear_flags(BIO *b, int flags) {
+	BIO_clear_flags(b, flags);
+}
+
+int X_BIO_read(BIO *b, void *buf, int len) {
+	return BIO_read(b, buf, len);
+}
+
+int X_BIO_write(BIO *b, const void *buf, int len) {
+	return BIO_write(b, buf, len);
+}
+
+BIO *X_BIO_new_write_bio() {
+	return BIO_new(BIO_s_writeBio());
+}
+
+BIO *X_BIO_new_read_bio() {
+	return BIO_new(BIO_s_readBio());
+}
+
+const EVP_MD *X_EVP_get_digestbyname(const char *name) {
+	return EVP_get_digestbyname(name);
+}
+
+const EVP_MD *X_EVP_md_null() {
+	return EVP_md_null();
+}
+
+const EVP_MD *X_EVP_md5() {
+	return EVP_md5();
+}
+
+const EVP_MD *X_EVP_md4() {
+	return EVP_md4();
+}
+
+const EVP_MD *X_EVP_ripemd160() {
+	return EVP_ripemd160();
+}
+
+const EVP_MD *X_EVP_sha224() {
+	return EVP_sha224();
+}
+
+const EVP_MD *X_EVP_sha1() {
+	return EVP_sha1();
+}
+
+const EVP_MD *X_EVP_sha256() {
+	return EVP_sha256();
+}
+
+const EVP_MD *X_EVP_sha384() {
+	return EVP_sha384();
+}
+
+const EVP_MD *X_EVP_sha512() {
+	return EVP_sha512();
+}
+
+int X_EVP_MD_size(const EVP_MD *md) {
+	return EVP_MD_size(md);
+}
+
+int X_EVP_MD_block_size(const EVP_MD *md) {
+	return EVP_MD_block_size(md);
+}
+
+int X_EVP_MD_type(const EVP_MD *md) {
+	return EVP_MD_type(md);
+}
+
+int X_EVP_MD_CTX_copy_ex(EVP_MD_CTX *out, const EVP_MD_CTX *in) {
+	return EVP_MD_CTX_copy_ex(out, in);
+}
+
+int X_EVP_DigestInit_ex(EVP_MD_CTX *ctx, const EVP_MD *type, ENGINE *impl) {
+	return EVP_DigestInit_ex(ctx, type, impl);
+}
+
+int X_EVP_DigestUpdate(EVP_MD_CTX *ctx, const void *d, size_t cnt) {
+	return EVP_DigestUpdate(ctx, d, cnt);
+}
+
+int X_EVP_DigestFinal_ex(EVP_MD_CTX *ctx, unsigned char *md, unsigned int *s) {
+	return EVP_DigestFinal_ex(ctx, md, s);
+}
+
+int X_EVP_SignInit(EVP_MD_CTX *ctx, const EVP_MD *type) {
+	return EVP_SignInit(ctx, type);
+}
+
+int X_EVP_SignUpdate(EVP_MD_CTX *ctx, const void *d, unsigned int cnt) {
+	return EVP_SignUpdate(ctx, d, cnt);
 }
 
 EVP_PKEY *X_EVP_PKEY_new(void) {
@@ -801,6 +2696,117 @@ int X_SSL_CTX_set1_param(SSL_CTX *ctx, X509_VERIFY_PARAM *vpm) {
 	return SSL_CTX_set1_param(ctx, vpm);
 }
 
+EVP_PKEY *X_PEM_read_PrivateKey(const void *buf, int len,
+		const char *password, X_result *res) {
+	BIO *bio;
+	EVP_PKEY *key = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		key = PEM_read_bio_PrivateKey(bio, NULL, NULL, (void *)password);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return key;
+}
+
+EVP_PKEY *X_d2i_PrivateKey(const void *buf, int len, X_result *res) {
+	BIO *bio;
+	EVP_PKEY *key = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		key = d2i_PrivateKey_bio(bio, NULL);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return key;
+}
+
+EVP_PKEY *X_PEM_read_PUBKEY(const void *buf, int len, X_result *res) {
+	BIO *bio;
+	EVP_PKEY *key = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		key = PEM_read_bio_PUBKEY(bio, NULL, NULL, NULL);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return key;
+}
+
+EVP_PKEY *X_d2i_PUBKEY(const void *buf, int len, X_result *res) {
+	BIO *bio;
+	EVP_PKEY *key = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		key = d2i_PUBKEY_bio(bio, NULL);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return key;
+}
+
+X509 *X_PEM_read_X509(const void *buf, int len, X_result *res) {
+	BIO *bio;
+	X509 *cert = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		cert = PEM_read_bio_X509(bio, NULL, NULL, NULL);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return cert;
+}
+
+X509 *X_d2i_X509(const void *buf, int len, X_result *res) {
+	BIO *bio;
+	X509 *cert = NULL;
+	x_result_begin(res);
+	bio = BIO_new_mem_buf((void *)buf, len);
+	if (bio) {
+		cert = d2i_X509_bio(bio, NULL);
+		BIO_free(bio);
+	}
+	x_result_end(res);
+	return cert;
+}
+
+int X_X509_STORE_add_cert(X509_STORE *store, X509 *cert, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = X509_STORE_add_cert(store, cert);
+	x_result_end(res);
+	return rv;
+}
+
+int X_X509_STORE_add_crl(X509_STORE *store, X509_CRL *crl, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = X509_STORE_add_crl(store, crl);
+	x_result_end(res);
+	return rv;
+}
+
+int X_X509_STORE_set_flags(X509_STORE *store, unsigned long flags, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = X509_STORE_set_flags(store, flags);
+	x_result_end(res);
+	return rv;
+}
+
+int X_FIPS_mode_set(int mode, X_result *res) {
+	int rv;
+	x_result_begin(res);
+	rv = FIPS_mode_set(mode);
+	x_result_end(res);
+	return rv;
+}
+
 int X_sk_X509_num(STACK_OF(X509) *sk) {
 	return sk_X509_num(sk);
 }
@@ -809,6 +2815,18 @@ X509 *X_sk_X509_value(STACK_OF(X509)* sk, int i) {
    return sk_X509_value(sk, i);
 }
 
+void X_sk_SSL_CIPHER_free(STACK_OF(SSL_CIPHER) *sk) {
+	sk_SSL_CIPHER_free(sk);
+}
+
+int X_sk_SSL_CIPHER_num(STACK_OF(SSL_CIPHER) *sk) {
+	return sk_SSL_CIPHER_num(sk);
+}
+
+const SSL_CIPHER *X_sk_SSL_CIPHER_value(STACK_OF(SSL_CIPHER) *sk, int i) {
+	return sk_SSL_CIPHER_value(sk, i);
+}
+
 int X_sk_DIST_POINT_num(CRL_DIST_POINTS *crldp) {
 	return sk_DIST_POINT_num(crldp);
 }
@@ -15,6 +15,7 @@
  *
  */
 
+#include <stdint.h>
 #include <stdlib.h>
 #include <string.h>
 
@@ -37,6 +38,119 @@
 #define SSL_OP_NO_COMPRESSION 0
 #endif
 
+#ifndef TLS1_3_VERSION
+#define TLS1_3_VERSION 0x0304
+#endif
+
+#ifndef SSL_OP_ENABLE_KTLS
+#define SSL_OP_ENABLE_KTLS 0
+#endif
+
+#ifndef SSL_EXT_CLIENT_HELLO
+#define SSL_EXT_TLS_ONLY 0x0001
+#define SSL_EXT_IGNORE_ON_RESUMPTION 0x0040
+#define SSL_EXT_CLIENT_HELLO 0x0080
+#define SSL_EXT_TLS1_2_SERVER_HELLO 0x0100
+#define SSL_EXT_TLS1_3_SERVER_HELLO 0x0200
+#define SSL_EXT_TLS1_3_ENCRYPTED_EXTENSIONS 0x0400
+#define SSL_EXT_TLS1_3_HELLO_RETRY_REQUEST 0x0800
+#define SSL_EXT_TLS1_3_CERTIFICATE 0x1000
+#define SSL_EXT_TLS1_3_NEW_SESSION_TICKET 0x2000
+#define SSL_EXT_TLS1_3_CERTIFICATE_REQUEST 0x4000
+#endif
+
+#ifndef TLSEXT_comp_cert_zlib
+#define TLSEXT_comp_cert_zlib 1
+#define TLSEXT_comp_cert_brotli 2
+#define TLSEXT_comp_cert_zstd 3
+#endif
+
+#ifndef TLSEXT_cert_type_rpk
+#define TLSEXT_cert_type_x509 0
+#define TLSEXT_cert_type_rpk 2
+#endif
+
+#if OPENSSL_VERSION_NUMBER >= 0x30000000L
+#include <openssl/proverr.h>
+#endif
+
+#ifndef ERR_LIB_PROV
+#define ERR_LIB_PROV 57
+#define PROV_R_BAD_DECRYPT 100
+#endif
+
+// the values of OpenSSL 1.1.0, the SecurityOp constants have to stay distinct
+#ifndef SSL_SECOP_PEER
+#define SSL_SECOP_PEER 0x1000
+#define SSL_SECOP_CIPHER_SUPPORTED (1 | (1 << 16))
+#define SSL_SECOP_CIPHER_SHARED (2 | (1 << 16))
+#define SSL_SECOP_CIPHER_CHECK (3 | (1 << 16))
+#define SSL_SECOP_CURVE_SUPPORTED (4 | (2 << 16))
+#define SSL_SECOP_CURVE_SHARED (5 | (2 << 16))
+#define SSL_SECOP_CURVE_CHECK (6 | (2 << 16))
+#define SSL_SECOP_TMP_DH (7 | (4 << 16))
+#define SSL_SECOP_VERSION 9
+#define SSL_SECOP_TICKET 10
+#define SSL_SECOP_SIGALG_SUPPORTED (11 | (5 << 16))
+#define SSL_SECOP_SIGALG_SHARED (12 | (5 << 16))
+#define SSL_SECOP_SIGALG_CHECK (13 | (5 << 16))
+#define SSL_SECOP_SIGALG_MASK (14 | (5 << 16))
+#define SSL_SECOP_COMPRESSION 15
+#define SSL_SECOP_EE_KEY (16 | (6 << 16))
+#define SSL_SECOP_CA_KEY (17 | (6 << 16))
+#define SSL_SECOP_CA_MD (18 | (6 << 16))
+#endif
+
+/*
+ * Error state of a single call, captured by the shim in the same C call as the
+ * operation itself. OpenSSL keeps its error queue (and the C library errno)
+ * per OS thread, so capturing it here means Go does not need to pin the
+ * calling goroutine with runtime.LockOSThread.
+ */
+#ifndef X_RESULT_DEFINED
+#define X_RESULT_DEFINED
+
+#define X_ERR_QUEUE_MAX 16
+#define X_ERR_STRS_MAX 512
+
+/*
+ * The drained error queue. It is only allocated when the call left errors,
+ * so that the result itself stays small.
+ */
+typedef struct X_err_queue {
+	unsigned long codes[X_ERR_QUEUE_MAX];
+	int lines[X_ERR_QUEUE_MAX];
+	/*
+	 * file names and data of the entries are copied into strs, since OpenSSL
+	 * frees the data with the queue. -1 if absent or strs is full.
+	 */
+	short file_offs[X_ERR_QUEUE_MAX];
+	short data_offs[X_ERR_QUEUE_MAX];
+	int strs_len;
+	char strs[X_ERR_STRS_MAX];
+} X_err_queue;
+
+typedef struct X_result {
+	int ssl_error;
+	int sys_errno;
+	int nerrs;
+	/* NULL if nerrs is 0, released with X_err_queue_free */
+	X_err_queue *errs;
+} X_result;
+
+/*
+ * X_io_result is returned by value by the SSL I/O shims, so that the hot path
+ * passes no Go pointer to C and needs no allocation. eof is set if ret and
+ * the error mean that the transport reached EOF.
+ */
+typedef struct X_io_result {
+	long ret;
+	int eof;
+	X_result res;
+} X_io_result;
+
+#endif
+
 /* shim  methods */
 extern int X_shim_init();
 
@@ -45,12 +159,65 @@ extern void X_OPENSSL_free(void *ref);
 extern void *X_OPENSSL_malloc(size_t size);
 
 /* SSL methods */
+extern SSL *X_SSL_new(SSL_CTX *ctx, X_result *res);
+extern void X_err_queue_free(X_err_queue *errs);
+extern X_io_result X_SSL_do_handshake(SSL *ssl);
+extern X_io_result X_SSL_read(SSL *ssl, void *buf, int num);
+extern X_io_result X_SSL_write(SSL *ssl, const void *buf, int num);
+extern X_io_result X_SSL_shutdown(SSL *ssl);
+extern SSL_SESSION *X_d2i_SSL_SESSION(const unsigned char *der, long len, X_result *res);
+extern int X_SSL_set_session(SSL *ssl, SSL_SESSION *session, X_result *res);
+extern int X_SSL_set_fd(SSL *ssl, int fd, X_result *res);
+extern int X_ERR_GET_LIB(unsigned long code);
+extern int X_ERR_GET_REASON(unsigned long code);
+extern int X_handshake_info_init();
+extern void X_SSL_track_handshake(SSL *ssl);
+extern int X_SSL_get_fatal_alert(SSL *ssl, int sent);
+extern const char *X_SSL_get_handshake_state(SSL *ssl);
+extern int X_SSL_get_renegotiations(SSL *ssl);
+extern const int X_KTLS_SUPPORT;
+extern int X_SSL_get_ktls_send(SSL *ssl);
+extern int X_SSL_get_ktls_recv(SSL *ssl);
+extern const int X_CERT_COMP_SUPPORT;
+extern int X_cert_comp_init();
+extern int X_cert_comp_available(int alg);
+extern void X_SSL_CTX_track_cert_comp(SSL_CTX *ctx);
+extern int X_SSL_CTX_set_cert_comp(SSL_CTX *ctx, int *algs, size_t len, X_result *res);
+extern int X_SSL_CTX_compress_certs(SSL_CTX *ctx, int alg, X_result *res);
+extern int X_SSL_peer_cert_compressed(SSL *ssl);
+extern const int X_RPK_SUPPORT;
+extern int X_SSL_CTX_set1_server_cert_type(SSL_CTX *ctx, const unsigned char *val, size_t len, X_result *res);
+extern int X_SSL_CTX_set1_client_cert_type(SSL_CTX *ctx, const unsigned char *val, size_t len, X_result *res);
+extern int X_SSL_get_negotiated_server_cert_type(SSL *ssl);
+extern int X_SSL_get_negotiated_client_cert_type(SSL *ssl);
+extern EVP_PKEY *X_SSL_get1_peer_rpk(SSL *ssl);
+extern EVP_PKEY *X_X509_STORE_CTX_get1_rpk(X509_STORE_CTX *ctx);
+extern X_io_result X_SSL_sendfile(SSL *ssl, int fd, long long offset, size_t size);
 extern long X_SSL_set_options(SSL* ssl, long options);
 extern long X_SSL_get_options(SSL* ssl);
 extern long X_SSL_clear_options(SSL* ssl, long options);
-extern long X_SSL_set_tlsext_host_name(SSL *ssl, const char *name);
+extern long X_SSL_set_tlsext_host_name(SSL *ssl, const char *name, X_result *res);
 extern const char * X_SSL_get_cipher_name(const SSL *ssl);
+extern STACK_OF(SSL_CIPHER) *X_SSL_get1_supported_ciphers(SSL *ssl);
+extern const SSL_CIPHER *X_SSL_CIPHER_find(SSL *ssl, int id);
+extern const int X_SIGALGS_SUPPORT;
+extern int X_SSL_CTX_set1_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res);
+extern int X_SSL_CTX_set1_client_sigalgs_list(SSL_CTX *ctx, const char *list, X_result *res);
+extern int X_SSL_get_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code);
+extern int X_SSL_get_shared_sigalgs(SSL *ssl, int idx, int *sign, int *hash, int *code);
+extern const int X_GROUPS_SUPPORT;
+extern int X_SSL_CTX_set1_groups_list(SSL_CTX *ctx, const char *list, X_result *res);
+extern const int X_NEGOTIATED_GROUP_SUPPORT;
+extern const char *X_SSL_get_negotiated_group_name(SSL *ssl);
+extern const char *X_SSL_CIPHER_standard_name(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_get_protocol_id(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_is_aead(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_get_kx_nid(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_get_auth_nid(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_get_cipher_nid(const SSL_CIPHER *c);
+extern int X_SSL_CIPHER_get_digest_nid(const SSL_CIPHER *c);
 extern int X_SSL_session_reused(SSL *ssl);
+extern int X_SSL_is_init_finished(SSL *ssl);
 extern int X_SSL_new_index();
 
 extern const SSL_METHOD *X_SSLv23_method();
@@ -64,7 +231,17 @@ extern int sni_cb(SSL *ssl_conn, int *ad, void *arg);
 #endif
 extern int X_SSL_verify_cb(int ok, X509_STORE_CTX* store);
 
+/* PSK methods */
+extern const int X_TLS13_PSK_SUPPORT;
+extern int X_psk_init();
+extern void X_SSL_CTX_set_psk_client_callback(SSL_CTX *ctx, int enable);
+extern void X_SSL_CTX_set_psk_server_callback(SSL_CTX *ctx, int enable);
+extern void X_SSL_CTX_set_psk_use_session_callback(SSL_CTX *ctx, int enable);
+extern void X_SSL_CTX_set_psk_find_session_callback(SSL_CTX *ctx, int enable);
+extern int X_SSL_CTX_use_psk_identity_hint(SSL_CTX *ctx, const char *hint, X_result *res);
+
 /* SSL_CTX methods */
+extern SSL_CTX *X_SSL_CTX_new(const SSL_METHOD *method, X_result *res);
 extern int X_SSL_CTX_new_index();
 extern long X_SSL_CTX_set_options(SSL_CTX* ctx, long options);
 extern long X_SSL_CTX_clear_options(SSL_CTX* ctx, long options);
@@ -74,13 +251,32 @@ extern long X_SSL_CTX_get_mode(SSL_CTX* ctx);
 extern long X_SSL_CTX_set_session_cache_mode(SSL_CTX* ctx, long modes);
 extern long X_SSL_CTX_sess_set_cache_size(SSL_CTX* ctx, long t);
 extern long X_SSL_CTX_sess_get_cache_size(SSL_CTX* ctx);
+extern void X_SSL_CTX_get_stats(SSL_CTX* ctx, long *stats);
 extern long X_SSL_CTX_set_timeout(SSL_CTX* ctx, long t);
 extern long X_SSL_CTX_get_timeout(SSL_CTX* ctx);
-extern long X_SSL_CTX_add_extra_chain_cert(SSL_CTX* ctx, X509 *cert);
-extern long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key);
+extern long X_SSL_CTX_add_extra_chain_cert(SSL_CTX* ctx, X509 *cert, X_result *res);
+extern long X_SSL_CTX_set_tmp_ecdh(SSL_CTX* ctx, EC_KEY *key, X_result *res);
+extern int X_SSL_CTX_use_certificate(SSL_CTX *ctx, X509 *cert, X_result *res);
+extern int X_SSL_CTX_use_PrivateKey(SSL_CTX *ctx, EVP_PKEY *key, X_result *res);
+extern int X_SSL_CTX_check_private_key(SSL_CTX *ctx, X_result *res);
+extern const int X_CERT_CHAIN_SUPPORT;
+extern long X_SSL_CTX_add1_chain_cert(SSL_CTX *ctx, X509 *cert, X_result *res);
+extern long X_SSL_CTX_clear_chain_certs(SSL_CTX *ctx);
+extern const int X_CERT_AND_KEY_SUPPORT;
+extern int X_SSL_CTX_use_cert_and_key(SSL_CTX *ctx, X509 *cert, EVP_PKEY *key, X509 **chain, int nchain, X_result *res);
+extern const int X_SECURITY_LEVEL_SUPPORT;
+extern void X_SSL_CTX_set_security_callback(SSL_CTX *ctx, int enable);
+extern void X_SSL_CTX_set_security_level(SSL_CTX *ctx, int level);
+extern int X_SSL_CTX_get_security_level(SSL_CTX *ctx);
+extern void X_SSL_set_security_level(SSL *ssl, int level);
+extern int X_SSL_get_security_level(SSL *ssl);
+extern int X_X509_check_private_key(X509 *cert, EVP_PKEY *key, X_result *res);
+extern int X_SSL_CTX_load_verify_locations(SSL_CTX *ctx, const char *ca_file, const char *ca_path, X_result *res);
+extern int X_SSL_CTX_set_session_id_context(SSL_CTX *ctx, const unsigned char *sid, unsigned int len, X_result *res);
+extern int X_SSL_CTX_set_cipher_list(SSL_CTX *ctx, const char *list, X_result *res);
 extern long X_SSL_CTX_set_tlsext_servername_callback(SSL_CTX* ctx, int (*cb)(SSL *con, int *ad, void *args));
 extern int X_SSL_CTX_verify_cb(int ok, X509_STORE_CTX* store);
-extern long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh);
+extern long X_SSL_CTX_set_tmp_dh(SSL_CTX* ctx, DH *dh, X_result *res);
 
 extern X509_VERIFY_PARAM* X_X509_VERIFY_PARAM_new();
 extern void X_X509_VERIFY_PARAM_free(X509_VERIFY_PARAM *param);
@@ -111,6 +307,9 @@ extern BIO *X_BIO_new_read_bio();
 
 /* EVP methods */
 extern const int X_ED25519_SUPPORT;
+extern const int X_CUSTOM_EXT_SUPPORT;
+extern int X_SSL_CTX_add_custom_ext(SSL_CTX *ctx, unsigned int ext_type,
+		unsigned int context, int id, X_result *res);
 extern int X_EVP_PKEY_ED25519;
 extern const EVP_MD *X_EVP_get_digestbyname(const char *name);
 extern EVP_MD_CTX *X_EVP_MD_CTX_new();
@@ -128,6 +327,9 @@ extern const EVP_MD *X_EVP_sha256();
 extern const EVP_MD *X_EVP_sha384();
 extern const EVP_MD *X_EVP_sha512();
 extern int X_EVP_MD_size(const EVP_MD *md);
+extern int X_EVP_MD_block_size(const EVP_MD *md);
+extern int X_EVP_MD_type(const EVP_MD *md);
+extern int X_EVP_MD_CTX_copy_ex(EVP_MD_CTX *out, const EVP_MD_CTX *in);
 extern int X_EVP_DigestInit_ex(EVP_MD_CTX *ctx, const EVP_MD *type, ENGINE *impl);
 extern int X_EVP_DigestUpdate(EVP_MD_CTX *ctx, const void *d, size_t cnt);
 extern int X_EVP_DigestFinal_ex(EVP_MD_CTX *ctx, unsigned char *md, unsigned int *s);
@@ -159,6 +361,67 @@ extern const EVP_CIPHER *X_EVP_CIPHER_CTX_cipher(EVP_CIPHER_CTX *ctx);
 extern int X_EVP_CIPHER_CTX_encrypting(const EVP_CIPHER_CTX *ctx);
 extern int X_EVP_PKEY_CTX_set_ec_paramgen_curve_nid(EVP_PKEY_CTX *ctx, int nid);
 
+/* low-level digest states */
+#define X_MD_STATE_MARSHALED_MAX 204
+extern size_t X_md_state_size(int nid);
+extern int X_md_state_allowed(void);
+extern int X_md_state_init(int nid, void *state);
+extern int X_md_state_update(int nid, void *state, const void *data, size_t len);
+extern int X_md_state_sum(int nid, const void *state, unsigned char *md);
+extern int X_md_state_marshal(int nid, const void *state, unsigned char *out);
+extern int X_md_state_unmarshal(int nid, void *state, const unsigned char *in, size_t len);
+
+/* extendable-output functions */
+extern const int X_XOF_SUPPORT;
+extern const int X_XOF_SQUEEZE_SUPPORT;
+extern int X_EVP_MD_is_xof(const EVP_MD *md);
+extern int X_EVP_DigestFinalXOF(EVP_MD_CTX *ctx, unsigned char *md, size_t len);
+extern int X_EVP_DigestSqueeze(EVP_MD_CTX *ctx, unsigned char *out, size_t len);
+
+/* message authentication codes */
+typedef struct x_mac_st X_MAC;
+extern const int X_EVP_MAC_SUPPORT;
+extern X_MAC *X_MAC_new(const char *alg, const unsigned char *key, size_t key_len, const EVP_MD *md, const EVP_CIPHER *cipher, const unsigned char *iv, size_t iv_len, const unsigned char *custom, size_t custom_len, size_t size, X_result *res);
+extern void X_MAC_free(X_MAC *m);
+extern X_MAC *X_MAC_dup(X_MAC *m);
+extern size_t X_MAC_size(X_MAC *m);
+extern int X_MAC_reset(X_MAC *m);
+extern int X_MAC_update(X_MAC *m, const void *data, size_t len);
+extern int X_MAC_sum(X_MAC *m, unsigned char *out, size_t out_size);
+
+/* key derivation functions */
+#ifndef X_KDF_PARAMS_DEFINED
+#define X_KDF_PARAMS_DEFINED
+typedef struct X_KDF_params {
+	const EVP_MD *md;
+	int mode;
+	const unsigned char *key;
+	size_t key_len;
+	const unsigned char *salt;
+	size_t salt_len;
+	const unsigned char *info;
+	size_t info_len;
+	uint64_t iter;
+	uint64_t n;
+	uint32_t r;
+	uint32_t p;
+	uint32_t lanes;
+	uint32_t memcost;
+} X_KDF_params;
+#endif
+extern const int X_KDF_SUPPORT;
+extern const int X_EVP_KDF_SUPPORT;
+extern const int X_ARGON2_SUPPORT;
+extern int X_KDF_derive(const char *alg, X_KDF_params *params, unsigned char *out, size_t out_len, X_result *res);
+
+/* authenticated encryption */
+typedef struct x_aead_st X_AEAD;
+extern const int X_AEAD_GCM_SIV_SUPPORT;
+extern X_AEAD *X_AEAD_new(const char *name, const unsigned char *key, size_t key_len, size_t nonce_len, size_t tag_len, X_result *res);
+extern void X_AEAD_free(X_AEAD *a);
+extern int X_AEAD_seal(X_AEAD *a, const unsigned char *nonce, const unsigned char *ad, size_t ad_len, const unsigned char *in, size_t in_len, unsigned char *out, unsigned char *tag);
+extern int X_AEAD_open(X_AEAD *a, const unsigned char *nonce, const unsigned char *ad, size_t ad_len, const unsigned char *in, size_t in_len, unsigned char *out, const unsigned char *tag);
+
 /* HMAC methods */
 extern size_t X_HMAC_size(const HMAC_CTX *e);
 extern HMAC_CTX *X_HMAC_CTX_new(void);
@@ -168,11 +431,23 @@ extern int X_HMAC_Update(HMAC_CTX *ctx, const unsigned char *data, size_t len);
 extern int X_HMAC_Final(HMAC_CTX *ctx, unsigned char *md, unsigned int *len);
 
 /* X509 methods */
+extern X509 *X_PEM_read_X509(const void *buf, int len, X_result *res);
+extern EVP_PKEY *X_PEM_read_PrivateKey(const void *buf, int len, const char *password, X_result *res);
+extern EVP_PKEY *X_d2i_PrivateKey(const void *buf, int len, X_result *res);
+extern EVP_PKEY *X_PEM_read_PUBKEY(const void *buf, int len, X_result *res);
+extern EVP_PKEY *X_d2i_PUBKEY(const void *buf, int len, X_result *res);
+extern X509 *X_d2i_X509(const void *buf, int len, X_result *res);
+extern int X_X509_STORE_add_cert(X509_STORE *store, X509 *cert, X_result *res);
+extern int X_X509_STORE_add_crl(X509_STORE *store, X509_CRL *crl, X_result *res);
+extern int X_X509_STORE_set_flags(X509_STORE *store, unsigned long flags, X_result *res);
 extern int X_X509_add_ref(X509* x509);
 extern const ASN1_TIME *X_X509_get0_notBefore(const X509 *x);
 extern const ASN1_TIME *X_X509_get0_notAfter(const X509 *x);
 extern int X_sk_X509_num(STACK_OF(X509) *sk);
 extern X509 *X_sk_X509_value(STACK_OF(X509)* sk, int i);
+extern void X_sk_SSL_CIPHER_free(STACK_OF(SSL_CIPHER) *sk);
+extern int X_sk_SSL_CIPHER_num(STACK_OF(SSL_CIPHER) *sk);
+extern const SSL_CIPHER *X_sk_SSL_CIPHER_value(STACK_OF(SSL_CIPHER) *sk, int i);
 extern long X_X509_get_version(const X509 *x);
 extern int X_X509_set_version(X509 *x, long version);
 extern STACK_OF(X509_CRL) *X_sk_X509_CRL_new_null();
@@ -181,6 +456,7 @@ extern ASN1_TIME *X_X509_CRL_get_nextUpdate(X509_CRL *crl);
 extern X509* X_get_issuer(X509_STORE_CTX *ctx);
 
 /* misc methods */
+extern int X_FIPS_mode_set(int mode, X_result *res);
 extern int X_sk_DIST_POINT_num(STACK_OF(DIST_POINT) *crldp);
 extern DIST_POINT* X_sk_DIST_POINT_value(STACK_OF(DIST_POINT) *crldp, int i);
 extern GENERAL_NAMES *X_get_general_name(DIST_POINT* dp);
@@ -0,0 +1,165 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+var sigalgs_support = C.X_SIGALGS_SUPPORT != 0
+
+// sigalg_names maps the crypto/tls signature schemes to the names OpenSSL
+// 1.1.1 and later accept in signature algorithm lists.
+var sigalg_names = map[tls.SignatureScheme]string{
+	tls.PKCS1WithSHA256:        "rsa_pkcs1_sha256",
+	tls.PKCS1WithSHA384:        "rsa_pkcs1_sha384",
+	tls.PKCS1WithSHA512:        "rsa_pkcs1_sha512",
+	tls.PSSWithSHA256:          "rsa_pss_rsae_sha256",
+	tls.PSSWithSHA384:          "rsa_pss_rsae_sha384",
+	tls.PSSWithSHA512:          "rsa_pss_rsae_sha512",
+	tls.ECDSAWithP256AndSHA256: "ecdsa_secp256r1_sha256",
+	tls.ECDSAWithP384AndSHA384: "ecdsa_secp384r1_sha384",
+	tls.ECDSAWithP521AndSHA512: "ecdsa_secp521r1_sha512",
+	tls.Ed25519:                "ed25519",
+	tls.PKCS1WithSHA1:          "rsa_pkcs1_sha1",
+	tls.ECDSAWithSHA1:          "ecdsa_sha1",
+	0x0809:                     "rsa_pss_pss_sha256",
+	0x080a:                     "rsa_pss_pss_sha384",
+	0x080b:                     "rsa_pss_pss_sha512",
+	0x0808:                     "ed448",
+}
+
+// SignatureAlgorithm is a signature algorithm of the TLS handshake.
+type SignatureAlgorithm struct {
+	// Scheme is the TLS code point, which is also the value of the
+	// crypto/tls SignatureScheme.
+	Scheme tls.SignatureScheme
+	// Name is the name SetSigAlgs accepts, such as "rsa_pss_rsae_sha256",
+	// or empty for schemes this package doesn't know.
+	Name string
+	// Sign is the public key algorithm, such as NID_rsaEncryption.
+	Sign NID
+	// Hash is the digest, NID_undef for schemes that don't have a separate
+	// one, such as Ed25519.
+	Hash NID
+}
+
+// TLSSigAlgs returns the names, for SetSigAlgs and SetClientSigAlgs, of the
+// given crypto/tls signature schemes.
+func TLSSigAlgs(schemes []tls.SignatureScheme) ([]string, error) {
+	names := make([]string, 0, len(schemes))
+	for _, scheme := range schemes {
+		name, ok := sigalg_names[scheme]
+		if !ok {
+			return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func sigAlgsList(algs []string) (*C.char, error) {
+	if !sigalgs_support {
+		return nil, errors.New("signature algorithm lists require OpenSSL " +
+			"1.0.2")
+	}
+	if len(algs) == 0 {
+		return nil, errors.New("no signature algorithms provided")
+	}
+	return C.CString(strings.Join(algs, ":")), nil
+}
+
+// SetSigAlgs sets the signature algorithms offered and accepted for the
+// handshake signatures, in order of preference. Algorithms are named as
+// OpenSSL 1.1.1 and later name them, such as "rsa_pss_rsae_sha256",
+// "ecdsa_secp256r1_sha256" or "ed25519", see TLSSigAlgs. Requires OpenSSL
+// 1.0.2 or later. See
+// https://www.openssl.org/docs/man1.1.1/man3/SSL_CTX_set1_sigalgs_list.html
+func (c *Ctx) SetSigAlgs(algs []string) error {
+	clist, err := sigAlgsList(algs)
+	if err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(clist))
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_set1_sigalgs_list(c.ctx, clist, &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+// SetClientSigAlgs sets the signature algorithms a server accepts for client
+// certificates, or a client offers for its own, see SetSigAlgs.
+func (c *Ctx) SetClientSigAlgs(algs []string) error {
+	clist, err := sigAlgsList(algs)
+	if err != nil {
+		return err
+	}
+	defer C.free(unsafe.Pointer(clist))
+	var res C.X_result
+	defer releaseResult(&res)
+	if int(C.X_SSL_CTX_set1_client_sigalgs_list(c.ctx, clist, &res)) != 1 {
+		return errorFromResult(&res)
+	}
+	return nil
+}
+
+func loadSigAlgs(get func(idx C.int, sign, hash, code *C.int) C.int) (
+	rv []SignatureAlgorithm) {
+
+	var sign, hash, code C.int
+	num := int(get(0, &sign, &hash, &code))
+	rv = make([]SignatureAlgorithm, 0, num)
+	for i := 0; i < num; i++ {
+		get(C.int(i), &sign, &hash, &code)
+		alg := SignatureAlgorithm{
+			Scheme: tls.SignatureScheme(code),
+			Name:   sigalg_names[tls.SignatureScheme(code)],
+			Sign:   NID(sign),
+			Hash:   NID(hash)}
+		rv = append(rv, alg)
+	}
+	return rv
+}
+
+// PeerSignatureAlgorithms returns the signature algorithms the peer offered:
+// on a server those of the client hello, on a client those of the server's
+// certificate request. Only valid after a handshake.
+func (c *Conn) PeerSignatureAlgorithms() []SignatureAlgorithm {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return loadSigAlgs(func(idx C.int, sign, hash, code *C.int) C.int {
+		return C.X_SSL_get_sigalgs(c.ssl, idx, sign, hash, code)
+	})
+}
+
+// SharedSignatureAlgorithms returns the signature algorithms both the peer
+// and this side accept, in order of preference. Only valid after a
+// handshake.
+func (c *Conn) SharedSignatureAlgorithms() []SignatureAlgorithm {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return loadSigAlgs(func(idx C.int, sign, hash, code *C.int) C.int {
+		return C.X_SSL_get_shared_sigalgs(c.ssl, idx, sign, hash, code)
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func sigAlgsServer(t *testing.T, server_ctx *Ctx, client HandshakingConn,
+	server_conn net.Conn) (*Conn, error) {
+	server, err := Server(server_conn, server_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Handshake()
+		client.Close()
+	}()
+	err = server.Handshake()
+	if err != nil {
+		server.Close()
+		<-errs
+		return nil, err
+	}
+	<-errs
+	return server, nil
+}
+
+func newSigAlgsServerCtx(t *testing.T, sigalgs []string) *Ctx {
+	ctx, err := NewCtxFromKeys(certBytes, keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetSigAlgs(sigalgs); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestSharedSignatureAlgorithms(t *testing.T) {
+	ctx := newSigAlgsServerCtx(t, []string{"rsa_pss_rsae_sha256"})
+	server_conn, client_conn := NetPipe(t)
+	server, err := sigAlgsServer(t, ctx,
+		tls.Client(client_conn, &tls.Config{InsecureSkipVerify: true}),
+		server_conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	peer := server.PeerSignatureAlgorithms()
+	found := false
+	for _, alg := range peer {
+		found = found || alg.Scheme == tls.PSSWithSHA256
+	}
+	if len(peer) < 2 || !found {
+		t.Fatalf("unexpected peer signature algorithms %v", peer)
+	}
+	shared := server.SharedSignatureAlgorithms()
+	if len(shared) != 1 || shared[0].Scheme != tls.PSSWithSHA256 ||
+		shared[0].Name != "rsa_pss_rsae_sha256" ||
+		shared[0].Sign != NID_rsassaPss || shared[0].Hash != NID_sha256 {
+		t.Fatalf("unexpected shared signature algorithms %v", shared)
+	}
+}
+
+func TestSetSigAlgsMismatch(t *testing.T) {
+	// the server's rsa key can't make any of the allowed signatures
+	names, err := TLSSigAlgs([]tls.SignatureScheme{
+		tls.ECDSAWithP256AndSHA256, tls.Ed25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := newSigAlgsServerCtx(t, names)
+	server_conn, client_conn := NetPipe(t)
+	_, err = sigAlgsServer(t, ctx,
+		tls.Client(client_conn, &tls.Config{InsecureSkipVerify: true}),
+		server_conn)
+	if err == nil {
+		t.Fatal("expected the handshake to fail")
+	}
+}
+
+func TestSetClientSigAlgs(t *testing.T) {
+	ctx := newSigAlgsServerCtx(t, []string{"rsa_pss_rsae_sha256"})
+	if err := ctx.SetClientSigAlgs([]string{"ed25519"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetVerify(VerifyPeer, func(ok bool, store *CertificateStoreCtx) bool {
+		return true
+	})
+	client_ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server_conn, client_conn := NetPipe(t)
+	client, err := Client(client_conn, client_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := sigAlgsServer(t, ctx, client, server_conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	// a client learns the accepted algorithms from the certificate request
+	peer := client.PeerSignatureAlgorithms()
+	if len(peer) != 1 || peer[0].Scheme != tls.Ed25519 ||
+		peer[0].Name != "ed25519" {
+		t.Fatalf("unexpected peer signature algorithms %v", peer)
+	}
+}
+
+func TestTLSSigAlgs(t *testing.T) {
+	names, err := TLSSigAlgs([]tls.SignatureScheme{
+		tls.PSSWithSHA256, tls.ECDSAWithP384AndSHA384})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "rsa_pss_rsae_sha256" ||
+		names[1] != "ecdsa_secp384r1_sha384" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if _, err := TLSSigAlgs([]tls.SignatureScheme{0xfefe}); err == nil {
+		t.Fatal("expected an unknown scheme to fail")
+	}
+	ctx, err := NewCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetSigAlgs([]string{"no_such_sigalg"}); err == nil {
+		t.Fatal("expected an unknown signature algorithm to fail")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// UnknownNamePolicy decides how an SNIRouter handles a server name that no
+// registered host matches.
+type UnknownNamePolicy int
+
+const (
+	// FallbackToDefault serves unknown names with the default context.
+	FallbackToDefault UnknownNamePolicy = iota
+	// RejectUnknownName aborts the handshake with an unrecognized_name
+	// alert. Clients that don't send a server name at all still get the
+	// default context.
+	RejectUnknownName
+)
+
+// SNIRouter selects the context of a server connection by the server name the
+// client sent (SNI, rfc6066). Hosts are registered either exactly, such as
+// "example.com", or with a wildcard for a single leftmost label, such as
+// "*.example.com", which matches "www.example.com" but neither "example.com"
+// nor "a.b.example.com". Exact hosts take precedence over wildcards.
+//
+// Handshakes start on the default context, which carries the router's
+// servername callback. OpenSSL then only takes the certificates, keys and
+// session id context from the selected context; all other settings, such as
+// options and the verification mode, are those of the default context.
+//
+// An SNIRouter is safe for concurrent use, hosts may be added and removed
+// while it is serving.
+type SNIRouter struct {
+	default_ctx *Ctx
+
+	mtx      sync.RWMutex
+	exact    map[string]*Ctx
+	wildcard map[string]*Ctx // by the part after "*."
+	policy   UnknownNamePolicy
+}
+
+// NewSNIRouter creates a router that serves clients without a known server
+// name with default_ctx, and installs its servername callback on it,
+// replacing any callback set with SetTLSExtServernameCallback.
+func NewSNIRouter(default_ctx *Ctx) (*SNIRouter, error) {
+	if default_ctx == nil {
+		return nil, errors.New("no default ssl context provided")
+	}
+	r := &SNIRouter{
+		default_ctx: default_ctx,
+		exact:       make(map[string]*Ctx),
+		wildcard:    make(map[string]*Ctx)}
+	default_ctx.SetTLSExtServernameCallback(r.serverName)
+	return r, nil
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Add registers ctx for host, replacing the context previously registered for
+// it. host is either a hostname or a wildcard "*." followed by a hostname.
+func (r *SNIRouter) Add(host string, ctx *Ctx) error {
+	if ctx == nil {
+		return errors.New("no ssl context provided")
+	}
+	name := normalizeHost(host)
+	table := r.exact
+	if strings.HasPrefix(name, "*.") {
+		name = name[2:]
+		table = r.wildcard
+	}
+	if name == "" || strings.Contains(name, "*") {
+		return fmt.Errorf("invalid sni host pattern %q", host)
+	}
+	r.mtx.Lock()
+	table[name] = ctx
+	r.mtx.Unlock()
+	return nil
+}
+
+// Remove unregisters host, which must be given as it was passed to Add.
+func (r *SNIRouter) Remove(host string) {
+	host = normalizeHost(host)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if strings.HasPrefix(host, "*.") {
+		delete(r.wildcard, host[2:])
+	} else {
+		delete(r.exact, host)
+	}
+}
+
+// SetUnknownNamePolicy sets how server names without a registered host are
+// handled. The default is FallbackToDefault.
+func (r *SNIRouter) SetUnknownNamePolicy(policy UnknownNamePolicy) {
+	r.mtx.Lock()
+	r.policy = policy
+	r.mtx.Unlock()
+}
+
+// Lookup returns the context a client sending server name name is served
+// with, or nil if the handshake is rejected.
+func (r *SNIRouter) Lookup(name string) *Ctx {
+	name = normalizeHost(name)
+	if name == "" {
+		return r.default_ctx
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if ctx, ok := r.exact[name]; ok {
+		return ctx
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if ctx, ok := r.wildcard[name[i+1:]]; ok {
+			return ctx
+		}
+	}
+	if r.policy == RejectUnknownName {
+		return nil
+	}
+	return r.default_ctx
+}
+
+func (r *SNIRouter) serverName(s *SSL) SSLTLSExtErr {
+	ctx := r.Lookup(s.GetServername())
+	if ctx == nil {
+		// OpenSSL sends unrecognized_name unless told otherwise
+		return SSLTLSEXTErrAlertFatal
+	}
+	if ctx != r.default_ctx {
+		s.SetSSLCtx(ctx)
+	}
+	return SSLTLSExtErrOK
+}
+
+// NewListener wraps inner such that accepted connections are routed by r.
+func (r *SNIRouter) NewListener(inner net.Listener) net.Listener {
+	return NewListener(inner, r.default_ctx)
+}
+
+// NewListenerWithConfig is like NewListener, but handshakes connections as
+// configured by config, see the package level NewListenerWithConfig.
+func (r *SNIRouter) NewListenerWithConfig(inner net.Listener,
+	config ListenerConfig) net.Listener {
+	return NewListenerWithConfig(inner, r.default_ctx, config)
+}
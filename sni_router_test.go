@@ -0,0 +1,197 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSNITestCtx(t *testing.T, serial int64) (*Ctx, string) {
+	pair := newTestKeyPair(t, serial, 24*time.Hour)
+	ctx, err := NewCtxFromKeys(pair.cert_pem, pair.key_pem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, fmt.Sprint(serial)
+}
+
+func newSNITestRouter(t *testing.T) (*SNIRouter, net.Listener) {
+	default_ctx, _ := newSNITestCtx(t, 1)
+	r, err := NewSNIRouter(default_ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact_ctx, _ := newSNITestCtx(t, 2)
+	if err := r.Add("www.example.com", exact_ctx); err != nil {
+		t.Fatal(err)
+	}
+	wildcard_ctx, _ := newSNITestCtx(t, 3)
+	if err := r.Add("*.example.com", wildcard_ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp_listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := r.NewListenerWithConfig(tcp_listener, ListenerConfig{
+		HandshakeTimeout: 5 * time.Second})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return r, l
+}
+
+// sniServes returns the serial of the certificate served for server_name.
+func sniServes(t *testing.T, l net.Listener, server_name string) (
+	string, error) {
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := tls.Client(conn, &tls.Config{
+		ServerName:         server_name,
+		InsecureSkipVerify: true})
+	defer client.Close()
+	err = client.Handshake()
+	if err != nil {
+		return "", err
+	}
+	return client.ConnectionState().PeerCertificates[0].SerialNumber.String(),
+		nil
+}
+
+func TestSNIRouter(t *testing.T) {
+	_, l := newSNITestRouter(t)
+	defer l.Close()
+
+	tests := []struct {
+		server_name string
+		serial      string
+	}{
+		{"www.example.com", "2"},
+		{"WWW.Example.com", "2"},
+		{"api.example.com", "3"},
+		{"a.b.example.com", "1"},
+		{"example.com", "1"},
+		{"other.org", "1"},
+		{"", "1"},
+	}
+	for _, test := range tests {
+		serial, err := sniServes(t, l, test.server_name)
+		if err != nil {
+			t.Fatalf("%q: %s", test.server_name, err)
+		}
+		if serial != test.serial {
+			t.Errorf("%q: served certificate %s, expected %s",
+				test.server_name, serial, test.serial)
+		}
+	}
+}
+
+func TestSNIRouterRejectUnknownName(t *testing.T) {
+	r, l := newSNITestRouter(t)
+	defer l.Close()
+	r.SetUnknownNamePolicy(RejectUnknownName)
+
+	_, err := sniServes(t, l, "other.org")
+	if err == nil || !strings.Contains(err.Error(), "unrecognized name") {
+		t.Fatalf("expected an unrecognized_name alert, got %v", err)
+	}
+	// clients that don't send a name are not rejected
+	serial, err := sniServes(t, l, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "1" {
+		t.Fatalf("served certificate %s to a client without sni", serial)
+	}
+	serial, err = sniServes(t, l, "api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "3" {
+		t.Fatalf("served certificate %s to a wildcard host", serial)
+	}
+}
+
+func TestSNIRouterInvalidHost(t *testing.T) {
+	ctx, _ := newSNITestCtx(t, 1)
+	r, err := NewSNIRouter(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"", "*.", "*", "a.*.example.com"} {
+		if err := r.Add(host, ctx); err == nil {
+			t.Errorf("%q: expected an error", host)
+		}
+	}
+}
+
+func TestSNIRouterConcurrentUpdates(t *testing.T) {
+	r, l := newSNITestRouter(t)
+	defer l.Close()
+	ctx, _ := newSNITestCtx(t, 4)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			r.Add("new.example.org", ctx)
+			r.Remove("new.example.org")
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		serial, err := sniServes(t, l, "new.example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serial != "1" && serial != "4" {
+			t.Fatalf("served certificate %s", serial)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if err := r.Add("new.example.org", ctx); err != nil {
+		t.Fatal(err)
+	}
+	serial, err := sniServes(t, l, "new.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != "4" {
+		t.Fatalf("served certificate %s after Add", serial)
+	}
+}
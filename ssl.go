@@ -33,6 +33,8 @@ const (
 
 var (
 	ssl_idx = C.X_SSL_new_index()
+	// sslMapping holds the SSL structs attached to SSL objects
+	sslMapping = newMapping()
 )
 
 //export get_ssl_idx
@@ -43,6 +45,32 @@ func get_ssl_idx() C.int {
 type SSL struct {
 	ssl       *C.SSL
 	verify_cb VerifyCallback
+	ctx       *Ctx // for gc, set by SetSSLCtx
+	peer_exts map[uint16][]byte
+}
+
+// attach stores a handle of s in the ex data of its SSL object, so that
+// callbacks find it. The handle is released when the SSL object is freed.
+func (s *SSL) attach() {
+	t := sslMapping.Add(unsafe.Pointer(s))
+	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), unsafe.Pointer(t))
+}
+
+// attachedSSL returns the SSL struct attached to con, or nil.
+func attachedSSL(con *C.SSL) *SSL {
+	return sslFromHandle(C.SSL_get_ex_data(con, get_ssl_idx()))
+}
+
+func sslFromHandle(p unsafe.Pointer) *SSL {
+	if p == nil {
+		return nil
+	}
+	return (*SSL)(sslMapping.Get(token(p)))
+}
+
+//export go_ssl_ex_free
+func go_ssl_ex_free(p unsafe.Pointer) {
+	sslMapping.Del(token(p))
 }
 
 //export go_ssl_verify_cb_thunk
@@ -53,7 +81,7 @@ func go_ssl_verify_cb_thunk(p unsafe.Pointer, ok C.int, ctx *C.X509_STORE_CTX) C
 			os.Exit(1)
 		}
 	}()
-	verify_cb := (*SSL)(p).verify_cb
+	verify_cb := sslFromHandle(p).verify_cb
 	// set up defaults just in case verify_cb is nil
 	if verify_cb != nil {
 		store := &CertificateStoreCtx{ctx: ctx}
@@ -148,6 +176,16 @@ func (s *SSL) SetSSLCtx(ctx *Ctx) {
 	 * adjust other things we care about
 	 */
 	C.SSL_set_SSL_CTX(s.ssl, ctx.ctx)
+	s.ctx = ctx
+}
+
+// callbackSSL returns the SSL struct of a connection created by this package,
+// or a temporary one for connections created elsewhere.
+func callbackSSL(con *C.SSL) *SSL {
+	if s := attachedSSL(con); s != nil {
+		return s
+	}
+	return &SSL{ssl: con}
 }
 
 //export sni_cb_thunk
@@ -161,9 +199,15 @@ func sni_cb_thunk(p unsafe.Pointer, con *C.SSL, ad unsafe.Pointer, arg unsafe.Po
 
 	sni_cb := (*Ctx)(p).sni_cb
 
-	s := &SSL{ssl: con}
-	// This attaches a pointer to our SSL struct into the SNI callback.
-	C.SSL_set_ex_data(s.ssl, get_ssl_idx(), unsafe.Pointer(s))
+	// Connections created by this package already carry their SSL struct,
+	// which must stay attached so that it is not collected and its verify
+	// callback keeps working.
+	s := attachedSSL(con)
+	if s == nil {
+		s = &SSL{ssl: con}
+		// This attaches our SSL struct into the SNI callback.
+		s.attach()
+	}
 
 	// Note: this is ctx.sni_cb, not C.sni_cb
 	return C.int(sni_cb(s))
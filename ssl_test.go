@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
@@ -325,6 +326,38 @@ func ThroughputBenchmark(b *testing.B, constructor func(
 	b.StopTimer()
 }
 
+// SmallRecordBenchmark sends one small record per Write, so that the cost of
+// each call, rather than encryption, dominates.
+func SmallRecordBenchmark(b *testing.B, constructor func(
+	t testing.TB, conn1, conn2 net.Conn) (sslconn1, sslconn2 HandshakingConn)) {
+	server_conn, client_conn := NetPipe(b)
+	defer server_conn.Close()
+	defer client_conn.Close()
+
+	server, client := constructor(b, server_conn, client_conn)
+	defer close_both(server, client)
+
+	const size = 16
+	b.SetBytes(size)
+	b.ReportAllocs()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.CopyN(ioutil.Discard, server, int64(b.N*size))
+		done <- err
+	}()
+	data := make([]byte, size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+}
+
 func StdlibConstructor(t testing.TB, server_conn, client_conn net.Conn) (
 	server, client HandshakingConn) {
 	cert, err := tls.X509KeyPair(certBytes, keyBytes)
@@ -431,6 +464,14 @@ func BenchmarkOpenSSLThroughput(b *testing.B) {
 	ThroughputBenchmark(b, OpenSSLConstructor)
 }
 
+func BenchmarkStdlibSmallRecords(b *testing.B) {
+	SmallRecordBenchmark(b, StdlibConstructor)
+}
+
+func BenchmarkOpenSSLSmallRecords(b *testing.B) {
+	SmallRecordBenchmark(b, OpenSSLConstructor)
+}
+
 func TestStdlibOpenSSLSimple(t *testing.T) {
 	SimpleConnTest(t, StdlibOpenSSLConstructor)
 }
@@ -668,3 +709,32 @@ func TestOpenSSLLotsOfConns(t *testing.T) {
 			return Client(c, ctx)
 		})
 }
+
+func TestOpenSSLHandshakeFailureAlert(t *testing.T) {
+	server_conn, client_conn := NetPipe(t)
+	defer client_conn.Close()
+
+	// the server only has AES128-SHA
+	server, _ := OpenSSLConstructor(t, server_conn, client_conn)
+	errs := make(chan error, 1)
+	go func() {
+		err := server.Handshake()
+		// the alert has to be out by the time Handshake returns
+		server_conn.Close()
+		errs <- err
+	}()
+	client := tls.Client(client_conn, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	err := client.Handshake()
+	if <-errs == nil {
+		t.Fatal("expected the server handshake to fail")
+	}
+	var oerr *net.OpError
+	if !errors.As(err, &oerr) || oerr.Op != "remote error" {
+		t.Fatalf("expected the alert of the server, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"fmt"
+	"hash"
+	"runtime"
+	"unsafe"
+)
+
+// StdHash adapts a message digest to hash.Hash. Unlike Hash, Sum appends to
+// its argument and leaves the state unchanged, so more data can be written
+// afterwards.
+//
+// Hashing always goes through EVP, so engines and providers, including the
+// FIPS one, apply. EVP keeps digest states opaque, MarshalBinary and
+// UnmarshalBinary only work on hashes from NewMarshalableStdHash.
+type StdHash struct {
+	digest     *Digest
+	engine     *Engine
+	name       string
+	nid        NID
+	size       int
+	block_size int
+	ctx        *C.EVP_MD_CTX
+	// state is the C allocated low-level state of nid, it replaces ctx in
+	// hashes from NewMarshalableStdHash
+	state unsafe.Pointer
+}
+
+var _ hash.Hash = (*StdHash)(nil)
+
+// NewStdHash returns a hash.Hash for the digest with the given name, as
+// accepted by GetDigestByName. e may be nil.
+func NewStdHash(name string, e *Engine) (*StdHash, error) {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return newStdHash(e, digest)
+}
+
+// NewStdHashFromDigest returns a hash.Hash for digest.
+func NewStdHashFromDigest(digest *Digest) (*StdHash, error) {
+	return newStdHash(nil, digest)
+}
+
+// NewMarshalableStdHash returns a hash.Hash for the MD5, SHA1 or SHA224 to
+// SHA512 digest with the given name whose state implements
+// encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, in the format of
+// the standard library. States can be saved to resume hashing later, also
+// with the crypto package.
+//
+// The hash runs on the low-level implementation of OpenSSL instead of EVP,
+// bypassing engines and providers. It is refused in FIPS mode.
+func NewMarshalableStdHash(name string) (*StdHash, error) {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newStdHashInfo(nil, digest)
+	if err != nil {
+		return nil, err
+	}
+	size := C.X_md_state_size(C.int(h.nid))
+	if size == 0 {
+		return nil, fmt.Errorf("openssl: %s: hash state cannot be marshaled",
+			h.name)
+	}
+	if C.X_md_state_allowed() != 1 {
+		return nil, fmt.Errorf("openssl: %s: marshalable hashes are not "+
+			"allowed in FIPS mode", h.name)
+	}
+	runtime.SetFinalizer(h, func(h *StdHash) { h.Close() })
+	h.state = C.malloc(size)
+	if h.state == nil {
+		return nil, fmt.Errorf("openssl: %s: unable to allocate state", name)
+	}
+	if err := h.reset(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// StdHashFunc returns a constructor of hash.Hash values for the digest with
+// the given name, as needed by crypto/hmac.New.
+func StdHashFunc(name string) (func() hash.Hash, error) {
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := newStdHash(nil, digest); err != nil {
+		return nil, err
+	}
+	return func() hash.Hash {
+		h, err := newStdHash(nil, digest)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	}, nil
+}
+
+func newStdHashInfo(e *Engine, digest *Digest) (*StdHash, error) {
+	name, err := digest.ShortName()
+	if err != nil {
+		return nil, err
+	}
+	return &StdHash{
+		digest:     digest,
+		engine:     e,
+		name:       name,
+		nid:        digest.Nid(),
+		size:       digest.Size(),
+		block_size: digest.BlockSize(),
+	}, nil
+}
+
+func newStdHash(e *Engine, digest *Digest) (*StdHash, error) {
+	h, err := newStdHashInfo(e, digest)
+	if err != nil {
+		return nil, err
+	}
+	runtime.SetFinalizer(h, func(h *StdHash) { h.Close() })
+	h.ctx = C.X_EVP_MD_CTX_new()
+	if h.ctx == nil {
+		return nil, fmt.Errorf("openssl: %s: unable to allocate ctx", h.name)
+	}
+	if err := h.reset(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close releases the state. The hash must not be used afterwards.
+func (h *StdHash) Close() {
+	if h.ctx != nil {
+		C.X_EVP_MD_CTX_free(h.ctx)
+		h.ctx = nil
+	}
+	if h.state != nil {
+		C.free(h.state)
+		h.state = nil
+	}
+}
+
+func (h *StdHash) reset() error {
+	var ok bool
+	if h.state != nil {
+		ok = C.X_md_state_init(C.int(h.nid), h.state) == 1
+	} else {
+		ok = C.X_EVP_DigestInit_ex(h.ctx, h.digest.ptr,
+			engineRef(h.engine)) == 1
+	}
+	if !ok {
+		return fmt.Errorf("openssl: %s: cannot init digest ctx", h.name)
+	}
+	return nil
+}
+
+// Reset resets the hash to its initial state.
+func (h *StdHash) Reset() {
+	if err := h.reset(); err != nil {
+		panic(err)
+	}
+}
+
+// Write adds p to the hash. It never returns an error.
+func (h *StdHash) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var ok bool
+	if h.state != nil {
+		ok = C.X_md_state_update(C.int(h.nid), h.state,
+			unsafe.Pointer(&p[0]), C.size_t(len(p))) == 1
+	} else {
+		ok = C.X_EVP_DigestUpdate(h.ctx, unsafe.Pointer(&p[0]),
+			C.size_t(len(p))) == 1
+	}
+	if !ok {
+		panic(fmt.Sprintf("openssl: %s: cannot update digest", h.name))
+	}
+	return len(p), nil
+}
+
+// Sum appends the current hash to b and returns the result. The state is
+// left unchanged.
+func (h *StdHash) Sum(b []byte) []byte {
+	sum := make([]byte, h.size)
+	if h.state != nil {
+		if C.X_md_state_sum(C.int(h.nid), h.state,
+			(*C.uchar)(unsafe.Pointer(&sum[0]))) != 1 {
+			panic(fmt.Sprintf("openssl: %s: cannot finalize digest", h.name))
+		}
+		return append(b, sum...)
+	}
+	ctx := C.X_EVP_MD_CTX_new()
+	if ctx == nil {
+		panic(fmt.Sprintf("openssl: %s: unable to allocate ctx", h.name))
+	}
+	defer C.X_EVP_MD_CTX_free(ctx)
+	if C.X_EVP_MD_CTX_copy_ex(ctx, h.ctx) != 1 ||
+		C.X_EVP_DigestFinal_ex(ctx, (*C.uchar)(unsafe.Pointer(&sum[0])),
+			nil) != 1 {
+		panic(fmt.Sprintf("openssl: %s: cannot finalize digest", h.name))
+	}
+	return append(b, sum...)
+}
+
+func (h *StdHash) Size() int {
+	return h.size
+}
+
+func (h *StdHash) BlockSize() int {
+	return h.block_size
+}
+
+// Name returns the short name of the digest.
+func (h *StdHash) Name() string {
+	return h.name
+}
+
+// Clone returns an independent copy of the hash in its current state.
+func (h *StdHash) Clone() (*StdHash, error) {
+	c := &StdHash{}
+	*c = *h
+	c.ctx, c.state = nil, nil
+	runtime.SetFinalizer(c, func(c *StdHash) { c.Close() })
+	if h.state != nil {
+		size := C.X_md_state_size(C.int(h.nid))
+		c.state = C.malloc(size)
+		if c.state == nil {
+			return nil, fmt.Errorf("openssl: %s: unable to allocate state",
+				h.name)
+		}
+		C.memcpy(c.state, h.state, size)
+		return c, nil
+	}
+	c.ctx = C.X_EVP_MD_CTX_new()
+	if c.ctx == nil {
+		return nil, fmt.Errorf("openssl: %s: unable to allocate ctx", h.name)
+	}
+	if C.X_EVP_MD_CTX_copy_ex(c.ctx, h.ctx) != 1 {
+		return nil, fmt.Errorf("openssl: %s: cannot copy digest ctx", h.name)
+	}
+	return c, nil
+}
+
+// MarshalBinary returns the current state of the hash, which must come from
+// NewMarshalableStdHash.
+func (h *StdHash) MarshalBinary() ([]byte, error) {
+	if h.state == nil {
+		return nil, fmt.Errorf("openssl: %s: hash state cannot be marshaled",
+			h.name)
+	}
+	out := make([]byte, C.X_MD_STATE_MARSHALED_MAX)
+	n := C.X_md_state_marshal(C.int(h.nid), h.state,
+		(*C.uchar)(unsafe.Pointer(&out[0])))
+	return out[:n], nil
+}
+
+// UnmarshalBinary restores a state returned by MarshalBinary, or by the
+// hash of the standard library for the same digest. The hash must come from
+// NewMarshalableStdHash.
+func (h *StdHash) UnmarshalBinary(data []byte) error {
+	if h.state == nil {
+		return fmt.Errorf("openssl: %s: hash state cannot be marshaled",
+			h.name)
+	}
+	if len(data) == 0 || C.X_md_state_unmarshal(C.int(h.nid), h.state,
+		(*C.uchar)(unsafe.Pointer(&data[0])), C.size_t(len(data))) != 1 {
+		return fmt.Errorf("openssl: %s: invalid hash state", h.name)
+	}
+	return nil
+}
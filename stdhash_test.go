@@ -0,0 +1,187 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding"
+	"hash"
+	"testing"
+)
+
+var stdHashTests = []struct {
+	name        string
+	new         func() hash.Hash
+	marshalable bool
+}{
+	{"MD5", md5.New, true},
+	{"SHA1", sha1.New, true},
+	{"SHA224", sha256.New224, true},
+	{"SHA256", sha256.New, true},
+	{"SHA384", sha512.New384, true},
+	{"SHA512", sha512.New, true},
+	{"SHA512-256", sha512.New512_256, false},
+	{"SHA3-256", func() hash.Hash { return sha3.New256() }, false},
+}
+
+func TestStdHash(t *testing.T) {
+	for _, test := range stdHashTests {
+		h, err := NewStdHash(test.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSHA2(t, func(data []byte) {
+			expected := test.new()
+			expected.Write(data)
+			h.Reset()
+			h.Write(data[:len(data)/2])
+			// Sum must not change the state
+			h.Sum(nil)
+			h.Write(data[len(data)/2:])
+			got := h.Sum([]byte("prefix"))
+			if !bytes.Equal(got, expected.Sum([]byte("prefix"))) {
+				t.Fatalf("%s: exp:%x got:%x", test.name, expected.Sum(nil),
+					got)
+			}
+		})
+		if h.Size() != test.new().Size() ||
+			h.BlockSize() != test.new().BlockSize() {
+			t.Fatalf("%s: size %d/%d", test.name, h.Size(), h.BlockSize())
+		}
+		h.Close()
+	}
+}
+
+func TestStdHashHMAC(t *testing.T) {
+	new_hash, err := StdHashFunc("SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("hmac key")
+	data := []byte("hmac data")
+	mac := hmac.New(new_hash, key)
+	mac.Write(data)
+	expected := hmac.New(sha256.New, key)
+	expected.Write(data)
+	if !hmac.Equal(mac.Sum(nil), expected.Sum(nil)) {
+		t.Fatalf("exp:%x got:%x", expected.Sum(nil), mac.Sum(nil))
+	}
+	if _, err := StdHashFunc("NO-SUCH-DIGEST"); err == nil {
+		t.Fatal("expected an error for an unknown digest")
+	}
+}
+
+func TestStdHashClone(t *testing.T) {
+	for _, name := range []string{"SHA256", "SHA3-256"} {
+		h, err := NewStdHash(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte("common prefix"))
+		c, err := h.Clone()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte("one"))
+		c.Write([]byte("two"))
+
+		expected, err := NewStdHash(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected.Write([]byte("common prefixtwo"))
+		if !bytes.Equal(c.Sum(nil), expected.Sum(nil)) {
+			t.Fatalf("%s: clone does not continue from the state", name)
+		}
+		if bytes.Equal(h.Sum(nil), c.Sum(nil)) {
+			t.Fatalf("%s: clone shares the state", name)
+		}
+	}
+}
+
+func TestStdHashMarshal(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	for _, test := range stdHashTests {
+		plain, err := NewStdHash(test.name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := plain.MarshalBinary(); err == nil {
+			t.Fatalf("%s: expected an error without opt-in", test.name)
+		}
+		h, err := NewMarshalableStdHash(test.name)
+		if !test.marshalable {
+			if err == nil {
+				t.Fatalf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// stop in the middle of a block
+		h.Write(data[:333])
+		plain.Write(data[:333])
+		if !bytes.Equal(h.Sum(nil), plain.Sum(nil)) {
+			t.Fatalf("%s: marshalable hash differs from EVP", test.name)
+		}
+		state, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := test.new()
+		expected.Write(data)
+
+		// resume in the standard library
+		std := test.new()
+		if err := std.(encoding.BinaryUnmarshaler).UnmarshalBinary(
+			state); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		std.Write(data[333:])
+		if !bytes.Equal(std.Sum(nil), expected.Sum(nil)) {
+			t.Fatalf("%s: stdlib resumed to a different sum", test.name)
+		}
+
+		// resume here from the standard library
+		std = test.new()
+		std.Write(data[:333])
+		state, err = std.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resumed, err := NewMarshalableStdHash(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resumed.UnmarshalBinary(state); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		resumed.Write(data[333:])
+		if !bytes.Equal(resumed.Sum(nil), expected.Sum(nil)) {
+			t.Fatalf("%s: resumed to a different sum", test.name)
+		}
+
+		if err := resumed.UnmarshalBinary(state[:len(state)-1]); err == nil {
+			t.Fatalf("%s: expected a truncated state to fail", test.name)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+// #include "shim.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+var (
+	xof_support         = C.X_XOF_SUPPORT != 0
+	xof_squeeze_support = C.X_XOF_SQUEEZE_SUPPORT != 0
+)
+
+// XOF is an extendable-output function such as SHAKE128 or SHAKE256. Data is
+// absorbed with Write, output of any length is squeezed with Read. Once Read
+// was called, Write fails until Reset.
+//
+// Before OpenSSL 3.3 the output can only be computed at once, so reads
+// recompute it from the absorbed state, holding all the output read so far.
+type XOF struct {
+	ctx       *C.EVP_MD_CTX
+	digest    *Digest
+	engine    *Engine
+	name      string
+	squeezing bool
+	// out is the output computed ahead and read the number of bytes
+	// returned from it, without EVP_DigestSqueeze
+	out  []byte
+	read int
+}
+
+// NewXOF returns an XOF for the digest with the given name, as accepted by
+// GetDigestByName. e may be nil.
+func NewXOF(name string, e *Engine) (*XOF, error) {
+	if !xof_support {
+		return nil, errors.New("openssl: XOFs are not supported")
+	}
+	digest, err := GetDigestByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if C.X_EVP_MD_is_xof(digest.ptr) == 0 {
+		return nil, fmt.Errorf("openssl: %s is not an XOF", name)
+	}
+	x := &XOF{
+		digest: digest,
+		engine: e,
+		name:   name,
+	}
+	if err := x.alloc(); err != nil {
+		return nil, err
+	}
+	if err := x.Reset(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// NewSHAKE128 returns SHAKE128 as defined by FIPS 202.
+func NewSHAKE128() (*XOF, error) {
+	return NewXOF("SHAKE128", nil)
+}
+
+// NewSHAKE256 returns SHAKE256 as defined by FIPS 202.
+func NewSHAKE256() (*XOF, error) {
+	return NewXOF("SHAKE256", nil)
+}
+
+// SumSHAKE128 returns length bytes of the SHAKE128 output for data.
+func SumSHAKE128(data []byte, length int) ([]byte, error) {
+	return sumXOF("SHAKE128", data, length)
+}
+
+// SumSHAKE256 returns length bytes of the SHAKE256 output for data.
+func SumSHAKE256(data []byte, length int) ([]byte, error) {
+	return sumXOF("SHAKE256", data, length)
+}
+
+func sumXOF(name string, data []byte, length int) ([]byte, error) {
+	x, err := NewXOF(name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer x.Close()
+	if _, err := x.Write(data); err != nil {
+		return nil, err
+	}
+	out := make([]byte, length)
+	if _, err := x.Read(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (x *XOF) alloc() error {
+	x.ctx = C.X_EVP_MD_CTX_new()
+	if x.ctx == nil {
+		return fmt.Errorf("openssl: %s: unable to allocate ctx", x.name)
+	}
+	runtime.SetFinalizer(x, func(x *XOF) { x.Close() })
+	return nil
+}
+
+// Close releases the state. The XOF must not be used afterwards.
+func (x *XOF) Close() {
+	if x.ctx != nil {
+		C.X_EVP_MD_CTX_free(x.ctx)
+		x.ctx = nil
+	}
+}
+
+// Reset discards the absorbed data and the output state.
+func (x *XOF) Reset() error {
+	if 1 != C.X_EVP_DigestInit_ex(x.ctx, x.digest.ptr, engineRef(x.engine)) {
+		return fmt.Errorf("openssl: %s: cannot init digest ctx", x.name)
+	}
+	x.squeezing = false
+	x.out = nil
+	x.read = 0
+	return nil
+}
+
+// Write absorbs p.
+func (x *XOF) Write(p []byte) (n int, err error) {
+	if x.squeezing {
+		return 0, fmt.Errorf("openssl: %s: write after read", x.name)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if 1 != C.X_EVP_DigestUpdate(x.ctx, unsafe.Pointer(&p[0]),
+		C.size_t(len(p))) {
+		return 0, fmt.Errorf("openssl: %s: cannot update digest", x.name)
+	}
+	return len(p), nil
+}
+
+// Read squeezes the next len(p) bytes of output. It always fills p.
+func (x *XOF) Read(p []byte) (n int, err error) {
+	x.squeezing = true
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if xof_squeeze_support {
+		if 1 != C.X_EVP_DigestSqueeze(x.ctx,
+			(*C.uchar)(unsafe.Pointer(&p[0])), C.size_t(len(p))) {
+			return 0, fmt.Errorf("openssl: %s: cannot squeeze", x.name)
+		}
+		return len(p), nil
+	}
+	end := x.read + len(p)
+	if end > len(x.out) {
+		// grow geometrically to keep recomputing amortized
+		length := 2 * len(x.out)
+		if length < end {
+			length = end
+		}
+		out, err := x.finalCopy(length)
+		if err != nil {
+			return 0, err
+		}
+		x.out = out
+	}
+	copy(p, x.out[x.read:end])
+	x.read = end
+	return len(p), nil
+}
+
+// finalCopy computes length bytes of output from a copy of the state.
+func (x *XOF) finalCopy(length int) ([]byte, error) {
+	ctx := C.X_EVP_MD_CTX_new()
+	if ctx == nil {
+		return nil, fmt.Errorf("openssl: %s: unable to allocate ctx", x.name)
+	}
+	defer C.X_EVP_MD_CTX_free(ctx)
+	if 1 != C.X_EVP_MD_CTX_copy_ex(ctx, x.ctx) {
+		return nil, fmt.Errorf("openssl: %s: cannot copy digest ctx", x.name)
+	}
+	out := make([]byte, length)
+	if 1 != C.X_EVP_DigestFinalXOF(ctx, (*C.uchar)(unsafe.Pointer(&out[0])),
+		C.size_t(length)) {
+		return nil, fmt.Errorf("openssl: %s: cannot finalize digest", x.name)
+	}
+	return out, nil
+}
+
+// Clone returns an independent copy of the XOF in its current state.
+func (x *XOF) Clone() (*XOF, error) {
+	c := &XOF{
+		digest:    x.digest,
+		engine:    x.engine,
+		name:      x.name,
+		squeezing: x.squeezing,
+		out:       x.out,
+		read:      x.read,
+	}
+	if err := c.alloc(); err != nil {
+		return nil, err
+	}
+	if 1 != C.X_EVP_MD_CTX_copy_ex(c.ctx, x.ctx) {
+		return nil, fmt.Errorf("openssl: %s: cannot copy digest ctx", x.name)
+	}
+	return c, nil
+}
+
+// BlockSize returns the rate of the XOF in bytes.
+func (x *XOF) BlockSize() int {
+	return x.digest.BlockSize()
+}
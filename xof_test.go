@@ -0,0 +1,138 @@
+// Copyright (C) 2017. See AUTHORS.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package openssl
+
+import (
+	"bytes"
+	"crypto/sha3"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func newTestXOF(t *testing.T, name string) *XOF {
+	if !xof_support {
+		t.Skip("XOFs are not supported")
+	}
+	x, err := NewXOF(name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestXOFVectors(t *testing.T) {
+	if !xof_support {
+		t.Skip("XOFs are not supported")
+	}
+	// outputs for the empty message from FIPS 202
+	got, err := SumSHAKE128(nil, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26"
+	if hex.EncodeToString(got) != expected {
+		t.Fatalf("SHAKE128: exp:%s got:%x", expected, got)
+	}
+	got, err = SumSHAKE256(nil, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762f" +
+		"d75dc4ddd8c0f200cb05019d67b592f6fc821c49479ab48640292eacb3b7c4be"
+	if hex.EncodeToString(got) != expected {
+		t.Fatalf("SHAKE256: exp:%s got:%x", expected, got)
+	}
+}
+
+func TestXOFStdlib(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() *sha3.SHAKE
+	}{
+		{"SHAKE128", sha3.NewSHAKE128},
+		{"SHAKE256", sha3.NewSHAKE256},
+	}
+	for _, test := range tests {
+		x := newTestXOF(t, test.name)
+		testSHA2(t, func(data []byte) {
+			if err := x.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			expected := test.new()
+			expected.Write(data)
+			if _, err := x.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			// squeeze in uneven parts crossing the rate
+			for _, n := range []int{1, 31, 200, 7, 1000} {
+				want := make([]byte, n)
+				expected.Read(want)
+				got := make([]byte, n)
+				if _, err := io.ReadFull(x, got); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(want, got) {
+					t.Fatalf("%s: exp:%x got:%x", test.name, want, got)
+				}
+			}
+		})
+		x.Close()
+	}
+}
+
+func TestXOFWriteAfterRead(t *testing.T) {
+	x := newTestXOF(t, "SHAKE128")
+	defer x.Close()
+	x.Write([]byte("absorbed"))
+	x.Read(make([]byte, 16))
+	if _, err := x.Write([]byte("more")); err == nil {
+		t.Fatal("expected write after read to fail")
+	}
+	if err := x.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.Write([]byte("more")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXOFClone(t *testing.T) {
+	x := newTestXOF(t, "SHAKE256")
+	defer x.Close()
+	x.Write([]byte("absorbed"))
+	first := make([]byte, 10)
+	x.Read(first)
+	c, err := x.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a, b := make([]byte, 100), make([]byte, 100)
+	x.Read(a)
+	c.Read(b)
+	if !bytes.Equal(a, b) {
+		t.Fatal("clone squeezed a different output")
+	}
+}
+
+func TestXOFNotXOF(t *testing.T) {
+	if !xof_support {
+		t.Skip("XOFs are not supported")
+	}
+	if _, err := NewXOF("SHA256", nil); err == nil {
+		t.Fatal("expected SHA256 to be rejected")
+	}
+}